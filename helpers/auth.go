@@ -0,0 +1,56 @@
+package H
+
+import (
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// userIDContextKey clave del contexto donde se guarda el usuario autenticado de la petición
+const userIDContextKey = "mg_user_id"
+
+// GetUserID uuid del usuario autenticado: JWT firmado con JWT_SECRET (claim uuid) en Authorization, Token
+// o access_token, los mismos lugares que lee el limitador. Vacío si la petición es anónima o el token no es válido
+func GetUserID(c echo.Context) string {
+	if userID, ok := c.Get(userIDContextKey).(string); ok {
+		return userID
+	}
+
+	userID := ""
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		for _, token := range []string{
+			c.Request().Header.Get("Authorization"),
+			c.Request().Header.Get("Token"),
+			c.QueryParam("access_token"),
+		} {
+			if userID = verifiedTokenUUID(token, secret); userID != "" {
+				break
+			}
+		}
+	}
+	c.Set(userIDContextKey, userID)
+	return userID
+}
+
+// verifiedTokenUUID valida la firma y vigencia del JWT ("Bearer <jwt>" o solo el jwt) y devuelve su claim uuid
+func verifiedTokenUUID(token, secret string) string {
+	parts := strings.Fields(token)
+	if len(parts) == 0 {
+		return ""
+	}
+
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(parts[len(parts)-1], claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+	if err != nil || !parsed.Valid {
+		return ""
+	}
+
+	if uuid, ok := claims["uuid"].(string); ok && !IsEmpty(uuid) {
+		return uuid
+	}
+	return ""
+}
@@ -22,8 +22,9 @@ type UserCache struct {
 }
 
 type CacheSessions struct {
-	mu    sync.Mutex
-	users map[string]*UserCache
+	mu     sync.Mutex
+	users  map[string]*UserCache
+	shared []*UserCache
 }
 
 var cache CacheSessions
@@ -48,6 +49,9 @@ func runCleanup() {
 		}
 	}
 	ParallelMapWorker(&cache.users, 4, runCleanupUser)
+	for _, sharedCache := range cache.shared {
+		runCleanupUser(sharedCache)
+	}
 }
 
 func cacheCleanup() {
@@ -78,6 +82,19 @@ func GetCacheSession(user_uuid string) *UserCache {
 	return &sessionCache
 }
 
+// NewCache caché propio, fuera de las sesiones: no se descarta por inactividad ni comparte claves con ellas,
+// y la limpieza periódica borra sus entradas vencidas
+func NewCache() *UserCache {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	sharedCache := &UserCache{
+		cache:      make(map[string]valueCacheLTE),
+		lastAccess: time.Now(),
+	}
+	cache.shared = append(cache.shared, sharedCache)
+	return sharedCache
+}
+
 func ClearUserCacheSession(user_uuid string) {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
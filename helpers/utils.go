@@ -296,6 +296,54 @@ func GetIP(c echo.Context) string {
 	return client_ip_string
 }
 
+// GetCountry país ISO2 del visitante según Cloudflare (CF-IPCountry); vacío si es desconocido o Tor
+func GetCountry(c echo.Context) string {
+	country := strings.ToUpper(Trim(c.Request().Header.Get("CF-IPCountry")))
+	if len(country) != 2 || country == "XX" || country == "T1" {
+		return ""
+	}
+	return country
+}
+
+// VisitorCookieName cookie con el identificador anónimo del visitante (búsquedas, vistos recientemente)
+const VisitorCookieName = "mg_visitor"
+
+// visitorIDPattern forma de los identificadores de NewUUID; la cookie con otro valor se reemplaza
+var visitorIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// GetVisitorID devuelve el identificador del visitante desde su cookie, creándola si no existe o no es válida
+func GetVisitorID(c echo.Context) string {
+	if visitorID, ok := c.Get(VisitorCookieName).(string); ok && !IsEmpty(visitorID) {
+		return visitorID
+	}
+	if cookie, err := c.Cookie(VisitorCookieName); err == nil && visitorIDPattern.MatchString(cookie.Value) {
+		c.Set(VisitorCookieName, cookie.Value)
+		return cookie.Value
+	}
+
+	visitorID := NewUUID()
+	c.SetCookie(&http.Cookie{
+		Name:     VisitorCookieName,
+		Value:    visitorID,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	c.Set(VisitorCookieName, visitorID)
+	return visitorID
+}
+
+// VisitorSessionKey sesión de GetCacheSession del visitante anónimo; el prefijo evita que choque con la de un usuario
+func VisitorSessionKey(visitorID string) string {
+	return "visitor:" + visitorID
+}
+
+// UserSessionKey sesión de GetCacheSession del usuario autenticado
+func UserSessionKey(userID string) string {
+	return "user:" + userID
+}
+
 func IsFloatEmpty(f *float64) bool {
 	return f == nil || math.Abs(*f) < 0.000001
 }
@@ -1132,40 +1180,121 @@ func GetIntParam(c echo.Context, param string, defaultValue int) int {
 	return value
 }
 
+// Direcciones del cursor: página siguiente o anterior al producto del cursor
+const (
+	CursorDirectionNext = "next"
+	CursorDirectionPrev = "prev"
+)
+
 // CursorData estructura para los datos del cursor
 type CursorData struct {
-	Timestamp string   `json:"timestamp"`
-	Price     *int     `json:"price,omitempty"`   // Para ordenamiento por precio
-	Rating    *float64 `json:"rating,omitempty"`  // Para ordenamiento por rating
-	Sold      *int     `json:"sold,omitempty"`    // Para ordenamiento por ventas
-	SortBy    string   `json:"sort_by,omitempty"` // Tipo de ordenamiento usado
+	Timestamp  string   `json:"timestamp"`
+	Price      *int     `json:"price,omitempty"`     // Para ordenamiento por precio
+	Rating     *float64 `json:"rating,omitempty"`    // Para ordenamiento por rating
+	Sold       *int     `json:"sold,omitempty"`      // Para ordenamiento por ventas
+	Trending   *float64 `json:"trending,omitempty"`  // Para ordenamiento por tendencia
+	SortBy     string   `json:"sort_by,omitempty"`   // Tipo de ordenamiento usado
+	Direction  string   `json:"direction,omitempty"` // CursorDirectionNext o CursorDirectionPrev
+	ExpiresAt  int64    `json:"exp"`                 // Unix; se rechaza después de esta fecha
+	FilterHash string   `json:"fh,omitempty"`        // CursorFilterHash de los filtros con los que se generó
+
+	// Desempate del keyset por id (categoría y búsqueda); en búsqueda también relevance y el modo de
+	// coincidencia que produjo los resultados
+	ID         string   `json:"id,omitempty"`
+	Relevance  *float64 `json:"relevance,omitempty"`
+	SearchMode int      `json:"search_mode,omitempty"`
+
+	Attributes map[string][]string `json:"attributes,omitempty"` // Filtros de atributos activos al generar el cursor
+}
+
+// Formato del cursor: base64 URL-safe sin relleno de [versión][id de clave][nonce][JSON cifrado].
+// La versión y el id de clave van como datos autenticados para que no se puedan alterar
+const (
+	cursorVersion = byte(1)
+	CursorTTL     = 24 * time.Hour // Vigencia de un cursor desde que se genera
+)
+
+// Errores de cursor; todos envuelven ErrInvalidCursor
+var (
+	ErrInvalidCursor        = errors.New("invalid cursor")
+	ErrExpiredCursor        = fmt.Errorf("%w: expired", ErrInvalidCursor)
+	ErrCursorFilterMismatch = fmt.Errorf("%w: minted for different filters", ErrInvalidCursor)
+)
+
+// cursorKey clave AES-256 identificada para permitir rotación
+type cursorKey struct {
+	ID  byte
+	Key []byte
+}
+
+// cursorKeys lee las claves de cursor. CURSOR_ENCRYPTION_KEYS="2:clave,1:clave_anterior" (la primera es la activa
+// y las demás solo se usan para descifrar); si no existe se usa CURSOR_ENCRYPTION_KEY con id 1
+func cursorKeys() ([]cursorKey, error) {
+	keys := make([]cursorKey, 0)
+	if raw := os.Getenv("CURSOR_ENCRYPTION_KEYS"); !IsEmpty(raw) {
+		for _, entry := range strings.Split(raw, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid cursor key entry %q, expected id:key", entry)
+			}
+			id, err := strconv.ParseUint(parts[0], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cursor key id %q", parts[0])
+			}
+			keys = append(keys, cursorKey{ID: byte(id), Key: []byte(parts[1])})
+		}
+	} else {
+		keys = append(keys, cursorKey{ID: 1, Key: []byte(os.Getenv("CURSOR_ENCRYPTION_KEY"))})
+	}
+
+	for _, key := range keys {
+		if len(key.Key) != 32 {
+			return nil, fmt.Errorf("encryption key %d must be 32 bytes long", key.ID)
+		}
+	}
+	return keys, nil
+}
+
+// cursorGCM crea el AEAD AES-256-GCM de una clave
+func cursorGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// CursorFilterHash resume los filtros con los que se genera un cursor para detectar su reutilización con otros
+func CursorFilterHash(filters interface{}) string {
+	jsonData, err := json.Marshal(filters)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(jsonData)
+	return hex.EncodeToString(sum[:8])
 }
 
-// EncryptCursor encripta un cursor usando AES-256-GCM
+// EncryptCursor encripta un cursor usando AES-256-GCM con la clave activa
 func EncryptCursor(cursorData CursorData) (string, error) {
+	// Vigencia por defecto
+	if cursorData.ExpiresAt == 0 {
+		cursorData.ExpiresAt = time.Now().Add(CursorTTL).Unix()
+	}
+
 	// Convertir a JSON
 	jsonData, err := json.Marshal(cursorData)
 	if err != nil {
 		return "", err
 	}
 
-	// Codificar en base64
-	b64Data := base64.StdEncoding.EncodeToString(jsonData)
-
-	// Obtener clave de encriptación
-	key := []byte(os.Getenv("CURSOR_ENCRYPTION_KEY"))
-	if len(key) != 32 {
-		return "", fmt.Errorf("encryption key must be 32 bytes long")
-	}
-
-	// Crear cipher AES
-	block, err := aes.NewCipher(key)
+	// Obtener clave de encriptación activa
+	keys, err := cursorKeys()
 	if err != nil {
 		return "", err
 	}
+	key := keys[0]
 
-	// Crear GCM
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := cursorGCM(key.Key)
 	if err != nil {
 		return "", err
 	}
@@ -1176,14 +1305,17 @@ func EncryptCursor(cursorData CursorData) (string, error) {
 		return "", err
 	}
 
-	// Encriptar
-	ciphertext := gcm.Seal(nonce, nonce, []byte(b64Data), nil)
+	// Encriptar autenticando la cabecera
+	header := []byte{cursorVersion, key.ID}
+	payload := append(header, nonce...)
+	payload = gcm.Seal(payload, nonce, jsonData, header)
 
-	// Codificar en base64 el resultado final
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	// Codificar en base64 URL-safe para que sobreviva en query strings
+	return base64.RawURLEncoding.EncodeToString(payload), nil
 }
 
-// DecryptCursor desencripta un cursor encriptado
+// DecryptCursor desencripta un cursor encriptado y rechaza los expirados
+// El hash de filtros se valida en quien conoce los filtros (ver CursorFilterHash)
 func DecryptCursor(encryptedCursor string) (CursorData, error) {
 	var cursorData CursorData
 
@@ -1192,54 +1324,108 @@ func DecryptCursor(encryptedCursor string) (CursorData, error) {
 	}
 
 	// Decodificar base64
-	ciphertext, err := base64.StdEncoding.DecodeString(encryptedCursor)
-	if err != nil {
-		return cursorData, err
+	payload, err := base64.RawURLEncoding.DecodeString(encryptedCursor)
+	if err != nil || len(payload) < 2 {
+		return cursorData, ErrInvalidCursor
 	}
 
-	// Obtener clave de encriptación
-	key := []byte(os.Getenv("CURSOR_ENCRYPTION_KEY"))
-	if len(key) != 32 {
-		return cursorData, fmt.Errorf("encryption key must be 32 bytes long")
+	// Cabecera: versión y clave
+	header := payload[:2]
+	if header[0] != cursorVersion {
+		return cursorData, fmt.Errorf("%w: unsupported version %d", ErrInvalidCursor, header[0])
 	}
 
-	// Crear cipher AES
-	block, err := aes.NewCipher(key)
+	keys, err := cursorKeys()
 	if err != nil {
 		return cursorData, err
 	}
+	var key []byte
+	for _, candidate := range keys {
+		if candidate.ID == header[1] {
+			key = candidate.Key
+			break
+		}
+	}
+	if key == nil {
+		return cursorData, fmt.Errorf("%w: unknown key %d", ErrInvalidCursor, header[1])
+	}
 
-	// Crear GCM
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := cursorGCM(key)
 	if err != nil {
 		return cursorData, err
 	}
 
 	// Extraer nonce
 	nonceSize := gcm.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return cursorData, fmt.Errorf("ciphertext too short")
+	if len(payload) < 2+nonceSize {
+		return cursorData, fmt.Errorf("%w: ciphertext too short", ErrInvalidCursor)
 	}
-
-	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
+	nonce, ciphertext := payload[2:2+nonceSize], payload[2+nonceSize:]
 
 	// Desencriptar
-	b64Data, err := gcm.Open(nil, nonce, ciphertext, nil)
+	jsonData, err := gcm.Open(nil, nonce, ciphertext, header)
 	if err != nil {
-		return cursorData, err
-	}
-
-	// Decodificar base64
-	jsonData, err := base64.StdEncoding.DecodeString(string(b64Data))
-	if err != nil {
-		return cursorData, err
+		return cursorData, ErrInvalidCursor
 	}
 
 	// Convertir de JSON
 	err = json.Unmarshal(jsonData, &cursorData)
 	if err != nil {
-		return cursorData, err
+		return cursorData, ErrInvalidCursor
+	}
+
+	if cursorData.ExpiresAt == 0 || time.Now().Unix() > cursorData.ExpiresAt {
+		return CursorData{}, ErrExpiredCursor
 	}
 
 	return cursorData, nil
 }
+
+var accentsReplacer = strings.NewReplacer(
+	"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n",
+	"Á", "A", "É", "E", "Í", "I", "Ó", "O", "Ú", "U", "Ü", "U", "Ñ", "N",
+	"à", "a", "è", "e", "ì", "i", "ò", "o", "ù", "u", "ç", "c",
+	"À", "A", "È", "E", "Ì", "I", "Ò", "O", "Ù", "U", "Ç", "C",
+)
+
+// RemoveAccents reemplaza las vocales acentuadas y la ñ por su equivalente sin acento
+func RemoveAccents(s string) string {
+	return accentsReplacer.Replace(s)
+}
+
+// slugSeparatorPattern caracteres que Slugify reemplaza por un guion
+var slugSeparatorPattern = regexp.MustCompile("[^a-z0-9]+")
+
+// Slugify convierte un texto en un slug: minúsculas, sin acentos y con guiones
+// Ejemplo: Slugify("Índice de Carga") // Returns "indice-de-carga"
+func Slugify(s string) string {
+	s = strings.ToLower(RemoveAccents(Trim(s)))
+	return strings.Trim(slugSeparatorPattern.ReplaceAllString(s, "-"), "-")
+}
+
+// EditDistance distancia de Damerau-Levenshtein (transposiciones adyacentes) entre dos textos, por runas
+func EditDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	rows := make([][]int, len(ra)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(rb)+1)
+		rows[i][0] = i
+	}
+	for j := 0; j <= len(rb); j++ {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(ra)][len(rb)]
+}
@@ -0,0 +1,170 @@
+package H
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+const (
+	testCursorKey    = "0123456789abcdef0123456789abcdef"
+	testCursorKeyOld = "fedcba9876543210fedcba9876543210"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	t.Setenv("CURSOR_ENCRYPTION_KEYS", "")
+	t.Setenv("CURSOR_ENCRYPTION_KEY", testCursorKey)
+
+	price := 1500
+	encrypted, err := EncryptCursor(CursorData{
+		Timestamp:  "2024-05-01T10:00:00Z",
+		Price:      &price,
+		SortBy:     "price_asc",
+		Direction:  CursorDirectionNext,
+		FilterHash: CursorFilterHash(map[string]string{"category": "hogar"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := DecryptCursor(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Timestamp != "2024-05-01T10:00:00Z" || decrypted.Price == nil || *decrypted.Price != price ||
+		decrypted.SortBy != "price_asc" || decrypted.Direction != CursorDirectionNext {
+		t.Errorf("decrypted = %+v", decrypted)
+	}
+	if decrypted.FilterHash != CursorFilterHash(map[string]string{"category": "hogar"}) {
+		t.Errorf("filter hash changed: %s", decrypted.FilterHash)
+	}
+	if decrypted.ExpiresAt <= time.Now().Unix() {
+		t.Errorf("expires_at %d should be in the future", decrypted.ExpiresAt)
+	}
+
+	// Sin cursor: datos vacíos y sin error
+	if empty, err := DecryptCursor(""); err != nil || empty.Timestamp != "" {
+		t.Errorf("empty cursor = %+v, %v", empty, err)
+	}
+}
+
+func TestCursorRejectsInvalid(t *testing.T) {
+	t.Setenv("CURSOR_ENCRYPTION_KEYS", "")
+	t.Setenv("CURSOR_ENCRYPTION_KEY", testCursorKey)
+
+	encrypted, err := EncryptCursor(CursorData{Timestamp: "2024-05-01T10:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(encrypted)
+
+	tampered := append([]byte{}, payload...)
+	tampered[len(tampered)-1] ^= 1
+	otherVersion := append([]byte{}, payload...)
+	otherVersion[0] = cursorVersion + 1
+	otherKey := append([]byte{}, payload...)
+	otherKey[1] = 9
+
+	tests := map[string]string{
+		"not base64":    "%%%",
+		"too short":     base64.RawURLEncoding.EncodeToString(payload[:5]),
+		"tampered":      base64.RawURLEncoding.EncodeToString(tampered),
+		"other version": base64.RawURLEncoding.EncodeToString(otherVersion),
+		"unknown key":   base64.RawURLEncoding.EncodeToString(otherKey),
+	}
+	for name, cursor := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := DecryptCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("err = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestCursorExpiry(t *testing.T) {
+	t.Setenv("CURSOR_ENCRYPTION_KEYS", "")
+	t.Setenv("CURSOR_ENCRYPTION_KEY", testCursorKey)
+
+	expired, err := EncryptCursor(CursorData{Timestamp: "2024-05-01T10:00:00Z", ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DecryptCursor(expired)
+	if !errors.Is(err, ErrExpiredCursor) || !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("err = %v, want ErrExpiredCursor wrapping ErrInvalidCursor", err)
+	}
+}
+
+func TestCursorKeyRotation(t *testing.T) {
+	// Cursor generado con la clave anterior (id 1)
+	t.Setenv("CURSOR_ENCRYPTION_KEYS", "1:"+testCursorKeyOld)
+	oldCursor, err := EncryptCursor(CursorData{Timestamp: "2024-05-01T10:00:00Z", SortBy: "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotación: la clave 2 es la activa y la 1 solo descifra
+	t.Setenv("CURSOR_ENCRYPTION_KEYS", "2:"+testCursorKey+",1:"+testCursorKeyOld)
+	decrypted, err := DecryptCursor(oldCursor)
+	if err != nil || decrypted.SortBy != "old" {
+		t.Fatalf("old cursor after rotation = %+v, %v", decrypted, err)
+	}
+	newCursor, err := EncryptCursor(CursorData{Timestamp: "2024-05-01T10:00:00Z", SortBy: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload, _ := base64.RawURLEncoding.DecodeString(newCursor); payload[1] != 2 {
+		t.Errorf("new cursor key id = %d, want 2", payload[1])
+	}
+
+	// Retirada la clave 1, sus cursores ya no valen
+	t.Setenv("CURSOR_ENCRYPTION_KEYS", "2:"+testCursorKey)
+	if _, err := DecryptCursor(oldCursor); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("old cursor after retiring its key: err = %v, want ErrInvalidCursor", err)
+	}
+	if decrypted, err := DecryptCursor(newCursor); err != nil || decrypted.SortBy != "new" {
+		t.Errorf("new cursor = %+v, %v", decrypted, err)
+	}
+}
+
+func TestCursorKeysConfig(t *testing.T) {
+	tests := map[string]string{
+		"short key":  "1:short",
+		"missing id": testCursorKey,
+		"bad id":     "x:" + testCursorKey,
+	}
+	for name, keys := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("CURSOR_ENCRYPTION_KEYS", keys)
+			if _, err := EncryptCursor(CursorData{}); err == nil {
+				t.Error("expected a configuration error")
+			}
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "casa", 4},
+		{"casa", "casa", 0},
+		{"casa", "cosa", 1},
+		{"casa", "casas", 1},
+		{"zapatila", "zapatilla", 1},
+		{"mochlia", "mochila", 1}, // Transposición
+		{"camion", "camión", 1},   // Runas, no bytes
+		{"perro", "gato", 4},
+	}
+	for _, tt := range tests {
+		if got := EditDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("EditDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := EditDistance(tt.b, tt.a); got != tt.want {
+			t.Errorf("EditDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
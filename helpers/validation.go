@@ -35,9 +35,13 @@ type (
 	}
 )
 
-var validator_instance *validator.Validate
+type validatorInstance struct {
+	validate *validator.Validate
+	trans    ut.Translator
+}
+
+var validator_instances map[string]*validatorInstance
 var mutex *sync.Mutex
-var last_language string
 
 func SnakeCase(s string) string {
 	var snake string
@@ -72,9 +76,14 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 			lang = "es"
 		}
 	}
+	return cv.ValidateLang(to_validate.Data, lang)
+}
+
+// ValidateLang valida data (puntero a struct) con los mensajes en lang, sin una petición (trabajos en segundo plano)
+func (cv *CustomValidator) ValidateLang(data interface{}, lang string) error {
 	trans, _ := cv.Uni.GetTranslator(lang)
 	fTranslation := make(map[string]string)
-	typeOf := reflect.TypeOf(to_validate.Data).Elem()
+	typeOf := reflect.TypeOf(data).Elem()
 	modelName := typeOf.Name()
 	if modelTranslate, ok := cv.ListModels[modelName]; ok {
 		if fieldTranslate, ok := modelTranslate[lang]; ok {
@@ -83,29 +92,35 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 	}
 	mutex.Lock()
 	defer mutex.Unlock()
-	validator_instance.RegisterTagNameFunc(func(field reflect.StructField) string {
-		if name, ok := fTranslation[field.Name]; ok {
-			return name
-		}
-		return field.Name
-	})
-	if err := validator_instance.Struct(to_validate.Data); err != nil {
-		var list_error []map[string]interface{}
-		if last_language != lang {
-			if lang == "es" {
-				es_translations.RegisterDefaultTranslations(validator_instance, trans)
-			} else {
-				en_translations.RegisterDefaultTranslations(validator_instance, trans)
+	// El validador cachea los nombres de campo de cada struct la primera vez que lo valida, así que se usa una
+	// instancia por modelo e idioma, con su propio traductor (un traductor no admite registrar dos veces un mensaje)
+	instanceKey := modelName + ":" + lang
+	instance, ok := validator_instances[instanceKey]
+	if !ok {
+		instance = &validatorInstance{validate: validator.New()}
+		instance.trans, _ = ut.New(trans, trans).GetTranslator(trans.Locale())
+		instance.validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+			if name, ok := fTranslation[field.Name]; ok {
+				return name
 			}
-			last_language = lang
+			return field.Name
+		})
+		if lang == "es" {
+			es_translations.RegisterDefaultTranslations(instance.validate, instance.trans)
+		} else {
+			en_translations.RegisterDefaultTranslations(instance.validate, instance.trans)
 		}
+		validator_instances[instanceKey] = instance
+	}
+	if err := instance.validate.Struct(data); err != nil {
+		var list_error []map[string]interface{}
 		for _, err := range err.(validator.ValidationErrors) {
 			el := make(map[string]interface{})
 			el["content"] = err.Value()
 			el["rule"] = err.Tag()
 			el["field"] = SnakeCase(err.StructField())
 			el["field_lang"] = err.Field()
-			el["message"] = err.Translate(trans)
+			el["message"] = err.Translate(instance.trans)
 			list_error = append(list_error, el)
 		}
 
@@ -115,7 +130,6 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 }
 
 func init() {
-	validator_instance = validator.New()
+	validator_instances = make(map[string]*validatorInstance)
 	mutex = new(sync.Mutex)
-	last_language = ""
 }
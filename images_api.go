@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	H "mercadillo-global/helpers"
+	"mercadillo-global/models"
+)
+
+// listProductImages GET /api/v1/products/:productId/images, imágenes subidas con las URL de cada tamaño
+func listProductImages(c echo.Context) error {
+	images, err := models.GetProductImages(H.DB(), H.GetUserID(c), c.Param("productId"))
+	if err != nil {
+		return productImageError(c, err)
+	}
+	response := make([]map[string]interface{}, 0, len(images))
+	for _, image := range images {
+		response = append(response, productImageResponse(image))
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// uploadProductImage POST /api/v1/products/:productId/images, multipart con la imagen en "file". Se agrega al
+// final de las imágenes del producto
+func uploadProductImage(c echo.Context) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, H.GenericError{Message: H.TranslateText("The file is required", c), Error: err.Error()})
+	}
+	if fileHeader.Size > models.ProductImageMaxBytes {
+		return c.JSON(http.StatusRequestEntityTooLarge, H.GenericError{Message: H.TranslateText("The file is too large", c)})
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, H.GenericError{Message: H.TranslateText("The file is required", c), Error: err.Error()})
+	}
+	defer file.Close()
+
+	image, err := models.AddProductImage(H.DB(), H.GetUserID(c), c.Param("productId"), file)
+	if err != nil {
+		return productImageError(c, err)
+	}
+	reindexSellerProduct(c, image.ProductID)
+	return c.JSON(http.StatusCreated, productImageResponse(*image))
+}
+
+// deleteProductImage DELETE /api/v1/products/:productId/images/:imageId
+func deleteProductImage(c echo.Context) error {
+	productID := c.Param("productId")
+	if err := models.DeleteProductImage(H.DB(), H.GetUserID(c), productID, c.Param("imageId")); err != nil {
+		return productImageError(c, err)
+	}
+	reindexSellerProduct(c, productID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// productImageResponse imagen con las URL de sus tamaños y formatos
+func productImageResponse(image models.ProductImage) map[string]interface{} {
+	return map[string]interface{}{
+		"image": image,
+		"urls":  image.URLs(),
+	}
+}
+
+// productImageError responde los errores de las imágenes de productos
+func productImageError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, models.ErrProductNotFound):
+		return c.JSON(http.StatusNotFound, H.GenericError{Message: H.TranslateText("Product not found", c)})
+	case errors.Is(err, models.ErrProductImageNotFound):
+		return c.JSON(http.StatusNotFound, H.GenericError{Message: H.TranslateText("Image not found", c)})
+	case errors.Is(err, models.ErrInvalidImage):
+		return c.JSON(http.StatusBadRequest, H.GenericError{Message: H.TranslateText("Invalid image", c), Error: err.Error()})
+	}
+	c.Logger().Error("Error saving product image: ", err)
+	return c.JSON(http.StatusInternalServerError, H.GenericError{Message: H.TranslateText("Error saving image", c)})
+}
+
+// reindexSellerProduct actualiza el producto en el motor de búsqueda después de cambiar sus imágenes
+func reindexSellerProduct(c echo.Context, productID string) {
+	product, err := models.GetSellerProduct(H.DB(), H.GetUserID(c), productID)
+	if err != nil {
+		c.Logger().Error("Error loading product: ", err)
+		return
+	}
+	indexProduct(c, product)
+}
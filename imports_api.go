@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	H "mercadillo-global/helpers"
+	"mercadillo-global/models"
+)
+
+// maxImportFileSize tamaño máximo del CSV/XLSX de la importación masiva
+const maxImportFileSize = 20 << 20
+
+// createProductImport POST /api/v1/imports, multipart con el archivo en "file" y "publish" (true para mandar los
+// productos nuevos a wait_for_ia en lugar de dejarlos en borrador). La importación corre en segundo plano
+func createProductImport(c echo.Context) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, H.GenericError{Message: H.TranslateText("The file is required", c), Error: err.Error()})
+	}
+	if fileHeader.Size > maxImportFileSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, H.GenericError{Message: H.TranslateText("The file is too large", c)})
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, H.GenericError{Message: H.TranslateText("The file is required", c), Error: err.Error()})
+	}
+	defer file.Close()
+
+	publish, _ := strconv.ParseBool(c.FormValue("publish"))
+	productImport, err := models.CreateProductImport(H.DB(), H.GetUserID(c), fileHeader.Filename, file, publish, H.GetLanguage(c))
+	if errors.Is(err, models.ErrInvalidImportFile) {
+		return c.JSON(http.StatusBadRequest, H.GenericError{Message: H.TranslateText("Invalid import file", c), Error: err.Error()})
+	}
+	if err != nil {
+		c.Logger().Error("Error creating product import: ", err)
+		return c.JSON(http.StatusInternalServerError, H.GenericError{Message: H.TranslateText("Error creating import", c)})
+	}
+	return c.JSON(http.StatusAccepted, productImportResponse(*productImport))
+}
+
+// listProductImports GET /api/v1/imports
+func listProductImports(c echo.Context) error {
+	limit := H.GetIntParam(c, "limit", 20)
+	if limit > 100 {
+		limit = 20
+	}
+	imports, err := models.GetProductImports(H.DB(), H.GetUserID(c), limit)
+	if err != nil {
+		c.Logger().Error("Error loading product imports: ", err)
+		return c.JSON(http.StatusInternalServerError, H.GenericError{Message: H.TranslateText("Error loading imports", c)})
+	}
+	response := make([]map[string]interface{}, 0, len(imports))
+	for _, productImport := range imports {
+		response = append(response, productImportResponse(productImport))
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// getProductImport GET /api/v1/imports/:importId, estado y avance de la importación
+func getProductImport(c echo.Context) error {
+	productImport, err := models.GetProductImport(H.DB(), H.GetUserID(c), c.Param("importId"))
+	if err != nil {
+		return productImportError(c, err)
+	}
+	return c.JSON(http.StatusOK, productImportResponse(*productImport))
+}
+
+// productImportErrors GET /api/v1/imports/:importId/errors, reporte de errores por fila (format=csv para descargarlo)
+func productImportErrors(c echo.Context) error {
+	productImport, err := models.GetProductImport(H.DB(), H.GetUserID(c), c.Param("importId"))
+	if err != nil {
+		return productImportError(c, err)
+	}
+	importErrors, err := models.GetProductImportErrors(H.DB(), productImport.ID)
+	if err != nil {
+		return productImportError(c, err)
+	}
+	if c.QueryParam("format") != "csv" {
+		return c.JSON(http.StatusOK, importErrors)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="import-%s-errors.csv"`, productImport.ID))
+	c.Response().WriteHeader(http.StatusOK)
+	writer := csv.NewWriter(c.Response())
+	writer.Write([]string{"row", "field", "message"})
+	for _, importError := range importErrors {
+		writer.Write([]string{strconv.Itoa(importError.Row), importError.Field, importError.Message})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// productImportResponse importación con su porcentaje de avance
+func productImportResponse(productImport models.ProductImport) map[string]interface{} {
+	return map[string]interface{}{
+		"import":   productImport,
+		"progress": productImport.Progress(),
+	}
+}
+
+// productImportError responde los errores de las consultas de importaciones
+func productImportError(c echo.Context, err error) error {
+	if errors.Is(err, models.ErrProductImportNotFound) {
+		return c.JSON(http.StatusNotFound, H.GenericError{Message: H.TranslateText("Import not found", c)})
+	}
+	c.Logger().Error("Error loading product import: ", err)
+	return c.JSON(http.StatusInternalServerError, H.GenericError{Message: H.TranslateText("Error loading imports", c)})
+}
+
+// importValidator adapta H.CustomValidator a la validación de filas de la importación
+func importValidator(cv *H.CustomValidator) models.ImportValidateFunc {
+	return func(data interface{}, lang string) []models.ImportFieldError {
+		err := cv.ValidateLang(data, lang)
+		if err == nil {
+			return nil
+		}
+		var httpError *echo.HTTPError
+		if errors.As(err, &httpError) {
+			if list, ok := httpError.Message.([]map[string]interface{}); ok {
+				fieldErrors := make([]models.ImportFieldError, 0, len(list))
+				for _, item := range list {
+					fieldErrors = append(fieldErrors, models.ImportFieldError{
+						Field:   fmt.Sprint(item["field"]),
+						Message: fmt.Sprint(item["message"]),
+					})
+				}
+				return fieldErrors
+			}
+		}
+		return []models.ImportFieldError{{Message: err.Error()}}
+	}
+}
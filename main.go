@@ -1,14 +1,27 @@
 package main
 
 import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"html/template"
 	"io"
-	"math/rand"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	ut "github.com/go-playground/universal-translator"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"gorm.io/gorm"
 
 	H "mercadillo-global/helpers"
 	"mercadillo-global/models"
@@ -29,8 +42,57 @@ func main() {
 		panic("Failed to initialize categories: " + err.Error())
 	}
 
+	// Sinónimos para la normalización de búsquedas
+	if err := models.InitializeSynonyms(); err != nil {
+		panic("Failed to initialize synonyms: " + err.Error())
+	}
+
+	// Motor de búsqueda: MySQL FULLTEXT por defecto o índice BM25 en memoria con SEARCH_ENGINE=memory
+	if os.Getenv("SEARCH_ENGINE") == "memory" {
+		engine := models.NewMemorySearchEngine()
+		models.SetSearchEngine(engine)
+		go func() {
+			if err := engine.IndexFromDB(H.DB()); err != nil {
+				log.Println("Error indexing products in memory search engine: ", err)
+			}
+		}()
+	}
+
+	// Índice de autocompletado en memoria, se refresca periódicamente
+	go models.RunSuggestIndexRefresher(H.DB)
+
+	// Visitas, puntaje de tendencia (sort=trending) y más vendidos por categoría
+	go models.RunTrendingJob(H.DB)
+
 	e := echo.New()
 
+	// Validación de los DTO de la API (H.Validate) con mensajes y nombres de campo en es/en
+	validator := &H.CustomValidator{Uni: ut.New(es.New(), es.New(), en.New()), ListModels: models.ValidationModels}
+	e.Validator = validator
+
+	// Eventos: cambios de estado de productos, preguntas y reseñas
+	if err := H.Listener.Load(&e.Logger); err != nil {
+		panic("Failed to load listeners: " + err.Error())
+	}
+	models.RegisterWorkflowListeners(H.DB)
+
+	// Enriquecimiento con IA de los productos en wait_for_ia; IA_MODEL=fake usa un modelo determinístico sin IA
+	if iaModel := os.Getenv("IA_MODEL"); iaModel != "" {
+		var model models.EnrichmentModel = models.NewIAEnrichmentModel()
+		if iaModel == "fake" {
+			model = &models.FakeEnrichmentModel{}
+		}
+		go models.RunEnrichmentWorker(H.DB, model)
+	}
+
+	// Importación masiva de productos (CSV/XLSX) en segundo plano
+	go models.RunProductImportWorker(H.DB, importValidator(validator))
+
+	// Feeds de catálogo para Google Merchant Center y Meta; SITE_URL es la URL pública para los enlaces
+	if siteURL := os.Getenv("SITE_URL"); siteURL != "" {
+		go models.RunCatalogFeedJob(H.DB, siteURL)
+	}
+
 	// Load templates with helper functions
 	funcMap := template.FuncMap{
 		"add": func(a, b int) int { return a + b },
@@ -40,6 +102,9 @@ func main() {
 		"isEmpty":       H.IsEmpty,
 		"jsonDecode":    H.JSONDecode,
 		"jsonDecodeMap": H.JSONDecodeMap,
+		"imageURL":      models.ImageURL,
+		"srcset":        func(url string) string { return models.ImageSrcset(H.DB(), url) },
+		"webpSrcset":    func(url string) string { return models.ImageWebPSrcset(H.DB(), url) },
 	}
 
 	templates := template.Must(template.New("").Funcs(funcMap).ParseGlob("templates/**/*.html"))
@@ -52,12 +117,56 @@ func main() {
 
 	// Static files (for CSS, JS, images)
 	e.Static("/static", "static")
+	// Imágenes subidas por los vendedores (almacenamiento local, ver models.ImageStorage)
+	e.Static("/uploads", models.ProductImagesDir)
+
+	// Formularios de compra: token en la cookie mg_csrf que debe volver en el campo csrf
+	csrf := middleware.CSRFWithConfig(middleware.CSRFConfig{
+		TokenLookup:    "form:csrf",
+		CookieName:     "mg_csrf",
+		CookiePath:     "/",
+		CookieHTTPOnly: true,
+		CookieSameSite: http.SameSiteLaxMode,
+	})
 
 	// Routes
 	e.GET("/", homePage)
 	e.GET("/category/:categoryId", categoryPage)
-	e.GET("/product/:productId", productPage)
-	e.GET("/checkout/:productId", checkoutPage)
+	e.GET("/product/:productId", productPage, csrf)
+	e.GET("/checkout/:productId", checkoutPage, csrf)
+	e.POST("/checkout/:productId", reserveCheckout, csrf)
+	e.POST("/checkout/:productId/confirm", confirmCheckout, csrf)
+	e.POST("/checkout/:productId/release", releaseCheckout, csrf)
+	e.GET("/search", searchPage)
+	e.GET("/search/suggest", searchSuggest)
+	e.GET("/feeds/:file", catalogFeed)
+
+	// Administración: requiere la clave ADMIN_API_KEY (Authorization: Bearer <clave>)
+	admin := e.Group("/admin", middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
+		adminKey := os.Getenv("ADMIN_API_KEY")
+		return adminKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1, nil
+	}))
+	admin.GET("/search/report", searchReport)
+	admin.POST("/search/normalize", normalizeSearchColumns)
+	admin.POST("/products/:id/status", moderateStatus(models.ProductWorkflow))
+	admin.POST("/questions/:id/status", moderateStatus(models.QuestionWorkflow))
+	admin.POST("/reviews/:id/status", moderateStatus(models.ReviewWorkflow))
+	admin.GET("/products/:id/status-history", productStatusHistory)
+	admin.POST("/variants/migrate", migrateAttributeVariants)
+
+	// API de productos del vendedor: requiere un JWT válido (ver H.GetUserID)
+	api := e.Group("/api/v1", requireUser)
+	api.POST("/products", createProduct)
+	api.PUT("/products/:productId", replaceProduct)
+	api.PATCH("/products/:productId", patchProduct)
+	api.DELETE("/products/:productId", deleteProduct)
+	api.GET("/products/:productId/images", listProductImages)
+	api.POST("/products/:productId/images", uploadProductImage)
+	api.DELETE("/products/:productId/images/:imageId", deleteProductImage)
+	api.POST("/imports", createProductImport)
+	api.GET("/imports", listProductImports)
+	api.GET("/imports/:importId", getProductImport)
+	api.GET("/imports/:importId/errors", productImportErrors)
 
 	// Start server
 	e.Logger.Fatal(e.Start(":8080"))
@@ -69,10 +178,11 @@ func homePage(c echo.Context) error {
 	c.Logger().Info("Home page accessed from IP: ", clientIP)
 
 	data := models.HomePageData{
-		Title:            "Mercadillo Global - Compra y Vende Online",
-		FeaturedProducts: getEnrichedProducts(),
-		Categories:       models.GetCategories(),
-		PageTemplate:     "home-content",
+		Title:          "Mercadillo Global - Compra y Vende Online",
+		Slots:          getHomeSlots(c),
+		Categories:     models.GetCategories(),
+		RecentlyViewed: getRecentlyViewed(c),
+		PageTemplate:   "home-content",
 	}
 	c.Logger().Info("PageTemplate: ", data.PageTemplate)
 	return c.Render(http.StatusOK, "base.html", data)
@@ -102,6 +212,14 @@ func categoryPage(c echo.Context) error {
 		}
 	}
 
+	// Rango de precio seleccionado desde las facetas
+	if priceRange := c.QueryParam("price_range"); priceRange != "" && filters.PriceMin == nil && filters.PriceMax == nil {
+		if priceMin, priceMax, ok := models.ParsePriceBucket(priceRange); ok {
+			filters.PriceMin = priceMin
+			filters.PriceMax = priceMax
+		}
+	}
+
 	// Filtro de rating
 	if rating := c.QueryParam("rating"); rating != "" {
 		if ratingInt, err := strconv.Atoi(rating); err == nil {
@@ -139,8 +257,32 @@ func categoryPage(c echo.Context) error {
 		filters.SortBy = sortBy
 	}
 
+	// Filtros de atributos de la categoría: attr[marca]=...
+	for param, values := range c.QueryParams() {
+		if slug, ok := models.ParseAttributeFacetID(param); ok {
+			for _, value := range values {
+				if !H.IsEmpty(value) {
+					if filters.Attributes == nil {
+						filters.Attributes = make(map[string][]string)
+					}
+					filters.Attributes[slug] = append(filters.Attributes[slug], value)
+				}
+			}
+		}
+	}
+
+	// Destino de envío: el elegido en la sesión o el país de la petición
+	shipTo := getShipTo(c)
+	if shipTo.Country != "" {
+		filters.ShipTo = &shipTo
+	}
+
 	// Usar únicamente cursor pagination encriptado basado en timestamp (más eficiente para millones de registros)
 	products, pagination, err := getCategoryProductsWithCursor(categoryId, encryptedCursor, limit, filters)
+	if errors.Is(err, H.ErrInvalidCursor) {
+		// Cursor expirado, manipulado o de otros filtros: volver a la primera página con los mismos filtros
+		return c.Redirect(http.StatusFound, categoryPageURL(c, categoryId, ""))
+	}
 	if err != nil {
 		c.Logger().Error("Error fetching category products: ", err)
 		products = []models.EnrichedProduct{}
@@ -151,14 +293,37 @@ func categoryPage(c echo.Context) error {
 		}
 	}
 
+	// Facetas con conteos reales sobre la misma consulta base del listado
+	facets, err := models.GetCategoryFacets(H.DB(), categoryId, filters)
+	if err != nil {
+		c.Logger().Error("Error fetching category facets: ", err)
+	}
+
+	// Más vendidos de la categoría solo en la primera página
+	bestsellers := []models.EnrichedProduct{}
+	if encryptedCursor == "" {
+		products, err := models.GetCategoryBestsellers(H.DB(), categoryId, 6)
+		if err != nil {
+			c.Logger().Error("Error fetching category bestsellers: ", err)
+		} else {
+			bestsellers = enrichProducts(products)
+		}
+	}
+
 	data := models.CategoryPageData{
-		Title:        getCategoryName(categoryId) + " - Mercadillo Global",
-		CategoryId:   categoryId,
-		CategoryName: getCategoryName(categoryId),
-		Products:     products,
-		Filters:      getFilters(),
-		Pagination:   pagination,
-		PageTemplate: "category-content",
+		Title:           getCategoryName(categoryId) + " - Mercadillo Global",
+		CategoryId:      categoryId,
+		CategoryName:    getCategoryName(categoryId),
+		Breadcrumbs:     models.GetCategoryPath(categoryId),
+		Products:        products,
+		Filters:         append(getFilters(facets), getAttributeFilters(categoryId, facets)...),
+		Pagination:      pagination,
+		Bestsellers:     bestsellers,
+		PrevPageURL:     categoryPageURL(c, categoryId, pagination.PrevCursor),
+		NextPageURL:     categoryPageURL(c, categoryId, pagination.NextCursor),
+		ShipTo:          shipTo,
+		ShipToCountries: getShipToCountries(shipTo),
+		PageTemplate:    "category-content",
 	}
 	return c.Render(http.StatusOK, "base.html", data)
 }
@@ -168,83 +333,483 @@ func productPage(c echo.Context) error {
 	clientIP := H.GetIP(c)
 	c.Logger().Info("Product page accessed from IP: ", clientIP, " for product: ", productId)
 
+	// Clic desde los resultados de una búsqueda
+	if searchQueryID := c.QueryParam("sq"); searchQueryID != "" {
+		sessionID := H.GetVisitorID(c)
+		go func() {
+			if err := models.RecordSearchClick(H.DB(), searchQueryID, sessionID, productId); err != nil {
+				log.Println("Error recording search click: ", err)
+			}
+		}()
+	}
+
 	product := getEnrichedProduct(c, productId)
+	var breadcrumbs []models.CategoryFlat
+	if product.PrimaryCategory != nil {
+		breadcrumbs = models.GetCategoryPath(product.PrimaryCategory.ID)
+	}
 	data := models.ProductPageData{
-		Title:        product.Title + " - Mercadillo Global",
-		Product:      product,
-		Questions:    []models.Question{},
-		Reviews:      []models.Review{},
-		PageTemplate: "product-content",
+		Title:           product.Title + " - Mercadillo Global",
+		Product:         product,
+		Breadcrumbs:     breadcrumbs,
+		Questions:       []models.Question{},
+		Reviews:         []models.Review{},
+		SimilarProducts: []models.EnrichedProduct{},
+		SellerProducts:  []models.EnrichedProduct{},
+		RecentlyViewed:  getRecentlyViewed(c, productId),
+		Purchased:       c.QueryParam("purchase") == "confirmed",
+		MaxQuantity:     models.StockReservationMaxQuantity,
+		CSRFToken:       csrfToken(c),
+		PageTemplate:    "product-content",
+	}
+	switch c.QueryParam("stock") {
+	case "out":
+		data.StockError = "No hay stock suficiente de esta opción. Prueba con otra cantidad o variante."
+	case "variant":
+		data.StockError = "Elige una opción antes de comprar."
+	case "limit":
+		data.StockError = fmt.Sprintf("Puedes comprar hasta %d unidades y tener %d compras en curso a la vez.",
+			models.StockReservationMaxQuantity, models.StockReservationMaxActive)
+	case "expired":
+		data.StockError = "Tu reserva de stock venció. Vuelve a pulsar Comprar ahora."
+	}
+	if product.ID != "" {
+		models.AddRecentlyViewed(recentlyViewedSessionID(c), product.ID)
+		models.RecordProductView(product.ID)
+	}
+
+	// Productos similares y más del vendedor (cacheados por producto)
+	if product.ID != "" {
+		related, err := models.GetRelatedProducts(H.DB(), product.Product, 6)
+		if err != nil {
+			c.Logger().Error("Error fetching related products: ", err)
+		} else {
+			data.SimilarProducts = enrichProducts(related.Similar)
+			data.SellerProducts = enrichProducts(related.FromSeller)
+		}
 	}
 	return c.Render(http.StatusOK, "base.html", data)
 }
 
+// checkoutPage muestra la compra del stock apartado con reserveCheckout (?reservation=). Sin una reserva activa
+// del visitante vuelve al producto
 func checkoutPage(c echo.Context) error {
 	productId := c.Param("productId")
 	clientIP := H.GetIP(c)
 	c.Logger().Info("Checkout page accessed from IP: ", clientIP, " for product: ", productId)
 
 	product := getEnrichedProduct(c, productId)
-	data := models.CheckoutPageData{
-		Title:        "Checkout - " + product.Title,
-		Product:      product,
-		PageTemplate: "checkout-content",
+	reservationID := c.QueryParam("reservation")
+	if reservationID != "" && product.ID != "" {
+		reservation, variant, err := models.GetStockReservation(H.DB(), reservationID, H.GetVisitorID(c))
+		if err != nil && !errors.Is(err, models.ErrReservationNotFound) && !errors.Is(err, models.ErrReservationExpired) {
+			c.Logger().Error("Error fetching stock reservation: ", err)
+		}
+		if reservation != nil && reservation.ProductID == product.ID {
+			data := models.CheckoutPageData{
+				Title:        "Checkout - " + product.Title,
+				Product:      product,
+				Reservation:  reservation,
+				Quantity:     reservation.Quantity,
+				CSRFToken:    csrfToken(c),
+				PageTemplate: "checkout-content",
+			}
+			price := product.Price
+			if variant != nil {
+				price = variant.EffectivePrice(product.Product)
+				for i := range product.Variants {
+					if product.Variants[i].ID == variant.ID {
+						data.Variant = &product.Variants[i]
+					}
+				}
+			}
+			data.FormattedPrice = H.MaybeFormatNumber(float64(price), true)
+			data.FormattedTotal = H.MaybeFormatNumber(float64(price*reservation.Quantity), true)
+			return c.Render(http.StatusOK, "base.html", data)
+		}
 	}
-	return c.Render(http.StatusOK, "base.html", data)
+
+	switch {
+	case reservationID != "":
+		return c.Redirect(http.StatusSeeOther, "/product/"+productId+"?stock=expired")
+	case len(product.Variants) > 0:
+		return c.Redirect(http.StatusSeeOther, "/product/"+productId+"?stock=variant")
+	}
+	return c.Redirect(http.StatusSeeOther, "/product/"+productId)
 }
 
-// Helper functions that need to be implemented
-func getEnrichedProducts() []models.EnrichedProduct {
-	// Obtener solo los IDs de los 100 mejores productos por rating y reviews
-	var productIDs []string
+// reserveCheckout POST /checkout/:productId desde "Comprar ahora": aparta el stock de la variante (variant_id) y
+// cantidad (quantity) elegidas para el visitante y lleva al checkout
+func reserveCheckout(c echo.Context) error {
+	productId := c.Param("productId")
+	quantity, err := strconv.Atoi(c.FormValue("quantity"))
+	if err != nil || quantity < 1 {
+		quantity = 1
+	}
+
+	reservation, err := models.ReserveStock(H.DB(), productId, c.FormValue("variant_id"), quantity, H.GetVisitorID(c), H.GetIP(c))
+	switch {
+	case errors.Is(err, models.ErrProductNotFound):
+		return echo.ErrNotFound
+	case errors.Is(err, models.ErrVariantRequired), errors.Is(err, models.ErrVariantNotFound):
+		return c.Redirect(http.StatusSeeOther, "/product/"+productId+"?stock=variant")
+	case errors.Is(err, models.ErrOutOfStock):
+		return c.Redirect(http.StatusSeeOther, "/product/"+productId+"?stock=out")
+	case errors.Is(err, models.ErrReservationLimit):
+		return c.Redirect(http.StatusSeeOther, "/product/"+productId+"?stock=limit")
+	case err != nil:
+		c.Logger().Error("Error reserving stock: ", err)
+		return echo.ErrInternalServerError
+	}
+	return c.Redirect(http.StatusSeeOther, "/checkout/"+productId+"?reservation="+reservation.ID)
+}
 
-	err := H.DB().Model(&models.Product{}).
-		Select("id").
-		Where("status = ? AND stock > 0", "active").
-		Order("rating DESC, review_count DESC, created_at DESC").
-		Limit(100).
-		Pluck("id", &productIDs).Error
+// confirmCheckout POST /checkout/:productId/confirm, "Confirmar compra": la reserva (reservation) del visitante
+// pasa a venta y descuenta el stock
+func confirmCheckout(c echo.Context) error {
+	productId := c.Param("productId")
+	err := models.ConfirmStockReservation(H.DB(), c.FormValue("reservation"), H.GetVisitorID(c))
+	switch {
+	case errors.Is(err, models.ErrReservationNotFound), errors.Is(err, models.ErrReservationExpired):
+		return c.Redirect(http.StatusSeeOther, "/product/"+productId+"?stock=expired")
+	case err != nil:
+		c.Logger().Error("Error confirming stock reservation: ", err)
+		return echo.ErrInternalServerError
+	}
+	return c.Redirect(http.StatusSeeOther, "/product/"+productId+"?purchase=confirmed")
+}
 
-	if err != nil || len(productIDs) == 0 {
-		return []models.EnrichedProduct{}
+// releaseCheckout POST /checkout/:productId/release, el visitante abandona el checkout y libera el stock apartado
+func releaseCheckout(c echo.Context) error {
+	productId := c.Param("productId")
+	err := models.ReleaseStockReservation(H.DB(), c.FormValue("reservation"), H.GetVisitorID(c))
+	if err != nil && !errors.Is(err, models.ErrReservationNotFound) {
+		c.Logger().Error("Error releasing stock reservation: ", err)
+		return echo.ErrInternalServerError
 	}
+	return c.Redirect(http.StatusSeeOther, "/product/"+productId)
+}
 
-	// Seleccionar 10 IDs aleatoriamente
-	selectedIDs := selectRandomIDs(productIDs, 10)
+// csrfToken token del middleware CSRF para los formularios de compra
+func csrfToken(c echo.Context) string {
+	token, _ := c.Get(middleware.DefaultCSRFConfig.ContextKey).(string)
+	return token
+}
 
-	// Ahora sí obtener los datos completos solo de los 10 productos seleccionados
-	var selectedProducts []models.Product
-	err = H.DB().Where("id IN ?", selectedIDs).Find(&selectedProducts).Error
+func searchPage(c echo.Context) error {
+	query := H.Trim(c.QueryParam("q"))
+	clientIP := H.GetIP(c)
+	c.Logger().Info("Search page accessed from IP: ", clientIP, " for query: ", query)
 
-	if err != nil {
-		return []models.EnrichedProduct{}
+	limit := 20
+	page := H.GetIntParam(c, "page", 1)
+
+	// Capturar filtros de la URL (mismos nombres que en la página de categoría)
+	filters := models.SearchFilters{
+		Limit:      limit,
+		Offset:     (page - 1) * limit,
+		WithFacets: true,
+		// Sin resultados se muestran los de la corrección ortográfica, salvo que se pida la búsqueda exacta
+		AutoCorrect: c.QueryParam("exact") != "1",
+	}
+	if sortBy := c.QueryParam("sort"); sortBy != "" {
+		filters.SortBy = sortBy
+	}
+	// Con ?cursor= (aunque esté vacío) se pagina por keyset en lugar de por número de página. El keyset sigue el
+	// orden por relevancia, así que con ?sort= se pagina por número de página
+	if c.QueryParams().Has("cursor") && filters.SortBy == "" {
+		filters.UseCursor = true
+		filters.Cursor = c.QueryParam("cursor")
+		filters.Offset = 0
+	}
+	for _, category := range c.QueryParams()["category"] {
+		if !H.IsEmpty(category) {
+			filters.Categories = append(filters.Categories, category)
+		}
+	}
+	if priceMin := c.QueryParam("price_min"); priceMin != "" {
+		if price, err := strconv.Atoi(priceMin); err == nil {
+			filters.MinPrice = &price
+		}
+	}
+	if priceMax := c.QueryParam("price_max"); priceMax != "" {
+		if price, err := strconv.Atoi(priceMax); err == nil {
+			filters.MaxPrice = &price
+		}
+	}
+	if priceRange := c.QueryParam("price_range"); priceRange != "" && filters.MinPrice == nil && filters.MaxPrice == nil {
+		if priceMin, priceMax, ok := models.ParsePriceBucket(priceRange); ok {
+			filters.MinPrice = priceMin
+			filters.MaxPrice = priceMax
+		}
+	}
+	if rating := c.QueryParam("rating"); rating != "" {
+		if ratingFloat, err := strconv.ParseFloat(rating, 64); err == nil {
+			filters.MinRating = &ratingFloat
+		}
+	}
+	if reviews := c.QueryParam("reviews"); reviews != "" {
+		if reviewsInt, err := strconv.Atoi(reviews); err == nil {
+			filters.Reviews = &reviewsInt
+		}
+	}
+	if sales := c.QueryParam("sales"); sales != "" {
+		if salesInt, err := strconv.Atoi(sales); err == nil {
+			filters.Sales = &salesInt
+		}
+	}
+	if shipping := c.QueryParam("shipping"); shipping == "free" || shipping == "nonfree" {
+		freeShipping := shipping == "free"
+		filters.FreeShipping = &freeShipping
+	}
+	if service := c.QueryParam("service"); service != "" {
+		if isService, err := strconv.ParseBool(service); err == nil {
+			filters.IsService = &isService
+		}
+	}
+	shipTo := getShipTo(c)
+	if shipTo.Country != "" {
+		filters.ShipTo = &shipTo
 	}
 
-	// Convertir a productos enriquecidos
-	enrichedProducts := make([]models.EnrichedProduct, len(selectedProducts))
-	for i, product := range selectedProducts {
-		// Obtener la categoría primaria del producto
-		var primaryCategory *models.Category
-		var productCategories []models.ProductCategory
+	result := &models.SearchResult{Products: []models.Product{}, Page: page, PerPage: limit}
+	if query != "" {
+		var err error
+		result, err = models.SearchProducts(H.DB(), query, filters)
+		if errors.Is(err, H.ErrInvalidCursor) {
+			// Cursor expirado o de otra búsqueda: volver a la primera página
+			return c.Redirect(http.StatusFound, searchCursorURL(c, query, "", ""))
+		}
+		if err != nil {
+			c.Logger().Error("Error searching products: ", err)
+			result = &models.SearchResult{Products: []models.Product{}, Page: page, PerPage: limit}
+		}
+	}
 
-		H.DB().Where("product_id = ?", product.ID).Find(&productCategories)
+	// Solo la primera carga alimenta las sugerencias de consultas pasadas y la analítica: las páginas siguientes (y
+	// volver a la primera desde ellas, con sq en la URL) son la misma búsqueda
+	searchQueryID := c.QueryParam("sq")
+	firstPage := page == 1 && filters.Cursor == "" && searchQueryID == ""
+	if firstPage {
+		models.RecordSearchQuery(query, result.Total)
+	}
 
-		for _, pc := range productCategories {
-			category := models.GetCategoryByID(pc.CategoryID)
-			if category != nil && pc.IsPrimary {
-				primaryCategory = category
-				break
+	// Registro de la búsqueda para analítica; los enlaces a productos llevan su id (sq) para medir clics y las
+	// páginas siguientes lo conservan en la URL
+	products := enrichProducts(result.Products)
+	if query != "" {
+		if firstPage {
+			searchQuery := models.NewSearchQuery(H.GetVisitorID(c), query, filters, result.Total)
+			result.QueryID = searchQuery.ID
+			go func() {
+				if err := H.DB().Create(searchQuery).Error; err != nil {
+					log.Println("Error logging search query: ", err)
+				}
+			}()
+		} else {
+			result.QueryID = searchQueryID
+		}
+		if result.QueryID != "" {
+			for i := range products {
+				products[i].Link = "/product/" + products[i].ID + "?sq=" + url.QueryEscape(result.QueryID)
 			}
 		}
+	}
 
-		// Si no hay categoría primaria, usar la primera disponible
-		if primaryCategory == nil && len(productCategories) > 0 {
-			category := models.GetCategoryByID(productCategories[0].CategoryID)
-			if category != nil {
-				primaryCategory = category
-			}
+	if wantsJSON(c) {
+		return c.JSON(http.StatusOK, result)
+	}
+
+	data := models.SearchPageData{
+		Title:           "Resultados para \"" + query + "\" - Mercadillo Global",
+		Query:           query,
+		Products:        products,
+		Filters:         filters,
+		Facets:          getFilters(result.Facets),
+		Total:           result.Total,
+		TotalCapped:     result.TotalCapped,
+		Suggestion:      result.Suggestion,
+		Corrected:       result.Corrected,
+		Page:            result.Page,
+		TotalPages:      result.TotalPages,
+		ShipTo:          shipTo,
+		ShipToCountries: getShipToCountries(shipTo),
+		PageTemplate:    "search-content",
+	}
+	if result.Suggestion != "" {
+		data.SuggestionURL = "/search?q=" + url.QueryEscape(result.Suggestion)
+		data.ExactURL = "/search?exact=1&q=" + url.QueryEscape(query)
+	}
+	// Los resultados corregidos (y su cursor) son de la sugerencia: las páginas siguientes la buscan a ella
+	linkQuery := query
+	if result.Corrected {
+		linkQuery = result.Suggestion
+	}
+	if filters.UseCursor {
+		// Keyset solo avanza; "anterior" vuelve al inicio de la búsqueda
+		if filters.Cursor != "" {
+			data.PrevPageURL = searchCursorURL(c, linkQuery, "", result.QueryID)
+		}
+		if result.HasMore {
+			data.NextPageURL = searchCursorURL(c, linkQuery, result.NextCursor, result.QueryID)
+		}
+	} else {
+		if result.Page > 1 {
+			data.PrevPageURL = searchPageURL(c, linkQuery, result.Page-1, result.QueryID)
+		}
+		if result.Page < result.TotalPages {
+			data.NextPageURL = searchPageURL(c, linkQuery, result.Page+1, result.QueryID)
+		}
+	}
+	return c.Render(http.StatusOK, "base.html", data)
+}
+
+// normalizeSearchColumns POST /admin/search/normalize, pasa search_content y search_keywords de todos los productos
+// al formato normalizado y los reindexa (para los guardados antes de la normalización)
+func normalizeSearchColumns(c echo.Context) error {
+	updated, err := models.NormalizeSearchColumns(H.DB())
+	if err != nil {
+		c.Logger().Error("Error normalizing search columns: ", err)
+		return c.JSON(http.StatusInternalServerError, H.GenericError{Message: "Error normalizing search columns", Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]int{"updated": updated})
+}
+
+// searchSuggest autocompletado: categorías, productos, palabras clave y consultas pasadas desde el índice en memoria
+func searchSuggest(c echo.Context) error {
+	limit := H.GetIntParam(c, "limit", 8)
+	if limit < 1 || limit > 20 {
+		limit = 8
+	}
+	return c.JSON(http.StatusOK, models.GetSuggestions(c.QueryParam("q"), limit))
+}
+
+// searchReport consultas más buscadas, sin resultados y CTR de los últimos días (?days=30&limit=50)
+func searchReport(c echo.Context) error {
+	days := H.GetIntParam(c, "days", 30)
+	limit := H.GetIntParam(c, "limit", 50)
+	if days < 1 {
+		days = 30
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	report, err := models.GetSearchReport(H.DB(), time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		c.Logger().Error("Error building search report: ", err)
+		return c.JSON(http.StatusInternalServerError, H.GenericError{Message: "Error building search report"})
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// recentlyViewedSessionID sesión de vistos recientemente: el usuario autenticado (fusionando lo que vio como
+// anónimo, así la lista se conserva al iniciar sesión) o el visitante de la cookie
+func recentlyViewedSessionID(c echo.Context) string {
+	visitorID := H.GetVisitorID(c)
+	userID := H.GetUserID(c)
+	if userID == "" {
+		return H.VisitorSessionKey(visitorID)
+	}
+	models.MergeRecentlyViewed(visitorID, userID)
+	return H.UserSessionKey(userID)
+}
+
+// getRecentlyViewed productos vistos recientemente listos para las tarjetas, sin los excluidos
+func getRecentlyViewed(c echo.Context, exclude ...string) []models.EnrichedProduct {
+	products, err := models.GetRecentlyViewedProducts(H.DB(), recentlyViewedSessionID(c), exclude...)
+	if err != nil {
+		c.Logger().Error("Error fetching recently viewed products: ", err)
+		return []models.EnrichedProduct{}
+	}
+	return enrichProducts(products)
+}
+
+// shipToSessionKey clave de la sesión con el destino de envío elegido por el visitante
+const shipToSessionKey = "ship_to"
+
+// getShipTo destino de envío: ship_country/ship_state/ship_city si vienen en la URL (y se guardan en la sesión),
+// si no el guardado en la sesión y por último el país de la petición. País vacío = sin filtro
+func getShipTo(c echo.Context) models.ShipTo {
+	session := H.GetCacheSession(H.VisitorSessionKey(H.GetVisitorID(c)))
+	if c.QueryParams().Has("ship_country") {
+		shipTo := models.ShipTo{
+			Country: strings.ToUpper(H.Trim(c.QueryParam("ship_country"))),
+			State:   H.Trim(c.QueryParam("ship_state")),
+			City:    H.Trim(c.QueryParam("ship_city")),
 		}
+		if len(shipTo.Country) != 2 {
+			shipTo = models.ShipTo{}
+		}
+		session.Set(shipToSessionKey, shipTo, 30*24*time.Hour)
+		return shipTo
+	}
+	if shipTo, ok := session.Get(shipToSessionKey).(models.ShipTo); ok {
+		return shipTo
+	}
+	return models.ShipTo{Country: H.GetCountry(c)}
+}
+
+// getShipToCountries opciones del filtro "Envía a": países con almacenes o envíos activos y el seleccionado
+func getShipToCountries(selected models.ShipTo) []models.FilterOption {
+	countries, err := models.GetShippingCountries(H.DB())
+	if err != nil {
+		log.Println("Error fetching shipping countries: ", err)
+	}
+	if selected.Country != "" && !slices.Contains(countries, selected.Country) {
+		countries = append(countries, selected.Country)
+	}
+
+	options := make([]models.FilterOption, 0, len(countries))
+	for _, country := range countries {
+		options = append(options, models.FilterOption{Value: country, Label: H.CountryIso2ToCountryName(country)})
+	}
+	sort.Slice(options, func(i, j int) bool { return options[i].Label < options[j].Label })
+	return options
+}
+
+// wantsJSON indica si el cliente pidió la respuesta en JSON (Accept o ?format=json)
+func wantsJSON(c echo.Context) bool {
+	if c.QueryParam("format") == "json" {
+		return true
+	}
+	return strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMEApplicationJSON)
+}
+
+// searchPageURL construye la URL de búsqueda de query conservando los filtros actuales y el id de la búsqueda
+// registrada (sq), vacío para empezar una búsqueda nueva
+func searchPageURL(c echo.Context, query string, page int, searchQueryID string) string {
+	params := c.Request().URL.Query()
+	params.Set("q", query)
+	params.Set("page", strconv.Itoa(page))
+	setSearchQueryID(params, searchQueryID)
+	return "/search?" + params.Encode()
+}
 
+// searchCursorURL construye la URL de búsqueda de query en modo cursor conservando los filtros actuales y el id
+// de la búsqueda registrada (sq)
+func searchCursorURL(c echo.Context, query, cursor, searchQueryID string) string {
+	params := c.Request().URL.Query()
+	params.Set("q", query)
+	params.Del("page")
+	params.Set("cursor", cursor)
+	setSearchQueryID(params, searchQueryID)
+	return "/search?" + params.Encode()
+}
+
+// setSearchQueryID pone o quita el parámetro sq
+func setSearchQueryID(params url.Values, searchQueryID string) {
+	if searchQueryID == "" {
+		params.Del("sq")
+		return
+	}
+	params.Set("sq", searchQueryID)
+}
+
+// enrichProducts convierte productos en productos enriquecidos para las tarjetas
+func enrichProducts(products []models.Product) []models.EnrichedProduct {
+	enrichedProducts := make([]models.EnrichedProduct, len(products))
+	for i, product := range products {
 		enrichedProducts[i] = models.EnrichedProduct{
 			Product:                product,
 			FormattedPrice:         H.MaybeFormatNumber(float64(product.Price), true),
@@ -252,38 +817,28 @@ func getEnrichedProducts() []models.EnrichedProduct {
 			Discount:               calculateDiscount(product.OriginalPrice, product.Price),
 			Stars:                  []int{0, 1, 2, 3, 4},
 			RatingInt:              int(product.Rating),
-			PrimaryCategory:        primaryCategory,
 		}
 	}
-
 	return enrichedProducts
 }
 
-// selectRandomIDs selecciona n IDs aleatoriamente de una lista
-func selectRandomIDs(ids []string, n int) []string {
-	if len(ids) <= n {
-		return ids
-	}
-
-	// Crear una lista de índices
-	indices := make([]int, len(ids))
-	for i := range indices {
-		indices[i] = i
-	}
-
-	// Mezclar los índices usando Fisher-Yates shuffle
-	for i := len(indices) - 1; i > 0; i-- {
-		j := rand.Intn(i + 1)
-		indices[i], indices[j] = indices[j], indices[i]
+// getHomeSlots slots de merchandising vigentes con sus productos enriquecidos
+func getHomeSlots(c echo.Context) []models.HomeSlot {
+	merchandising, err := models.GetHomeMerchandising(H.DB(), time.Now())
+	if err != nil {
+		c.Logger().Error("Error fetching merchandising slots: ", err)
+		return []models.HomeSlot{}
 	}
 
-	// Seleccionar los primeros n IDs
-	result := make([]string, n)
-	for i := 0; i < n; i++ {
-		result[i] = ids[indices[i]]
+	slots := make([]models.HomeSlot, 0, len(merchandising))
+	for _, slot := range merchandising {
+		slots = append(slots, models.HomeSlot{
+			Slug:     slot.Slot.Slug,
+			Title:    slot.Slot.Title,
+			Products: enrichProducts(slot.Products),
+		})
 	}
-
-	return result
+	return slots
 }
 
 func getEnrichedProduct(c echo.Context, productId string) models.EnrichedProduct {
@@ -297,7 +852,6 @@ func getEnrichedProduct(c echo.Context, productId string) models.EnrichedProduct
 		Preload("Warehouses.Attributes.ProductWarehouse").
 		Preload("Attributes").
 		Preload("Attributes.ProductWarehouse").
-		Preload("Categories").
 		Preload("ProductCategories").
 		Preload("Questions").
 		Preload("Questions.QuestionVotes").
@@ -305,6 +859,8 @@ func getEnrichedProduct(c echo.Context, productId string) models.EnrichedProduct
 		Preload("Reviews").
 		Preload("Reviews.ReviewVotes").
 		Preload("Reviews.ReviewVotes.User").
+		Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("created_at, id") }).
+		Preload("Variants.Stocks").
 		Where("id = ? AND status = ?", productId, "active").First(&product).Error
 	if err != nil {
 		c.Logger().Error("Error fetching product: ", err)
@@ -333,7 +889,7 @@ func getEnrichedProduct(c echo.Context, productId string) models.EnrichedProduct
 		}
 	}
 
-	return models.EnrichedProduct{
+	enriched := models.EnrichedProduct{
 		Product:                product,
 		FormattedPrice:         H.MaybeFormatNumber(float64(product.Price), true),
 		FormattedOriginalPrice: H.MaybeFormatNumber(float64(product.OriginalPrice), true),
@@ -342,7 +898,23 @@ func getEnrichedProduct(c echo.Context, productId string) models.EnrichedProduct
 		RatingInt:              int(product.Rating),
 		PrimaryCategory:        primaryCategory,
 		AllCategories:          allCategories,
+		Image:                  product.FirstImage(),
 	}
+
+	// Variantes para el selector, con el stock que no está reservado
+	if len(product.Variants) > 0 {
+		variants, err := models.GetVariantViews(H.DB(), product, product.Variants)
+		if err != nil {
+			c.Logger().Error("Error fetching product variants: ", err)
+		}
+		categoryID := ""
+		if primaryCategory != nil {
+			categoryID = primaryCategory.ID
+		}
+		enriched.Variants = variants
+		enriched.VariantOptions = models.BuildVariantOptions(product.Variants, categoryID)
+	}
+	return enriched
 }
 
 func getCategoryName(categoryId string) string {
@@ -353,83 +925,141 @@ func getCategoryName(categoryId string) string {
 	return "Categoría Desconocida"
 }
 
-func getFilters() []models.Filter {
-	return []models.Filter{
+func getFilters(facets models.FacetCounts) []models.Filter {
+	filters := []models.Filter{
 		{
 			ID:   "price",
 			Name: "Precio",
-			Options: map[string]string{
-				"fixed": "fixed",
+			Options: []models.FilterOption{
+				{Value: "0-10", Label: "Hasta $10"},
+				{Value: "10-50", Label: "$10 a $50"},
+				{Value: "50-100", Label: "$50 a $100"},
+				{Value: "100-500", Label: "$100 a $500"},
+				{Value: "500-", Label: "Más de $500"},
 			},
 		},
 		{
 			ID:   "rating",
 			Name: "Calificación",
-			Options: map[string]string{
-				"4": "4 estrellas o más",
-				"3": "3 estrellas o más",
-				"2": "2 estrellas o más",
+			Options: []models.FilterOption{
+				{Value: "4", Label: "4 estrellas o más"},
+				{Value: "3", Label: "3 estrellas o más"},
+				{Value: "2", Label: "2 estrellas o más"},
 			},
 		},
 		{
 			ID:   "reviews",
 			Name: "Cantidad de Reviews",
-			Options: map[string]string{
-				"3": "3 o más",
-				"1": "1 o más",
-				"0": "Ninguna",
+			Options: []models.FilterOption{
+				{Value: "3", Label: "3 o más"},
+				{Value: "1", Label: "1 o más"},
+				{Value: "0", Label: "Ninguna"},
 			},
 		},
 		{
 			ID:   "sales",
 			Name: "Cantidad de Ventas",
-			Options: map[string]string{
-				"3": "3 o más",
-				"1": "1 o más",
-				"0": "Ninguna",
+			Options: []models.FilterOption{
+				{Value: "3", Label: "3 o más"},
+				{Value: "1", Label: "1 o más"},
+				{Value: "0", Label: "Ninguna"},
 			},
 		},
 		{
 			ID:   "shipping",
 			Name: "Envío",
-			Options: map[string]string{
-				"free":    "Envío gratis",
-				"nonfree": "Sin envío gratis",
+			Options: []models.FilterOption{
+				{Value: "free", Label: "Envío gratis"},
+				{Value: "nonfree", Label: "Sin envío gratis"},
 			},
 		},
 	}
+
+	// Subcategorías con productos
+	if counts, ok := facets["category"]; ok {
+		categoryFilter := models.Filter{ID: "category", Name: "Categorías"}
+		for categoryID := range counts {
+			categoryFilter.Options = append(categoryFilter.Options, models.FilterOption{
+				Value: categoryID,
+				Label: getCategoryName(categoryID),
+			})
+		}
+		sort.Slice(categoryFilter.Options, func(i, j int) bool {
+			return categoryFilter.Options[i].Label < categoryFilter.Options[j].Label
+		})
+		filters = append([]models.Filter{categoryFilter}, filters...)
+	}
+
+	// Sin facetas calculadas se muestran todas las opciones sin conteo
+	if facets == nil {
+		return filters
+	}
+
+	// Asignar conteos y ocultar opciones sin coincidencias (el precio siempre muestra sus campos min/max)
+	for i := range filters {
+		counts := facets[filters[i].ID]
+		options := make([]models.FilterOption, 0, len(filters[i].Options))
+		for _, option := range filters[i].Options {
+			option.Count = counts[option.Value]
+			if option.Count > 0 {
+				options = append(options, option)
+			}
+		}
+		filters[i].Options = options
+	}
+
+	return filters
+}
+
+// getAttributeFilters arma los filtros dinámicos de atributos de la categoría con sus valores y conteos
+func getAttributeFilters(categoryId string, facets models.FacetCounts) []models.Filter {
+	filters := make([]models.Filter, 0)
+	for _, attribute := range models.GetFilterableAttributes(categoryId) {
+		facetID := models.AttributeFacetID(H.Slugify(attribute))
+		counts := facets[facetID]
+		if len(counts) == 0 {
+			continue
+		}
+
+		filter := models.Filter{ID: facetID, Name: attribute}
+		for value, count := range counts {
+			if count > 0 {
+				filter.Options = append(filter.Options, models.FilterOption{Value: value, Label: value, Count: count})
+			}
+		}
+		sort.Slice(filter.Options, func(i, j int) bool {
+			if filter.Options[i].Count != filter.Options[j].Count {
+				return filter.Options[i].Count > filter.Options[j].Count
+			}
+			return filter.Options[i].Label < filter.Options[j].Label
+		})
+		filters = append(filters, filter)
+	}
+	return filters
 }
 
 // getCategoryProductsWithCursor usa cursor pagination encriptado para mejor rendimiento
 func getCategoryProductsWithCursor(categoryId, encryptedCursor string, limit int, filters models.CategoryFilters) ([]models.EnrichedProduct, models.Pagination, error) {
-	products, nextEncryptedCursor, hasMore, err := models.GetProductsByCategoryCursor(H.DB(), categoryId, encryptedCursor, limit, filters)
+	products, pagination, err := models.GetProductsByCategoryCursor(H.DB(), categoryId, encryptedCursor, limit, filters)
 	if err != nil {
 		return nil, models.Pagination{}, err
 	}
 
 	// Convertir a productos enriquecidos
-	enrichedProducts := make([]models.EnrichedProduct, len(products))
-	for i, product := range products {
-		enrichedProducts[i] = models.EnrichedProduct{
-			Product:                product,
-			FormattedPrice:         H.MaybeFormatNumber(float64(product.Price), true),
-			FormattedOriginalPrice: H.MaybeFormatNumber(float64(product.OriginalPrice), true),
-			Discount:               calculateDiscount(product.OriginalPrice, product.Price),
-			Stars:                  []int{0, 1, 2, 3, 4},
-			RatingInt:              int(product.Rating),
-		}
-	}
+	return enrichProducts(products), pagination, nil
+}
 
-	// Paginación optimizada con cursors encriptados
-	pagination := models.Pagination{
-		ItemsPerPage: limit,
-		HasNext:      hasMore,
-		HasPrev:      encryptedCursor != "", // Si hay cursor, significa que hay página anterior
-		NextCursor:   nextEncryptedCursor,
-		PrevCursor:   encryptedCursor, // El cursor actual se convierte en el cursor anterior
+// categoryPageURL URL de la categoría con los filtros actuales y el cursor indicado (vacío = primera página)
+func categoryPageURL(c echo.Context, categoryId, cursor string) string {
+	params := c.Request().URL.Query()
+	params.Del("cursor")
+	if cursor != "" {
+		params.Set("cursor", cursor)
 	}
-
-	return enrichedProducts, pagination, nil
+	if len(params) == 0 {
+		return "/category/" + categoryId
+	}
+	return "/category/" + categoryId + "?" + params.Encode()
 }
 
 // calculateDiscount helper function
@@ -439,3 +1069,12 @@ func calculateDiscount(originalPrice, price int) int {
 	}
 	return int(float64(originalPrice-price) / float64(originalPrice) * 100)
 }
+
+// catalogFeed GET /feeds/google.xml y /feeds/meta.csv, generados por models.RunCatalogFeedJob
+func catalogFeed(c echo.Context) error {
+	path := models.CatalogFeedPath(c.Param("file"))
+	if path == "" {
+		return echo.ErrNotFound
+	}
+	return c.File(path)
+}
@@ -0,0 +1,435 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	H "mercadillo-global/helpers"
+)
+
+// Configuración de los feeds de catálogo (Google Merchant Center y catálogos de Meta)
+var (
+	CatalogFeedsDir         = "feeds"
+	CatalogFeedRefreshEvery = 15 * time.Minute
+	CatalogFeedBatchSize    = 500
+	CatalogFeedTitle        = "Mercadillo Global"
+)
+
+// Archivos de los feeds en CatalogFeedsDir, servidos en /feeds/<archivo>
+const (
+	GoogleFeedFile = "google.xml"
+	MetaFeedFile   = "meta.csv"
+)
+
+// metaFeedColumns columnas del CSV de Meta
+var metaFeedColumns = []string{"id", "title", "description", "availability", "condition", "price", "sale_price", "link",
+	"image_link", "brand", "product_type", "shipping"}
+
+// CatalogFeedItem entrada ya generada de un producto activo en cada feed. Solo se regeneran las de productos que
+// cambiaron desde la última pasada; los archivos se arman concatenándolas
+type CatalogFeedItem struct {
+	ProductID  string    `json:"product_id" gorm:"type:char(36);primaryKey"`
+	GoogleItem string    `json:"google_item" gorm:"type:mediumtext;not null;comment:'<item> of the Google Merchant feed'"`
+	MetaRow    string    `json:"meta_row" gorm:"type:mediumtext;not null;comment:'Row of the Meta CSV feed'"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (CatalogFeedItem) TableName() string {
+	return "catalog_feed_items"
+}
+
+// googleFeedItem <item> del feed RSS 2.0 de Google Merchant Center
+type googleFeedItem struct {
+	XMLName      xml.Name             `xml:"item"`
+	ID           string               `xml:"g:id"`
+	Title        string               `xml:"g:title"`
+	Description  string               `xml:"g:description"`
+	Link         string               `xml:"g:link"`
+	ImageLink    string               `xml:"g:image_link,omitempty"`
+	Availability string               `xml:"g:availability"`
+	Condition    string               `xml:"g:condition"`
+	Price        string               `xml:"g:price"`
+	SalePrice    string               `xml:"g:sale_price,omitempty"`
+	Brand        string               `xml:"g:brand,omitempty"`
+	ProductType  string               `xml:"g:product_type,omitempty"`
+	Shipping     []googleFeedShipping `xml:"g:shipping"`
+}
+
+type googleFeedShipping struct {
+	Country        string `xml:"g:country"`
+	Region         string `xml:"g:region,omitempty"`
+	Price          string `xml:"g:price"`
+	MinTransitTime *int   `xml:"g:min_transit_time,omitempty"`
+	MaxTransitTime *int   `xml:"g:max_transit_time,omitempty"`
+}
+
+// catalogFeedShipping costo de envío del producto a un país (y estado, si el costo se limita a algunos)
+type catalogFeedShipping struct {
+	Country  string
+	Region   string
+	Price    float64
+	Currency string
+	MinDays  *int
+	MaxDays  *int
+}
+
+// RunCatalogFeedJob regenera los feeds cada CatalogFeedRefreshEvery; baseURL es la URL pública del sitio para
+// los enlaces de productos e imágenes
+func RunCatalogFeedJob(getDB func() *gorm.DB, baseURL string) {
+	for {
+		if err := RefreshCatalogFeeds(getDB(), baseURL); err != nil {
+			log.Println("Error refreshing catalog feeds: ", err)
+		}
+		time.Sleep(CatalogFeedRefreshEvery)
+	}
+}
+
+// RefreshCatalogFeeds regenera las entradas de los productos activos que cambiaron (o cuyos almacenes, costos de
+// envío o atributos cambiaron) desde la última pasada, borra las de productos que ya no están activos y, si hubo
+// cambios, vuelve a escribir los archivos. La última pasada es la fecha de modificación del feed de Google; sin
+// archivos o sin entradas se regenera todo
+func RefreshCatalogFeeds(db *gorm.DB, baseURL string) error {
+	startedAt := time.Now()
+	since := catalogFeedsGeneratedAt(db)
+
+	var productIDs []string
+	err := db.Raw(`SELECT p.id FROM products p WHERE p.status = 'active' AND (p.updated_at >= ?
+			OR EXISTS (SELECT 1 FROM product_warehouses pw WHERE pw.product_id = p.id AND pw.updated_at >= ?)
+			OR EXISTS (SELECT 1 FROM shipping_costs sc JOIN product_warehouses pw ON pw.id = sc.product_warehouse_id
+				WHERE pw.product_id = p.id AND sc.updated_at >= ?)
+			OR EXISTS (SELECT 1 FROM product_attributes pa WHERE pa.product_id = p.id AND pa.updated_at >= ?))
+		ORDER BY p.id`, since, since, since, since).
+		Scan(&productIDs).Error
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(productIDs); start += CatalogFeedBatchSize {
+		end := start + CatalogFeedBatchSize
+		if end > len(productIDs) {
+			end = len(productIDs)
+		}
+		if err := refreshCatalogFeedItems(db, productIDs[start:end], baseURL); err != nil {
+			return err
+		}
+	}
+
+	removed := db.Exec("DELETE FROM catalog_feed_items WHERE product_id NOT IN (SELECT id FROM products WHERE status = 'active')")
+	if removed.Error != nil {
+		return removed.Error
+	}
+
+	if len(productIDs) == 0 && removed.RowsAffected == 0 && !since.IsZero() {
+		return nil
+	}
+	return writeCatalogFeeds(db, baseURL, startedAt)
+}
+
+// catalogFeedsGeneratedAt inicio de la pasada que escribió los feeds actuales (cero para regenerar todo)
+func catalogFeedsGeneratedAt(db *gorm.DB) time.Time {
+	var items int64
+	if err := db.Model(&CatalogFeedItem{}).Count(&items).Error; err != nil || items == 0 {
+		return time.Time{}
+	}
+	google, err := os.Stat(filepath.Join(CatalogFeedsDir, GoogleFeedFile))
+	if err != nil {
+		return time.Time{}
+	}
+	if _, err := os.Stat(filepath.Join(CatalogFeedsDir, MetaFeedFile)); err != nil {
+		return time.Time{}
+	}
+	return google.ModTime()
+}
+
+// refreshCatalogFeedItems genera y guarda las entradas de los productos
+func refreshCatalogFeedItems(db *gorm.DB, productIDs []string, baseURL string) error {
+	var products []Product
+	err := db.Preload("ProductCategories").
+		Preload("Attributes", "product_warehouse_id IS NULL").
+		Preload("Warehouses.Warehouse").
+		Preload("Warehouses.ShippingCosts", "is_active = ?", true).
+		Where("id IN ? AND status = ?", productIDs, "active").
+		Find(&products).Error
+	if err != nil {
+		return err
+	}
+	if len(products) == 0 {
+		return nil
+	}
+
+	items := make([]CatalogFeedItem, 0, len(products))
+	for _, product := range products {
+		item, err := buildCatalogFeedItem(product, baseURL)
+		if err != nil {
+			return fmt.Errorf("product %s: %w", product.ID, err)
+		}
+		items = append(items, item)
+	}
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&items).Error
+}
+
+// buildCatalogFeedItem entradas del producto en ambos feeds
+func buildCatalogFeedItem(product Product, baseURL string) (CatalogFeedItem, error) {
+	price, salePrice := product.Price, 0
+	if product.OriginalPrice > product.Price {
+		price, salePrice = product.OriginalPrice, product.Price
+	}
+	availability := "out of stock"
+	if product.Stock > 0 {
+		availability = "in stock"
+	}
+	description := H.Trim(product.Description)
+	if description == "" {
+		description = product.Title
+	}
+	categoryPath := make([]string, 0)
+	for _, category := range GetCategoryPath(productPrimaryCategoryID(product)) {
+		categoryPath = append(categoryPath, category.Name)
+	}
+
+	google := googleFeedItem{
+		ID:           product.ID,
+		Title:        truncateRunes(product.Title, 150),
+		Description:  truncateRunes(description, 5000),
+		Link:         catalogFeedURL(baseURL, "/product/"+product.ID),
+		ImageLink:    catalogFeedURL(baseURL, product.FirstImage()),
+		Availability: availability,
+		Condition:    "new",
+		Price:        catalogFeedPrice(float64(price), product.CurrencyID),
+		Brand:        catalogFeedBrand(product),
+		ProductType:  strings.Join(categoryPath, " > "),
+	}
+	if salePrice > 0 {
+		google.SalePrice = catalogFeedPrice(float64(salePrice), product.CurrencyID)
+	}
+
+	shipping := catalogFeedShippings(product)
+	metaShipping := make([]string, 0, len(shipping))
+	for _, cost := range shipping {
+		google.Shipping = append(google.Shipping, googleFeedShipping{
+			Country:        cost.Country,
+			Region:         cost.Region,
+			Price:          catalogFeedPrice(cost.Price, cost.Currency),
+			MinTransitTime: cost.MinDays,
+			MaxTransitTime: cost.MaxDays,
+		})
+		metaShipping = append(metaShipping, fmt.Sprintf("%s:%s:Standard:%s", cost.Country, cost.Region,
+			catalogFeedPrice(cost.Price, cost.Currency)))
+	}
+
+	googleItem, err := xml.Marshal(google)
+	if err != nil {
+		return CatalogFeedItem{}, err
+	}
+
+	var metaRow bytes.Buffer
+	writer := csv.NewWriter(&metaRow)
+	writer.Write([]string{google.ID, google.Title, google.Description, google.Availability, google.Condition, google.Price,
+		google.SalePrice, google.Link, google.ImageLink, google.Brand, google.ProductType, strings.Join(metaShipping, ",")})
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return CatalogFeedItem{}, err
+	}
+
+	return CatalogFeedItem{ProductID: product.ID, GoogleItem: string(googleItem), MetaRow: metaRow.String()}, nil
+}
+
+// catalogFeedShippings costos de envío activos del producto, el más barato por país y estado. Con envío gratis
+// el costo es 0 donde el producto llega
+func catalogFeedShippings(product Product) []catalogFeedShipping {
+	cheapest := make(map[string]catalogFeedShipping)
+	for _, productWarehouse := range product.Warehouses {
+		if !productWarehouse.Warehouse.IsActive {
+			continue
+		}
+		for _, shippingCost := range productWarehouse.ShippingCosts {
+			price := shippingCost.Cost
+			if shippingCost.PriceType == "per_kg" {
+				price = shippingCost.Cost * productWarehouse.Weight
+			}
+			if product.FreeShipping {
+				price = 0
+			}
+
+			regions := []string{""}
+			var locations []ShippingLocation
+			if json.Unmarshal([]byte(shippingCost.Locations), &locations) == nil && len(locations) > 0 {
+				regions = regions[:0]
+				for _, location := range locations {
+					regions = append(regions, location.State)
+				}
+			}
+
+			country := strings.ToUpper(shippingCost.Country)
+			for _, region := range regions {
+				key := country + ":" + region
+				if current, ok := cheapest[key]; ok && current.Price <= price {
+					continue
+				}
+				cheapest[key] = catalogFeedShipping{
+					Country:  country,
+					Region:   region,
+					Price:    price,
+					Currency: shippingCost.CurrencyID,
+					MinDays:  shippingCost.EstimatedDaysMin,
+					MaxDays:  shippingCost.EstimatedDaysMax,
+				}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(cheapest))
+	for key := range cheapest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	shipping := make([]catalogFeedShipping, 0, len(keys))
+	for _, key := range keys {
+		shipping = append(shipping, cheapest[key])
+	}
+	return shipping
+}
+
+// catalogFeedBrand valor del atributo global "marca"
+func catalogFeedBrand(product Product) string {
+	for _, attribute := range product.Attributes {
+		if attribute.AttributeSlug != "marca" || attribute.ProductWarehouseID != nil {
+			continue
+		}
+		var brand string
+		if json.Unmarshal([]byte(attribute.Value), &brand) == nil {
+			return H.Trim(brand)
+		}
+		return H.Trim(strings.Trim(attribute.Value, `"`))
+	}
+	return ""
+}
+
+// catalogFeedPrice precio en el formato de ambos feeds: "1200.00 USD"
+func catalogFeedPrice(price float64, currencyID string) string {
+	if currencyID == "" {
+		currencyID = "USD"
+	}
+	return fmt.Sprintf("%.2f %s", price, strings.ToUpper(currencyID))
+}
+
+// catalogFeedURL URL absoluta con baseURL (las que ya son absolutas quedan igual)
+func catalogFeedURL(baseURL, path string) string {
+	if path == "" || strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	return strings.TrimRight(baseURL, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// writeCatalogFeeds escribe los dos archivos leyendo las entradas por lotes, para no cargar el catálogo completo
+// en memoria. Cada archivo se escribe aparte y reemplaza al anterior al terminar, así que los que se están
+// sirviendo nunca quedan a medias. La fecha de modificación queda en generatedAt (ver catalogFeedsGeneratedAt)
+func writeCatalogFeeds(db *gorm.DB, baseURL string, generatedAt time.Time) error {
+	if err := os.MkdirAll(CatalogFeedsDir, 0o755); err != nil {
+		return err
+	}
+
+	err := writeCatalogFeedFile(MetaFeedFile, generatedAt, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		writer.Write(metaFeedColumns)
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+		return streamCatalogFeedItems(db, func(item CatalogFeedItem) error {
+			_, err := io.WriteString(w, item.MetaRow)
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// El de Google va último: su fecha marca la pasada completa
+	return writeCatalogFeedFile(GoogleFeedFile, generatedAt, func(w io.Writer) error {
+		header := xml.Header + `<rss version="2.0" xmlns:g="http://base.google.com/ns/1.0">` + "\n<channel>\n"
+		var title, link bytes.Buffer
+		xml.EscapeText(&title, []byte(CatalogFeedTitle))
+		xml.EscapeText(&link, []byte(catalogFeedURL(baseURL, "/")))
+		header += "<title>" + title.String() + "</title>\n<link>" + link.String() + "</link>\n<description>" +
+			title.String() + "</description>\n"
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+		err := streamCatalogFeedItems(db, func(item CatalogFeedItem) error {
+			_, err := io.WriteString(w, item.GoogleItem+"\n")
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "</channel>\n</rss>\n")
+		return err
+	})
+}
+
+// writeCatalogFeedFile escribe el archivo en uno temporal y lo renombra al terminar
+func writeCatalogFeedFile(name string, modTime time.Time, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(CatalogFeedsDir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	buffered := bufio.NewWriter(tmp)
+	if err := write(buffered); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), modTime, modTime); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(CatalogFeedsDir, name))
+}
+
+// streamCatalogFeedItems recorre las entradas por lotes de CatalogFeedBatchSize, en orden de producto
+func streamCatalogFeedItems(db *gorm.DB, write func(item CatalogFeedItem) error) error {
+	var batch []CatalogFeedItem
+	var writeErr error
+	result := db.Order("product_id").FindInBatches(&batch, CatalogFeedBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, item := range batch {
+			if writeErr = write(item); writeErr != nil {
+				return writeErr
+			}
+		}
+		return nil
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	return result.Error
+}
+
+// CatalogFeedPath archivo del feed para servirlo; "" si el nombre no es uno de los feeds
+func CatalogFeedPath(name string) string {
+	if name != GoogleFeedFile && name != MetaFeedFile {
+		return ""
+	}
+	return filepath.Join(CatalogFeedsDir, name)
+}
@@ -28,10 +28,11 @@ type CategoryFlat struct {
 
 // Global category system - loaded once at startup
 var (
-	categoriesMap    map[string]*Category
-	categoriesList   []Category
-	categoriesFlat   []CategoryFlat
-	categoriesLoaded bool
+	categoriesMap     map[string]*Category
+	categoriesFlatMap map[string]CategoryFlat
+	categoriesList    []Category
+	categoriesFlat    []CategoryFlat
+	categoriesLoaded  bool
 )
 
 // InitializeCategories loads categories once at startup
@@ -54,6 +55,7 @@ func InitializeCategories() error {
 
 	// Initialize the global map
 	categoriesMap = make(map[string]*Category)
+	categoriesFlatMap = make(map[string]CategoryFlat)
 	categoriesList = make([]Category, 0)
 	categoriesFlat = make([]CategoryFlat, 0)
 
@@ -74,12 +76,14 @@ func processCategoryTree(category *Category, parentID string, level int) {
 	categoriesMap[category.ID] = category
 
 	// Add to flat list for UI purposes
-	categoriesFlat = append(categoriesFlat, CategoryFlat{
+	flat := CategoryFlat{
 		ID:       category.ID,
 		Name:     category.Name,
 		ParentID: parentID,
 		Level:    level,
-	})
+	}
+	categoriesFlat = append(categoriesFlat, flat)
+	categoriesFlatMap[category.ID] = flat
 
 	// Process children
 	if category.Children != nil {
@@ -105,6 +109,20 @@ func GetFlatCategories() []CategoryFlat {
 	return categoriesFlat
 }
 
+// GetCategoryPath returns the ancestor chain from the root category down to the given category (inclusive)
+func GetCategoryPath(categoryID string) []CategoryFlat {
+	path := make([]CategoryFlat, 0)
+	current, ok := categoriesFlatMap[categoryID]
+	for ok {
+		path = append([]CategoryFlat{current}, path...)
+		if current.ParentID == "" {
+			break
+		}
+		current, ok = categoriesFlatMap[current.ParentID]
+	}
+	return path
+}
+
 // GetCategoryAttributes returns the attributes for a specific category - O(1) lookup
 func GetCategoryAttributes(categoryID string) []string {
 	category := GetCategoryByID(categoryID)
@@ -113,3 +131,59 @@ func GetCategoryAttributes(categoryID string) []string {
 	}
 	return []string{}
 }
+
+// GetCategoryDescendantIDs returns the category ID followed by the IDs of all its descendants
+func GetCategoryDescendantIDs(categoryID string) []string {
+	category := GetCategoryByID(categoryID)
+	if category == nil {
+		return []string{categoryID}
+	}
+
+	ids := make([]string, 0)
+	collectCategoryIDs(category, &ids)
+	return ids
+}
+
+// ExpandCategoryIDs expands every category into itself plus its descendants, without duplicates
+func ExpandCategoryIDs(categoryIDs []string) []string {
+	seen := make(map[string]bool)
+	expanded := make([]string, 0, len(categoryIDs))
+	for _, categoryID := range categoryIDs {
+		for _, id := range GetCategoryDescendantIDs(categoryID) {
+			if !seen[id] {
+				seen[id] = true
+				expanded = append(expanded, id)
+			}
+		}
+	}
+	return expanded
+}
+
+// collectCategoryIDs recursively walks the category tree collecting IDs
+func collectCategoryIDs(category *Category, ids *[]string) {
+	*ids = append(*ids, category.ID)
+	for _, child := range category.Children {
+		collectCategoryIDs(child, ids)
+	}
+}
+
+// GetFilterableAttributes returns the attributes of a category, or the union of its descendants' attributes
+// when the category itself declares none (parent categories)
+func GetFilterableAttributes(categoryID string) []string {
+	attributes := GetCategoryAttributes(categoryID)
+	if len(attributes) > 0 {
+		return attributes
+	}
+
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+	for _, id := range GetCategoryDescendantIDs(categoryID) {
+		for _, attribute := range GetCategoryAttributes(id) {
+			if !seen[attribute] {
+				seen[attribute] = true
+				result = append(result, attribute)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,336 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	H "mercadillo-global/helpers"
+)
+
+// FacetCounts conteos por faceta: id de la faceta -> valor de la opción -> cantidad de productos
+type FacetCounts map[string]map[string]int64
+
+// PriceBuckets rangos de precio de la faceta de precio en formato "min-max" (max vacío = sin límite)
+var PriceBuckets = []string{"0-10", "10-50", "50-100", "100-500", "500-"}
+
+// Umbrales acumulados de las facetas (rating >= n, reviews/ventas >= n, 0 = ninguna)
+var (
+	ratingThresholds   = []int{4, 3, 2}
+	quantityThresholds = []int{3, 1, 0}
+)
+
+// facetRow fila de agregación: valor de la opción y total de productos
+type facetRow struct {
+	Value *string `gorm:"column:facet_value"`
+	Total int64   `gorm:"column:facet_total"`
+}
+
+// facetScope construye la consulta base ("products p" con sus filtros) excluyendo el filtro de la faceta indicada
+type facetScope func(exclude string) *gorm.DB
+
+// ParsePriceBucket convierte un rango "10-50" en los filtros de precio mínimo y máximo. Los rangos son
+// semiabiertos (10 <= precio < 50) y los filtros incluyen el máximo, así que el máximo queda en 49
+func ParsePriceBucket(bucket string) (*int, *int, bool) {
+	minPrice, maxPrice, ok := priceBucketBounds(bucket)
+	if ok && maxPrice != nil {
+		inclusiveMax := *maxPrice - 1
+		maxPrice = &inclusiveMax
+	}
+	return minPrice, maxPrice, ok
+}
+
+// priceInBucket indica si el precio cae en el rango (min <= precio < max)
+func priceInBucket(price int, bucket string) bool {
+	minPrice, maxPrice, ok := priceBucketBounds(bucket)
+	return ok && (minPrice == nil || price >= *minPrice) && (maxPrice == nil || price < *maxPrice)
+}
+
+// priceBucketBounds límites de un rango "min-max" (nil = sin límite); el máximo no se incluye
+func priceBucketBounds(bucket string) (*int, *int, bool) {
+	parts := strings.SplitN(bucket, "-", 2)
+	if len(parts) != 2 {
+		return nil, nil, false
+	}
+
+	var minPrice, maxPrice *int
+	if parts[0] != "" {
+		value, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, nil, false
+		}
+		minPrice = &value
+	}
+	if parts[1] != "" {
+		value, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, nil, false
+		}
+		maxPrice = &value
+	}
+	return minPrice, maxPrice, true
+}
+
+// GetChildCategoryIDs devuelve los IDs de las subcategorías directas de una categoría
+func GetChildCategoryIDs(categoryID string) []string {
+	category := GetCategoryByID(categoryID)
+	if category == nil {
+		return []string{}
+	}
+
+	ids := make([]string, 0, len(category.Children))
+	for id := range category.Children {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// GetCategoryFacets calcula las facetas de la página de categoría usando la misma consulta base que GetProductsByCategoryCursor
+func GetCategoryFacets(db *gorm.DB, categoryID string, filters CategoryFilters) (FacetCounts, error) {
+	scope := func(exclude string) *gorm.DB {
+		return applyCategoryFilters(categoryBaseQuery(db, categoryID), withoutCategoryFilter(filters, exclude))
+	}
+	facets, err := aggregateFacets(db, scope, GetChildCategoryIDs(categoryID))
+	if err != nil {
+		return nil, err
+	}
+
+	// Atributos de la categoría: valores distintos presentes (en atributos o en opciones de variantes) y su
+	// cantidad de productos
+	for _, attribute := range GetFilterableAttributes(categoryID) {
+		slug := H.Slugify(attribute)
+		facetID := AttributeFacetID(slug)
+		values := db.Raw("SELECT pa.product_id, JSON_UNQUOTE(pa.value) AS facet_value FROM product_attributes pa"+
+			" WHERE pa.attribute_slug = ? AND JSON_TYPE(pa.value) IN ?"+
+			" UNION ALL SELECT v.product_id, JSON_UNQUOTE(JSON_EXTRACT(v.options, ?)) FROM product_variants v"+
+			" WHERE JSON_EXTRACT(v.options, ?) IS NOT NULL",
+			slug, attributeScalarTypes, variantOptionPath(slug), variantOptionPath(slug))
+		var rows []facetRow
+		err := db.Table("(?) AS f", values).
+			Where("f.product_id IN (?)", scope(facetID).Select("p.id")).
+			Select("f.facet_value, COUNT(DISTINCT f.product_id) AS facet_total").
+			Group("f.facet_value").
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		facets[facetID] = rowsToCounts(rows)
+	}
+
+	return facets, nil
+}
+
+// attributeScalarTypes tipos JSON de product_attributes.value que se pueden filtrar como texto
+var attributeScalarTypes = []string{"STRING", "INTEGER", "UNSIGNED INTEGER", "DOUBLE", "DECIMAL", "BOOLEAN"}
+
+// AttributeFacetID id de la faceta de un atributo, igual al nombre del parámetro de la URL (attr[marca])
+func AttributeFacetID(slug string) string {
+	return "attr[" + slug + "]"
+}
+
+// ParseAttributeFacetID extrae el slug de un parámetro attr[slug]
+func ParseAttributeFacetID(param string) (string, bool) {
+	if strings.HasPrefix(param, "attr[") && strings.HasSuffix(param, "]") && len(param) > len("attr[]") {
+		return param[len("attr[") : len(param)-1], true
+	}
+	return "", false
+}
+
+// withoutCategoryFilter copia los filtros quitando el de la faceta indicada
+// para que cada faceta cuente las opciones alternativas a la seleccionada
+func withoutCategoryFilter(filters CategoryFilters, exclude string) CategoryFilters {
+	switch exclude {
+	case "price":
+		filters.PriceMin = nil
+		filters.PriceMax = nil
+	case "rating":
+		filters.Rating = nil
+	case "reviews":
+		filters.Reviews = nil
+	case "sales":
+		filters.Sales = nil
+	case "shipping":
+		filters.FreeShipping = nil
+	default:
+		if slug, ok := ParseAttributeFacetID(exclude); ok {
+			attributes := make(map[string][]string, len(filters.Attributes))
+			for key, values := range filters.Attributes {
+				if key != slug {
+					attributes[key] = values
+				}
+			}
+			filters.Attributes = attributes
+		}
+	}
+	return filters
+}
+
+// getSearchFacets calcula las facetas de búsqueda usando la misma condición de coincidencia que produjo los resultados
+func getSearchFacets(db *gorm.DB, query string, filters SearchFilters, mode int) (FacetCounts, error) {
+	scope := func(exclude string) *gorm.DB {
+		return applySearchFilters(applySearchMatch(db.Table("products p"), query, mode), withoutSearchFilter(filters, exclude))
+	}
+
+	return aggregateFacets(db, scope, searchFacetCategoryIDs(filters))
+}
+
+// searchFacetCategoryIDs opciones de la faceta de categoría: con una sola categoría seleccionada sus hijas,
+// sin categorías las raíz
+func searchFacetCategoryIDs(filters SearchFilters) []string {
+	var categoryIDs []string
+	if len(filters.Categories) == 1 {
+		categoryIDs = GetChildCategoryIDs(filters.Categories[0])
+	} else if len(filters.Categories) == 0 {
+		for _, category := range GetCategories() {
+			categoryIDs = append(categoryIDs, category.ID)
+		}
+	}
+	return categoryIDs
+}
+
+// withoutSearchFilter copia los filtros de búsqueda quitando el de la faceta indicada
+func withoutSearchFilter(filters SearchFilters, exclude string) SearchFilters {
+	switch exclude {
+	case "price":
+		filters.MinPrice = nil
+		filters.MaxPrice = nil
+	case "rating":
+		filters.MinRating = nil
+	case "reviews":
+		filters.Reviews = nil
+	case "sales":
+		filters.Sales = nil
+	case "shipping":
+		filters.FreeShipping = nil
+	}
+	return filters
+}
+
+// aggregateFacets ejecuta una consulta agrupada por faceta y devuelve los conteos
+func aggregateFacets(db *gorm.DB, scope facetScope, categoryIDs []string) (FacetCounts, error) {
+	facets := make(FacetCounts)
+
+	// Precio: rangos semiabiertos, igual que priceInBucket y el filtro de ParsePriceBucket
+	priceCase := "CASE"
+	for _, bucket := range PriceBuckets {
+		minPrice, maxPrice, _ := priceBucketBounds(bucket)
+		conditions := []string{"TRUE"}
+		if minPrice != nil {
+			conditions = append(conditions, fmt.Sprintf("p.price >= %d", *minPrice))
+		}
+		if maxPrice != nil {
+			conditions = append(conditions, fmt.Sprintf("p.price < %d", *maxPrice))
+		}
+		priceCase += fmt.Sprintf(" WHEN %s THEN '%s'", strings.Join(conditions, " AND "), bucket)
+	}
+	priceCase += " END"
+	rows, err := scanFacet(scope("price"), priceCase, "COUNT(*)")
+	if err != nil {
+		return nil, err
+	}
+	facets["price"] = rows
+
+	// Calificación: umbrales acumulados (4 o más incluye 5)
+	rows, err = scanFacet(scope("rating"), "FLOOR(p.rating)", "COUNT(*)")
+	if err != nil {
+		return nil, err
+	}
+	facets["rating"] = cumulativeCounts(rows, ratingThresholds)
+
+	// Reviews y ventas: 0 = ninguna, resto acumulado
+	rows, err = scanFacet(scope("reviews"), "LEAST(p.review_count, 3)", "COUNT(*)")
+	if err != nil {
+		return nil, err
+	}
+	facets["reviews"] = cumulativeCounts(rows, quantityThresholds)
+
+	rows, err = scanFacet(scope("sales"), "LEAST(p.sold, 3)", "COUNT(*)")
+	if err != nil {
+		return nil, err
+	}
+	facets["sales"] = cumulativeCounts(rows, quantityThresholds)
+
+	// Envío
+	rows, err = scanFacet(scope("shipping"), "CASE WHEN p.free_shipping THEN 'free' ELSE 'nonfree' END", "COUNT(*)")
+	if err != nil {
+		return nil, err
+	}
+	facets["shipping"] = rows
+
+	// Subcategorías: cada producto se cuenta una vez por subárbol de categoría
+	if len(categoryIDs) > 0 {
+		categoryCase := "CASE"
+		args := make([]interface{}, 0, len(categoryIDs)*2)
+		allIDs := make([]string, 0)
+		for _, id := range categoryIDs {
+			descendants := GetCategoryDescendantIDs(id)
+			categoryCase += " WHEN fpc.category_id IN ? THEN ?"
+			args = append(args, descendants, id)
+			allIDs = append(allIDs, descendants...)
+		}
+		categoryCase += " END"
+
+		// Se agrega sobre una tabla derivada para que los filtros sin alias no choquen con product_categories
+		var categoryRows []facetRow
+		err = db.Table("(?) AS fp", scope("").Select("p.id")).
+			Joins("INNER JOIN product_categories fpc ON fpc.product_id = fp.id").
+			Where("fpc.category_id IN ?", allIDs).
+			Select(categoryCase+" AS facet_value, COUNT(DISTINCT fp.id) AS facet_total", args...).
+			Group("facet_value").
+			Scan(&categoryRows).Error
+		if err != nil {
+			return nil, err
+		}
+		facets["category"] = rowsToCounts(categoryRows)
+	}
+
+	return facets, nil
+}
+
+// scanFacet agrupa la consulta por la expresión indicada
+func scanFacet(query *gorm.DB, valueExpr string, totalExpr string) (map[string]int64, error) {
+	var rows []facetRow
+	err := query.
+		Select(valueExpr + " AS facet_value, " + totalExpr + " AS facet_total").
+		Group("facet_value").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return rowsToCounts(rows), nil
+}
+
+// rowsToCounts convierte las filas agregadas en un mapa valor -> total
+func rowsToCounts(rows []facetRow) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, row := range rows {
+		if row.Value != nil {
+			counts[*row.Value] += row.Total
+		}
+	}
+	return counts
+}
+
+// cumulativeCounts convierte conteos por valor exacto en conteos ">= umbral" (el umbral 0 significa "exactamente 0")
+func cumulativeCounts(exact map[string]int64, thresholds []int) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, threshold := range thresholds {
+		key := strconv.Itoa(threshold)
+		for value, total := range exact {
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			if threshold == 0 {
+				if number == 0 {
+					counts[key] += total
+				}
+			} else if int(number) >= threshold {
+				counts[key] += total
+			}
+		}
+	}
+	return counts
+}
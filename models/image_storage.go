@@ -0,0 +1,100 @@
+package models
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidStorageKey la clave sale del almacenamiento (.., rutas absolutas)
+var ErrInvalidStorageKey = errors.New("invalid storage key")
+
+// ImageStorage dónde se guardan los archivos de las imágenes. Las claves son rutas relativas con "/"
+// ("products/<id>/<imagen>-card.jpg") y URL devuelve la dirección pública del archivo
+type ImageStorage interface {
+	Put(key string, data []byte, contentType string) error
+	Delete(key string) error
+	URL(key string) string
+}
+
+var (
+	imageStorageMu sync.RWMutex
+	imageStorage   ImageStorage
+)
+
+// SetImageStorage reemplaza el almacenamiento global de imágenes (nil = disco local en ProductImagesDir)
+func SetImageStorage(storage ImageStorage) {
+	imageStorageMu.Lock()
+	defer imageStorageMu.Unlock()
+	imageStorage = storage
+}
+
+// GetImageStorage devuelve el almacenamiento configurado o el disco local servido en /uploads
+func GetImageStorage() ImageStorage {
+	imageStorageMu.RLock()
+	defer imageStorageMu.RUnlock()
+	if imageStorage != nil {
+		return imageStorage
+	}
+	return NewLocalImageStorage(ProductImagesDir, "/uploads")
+}
+
+// LocalImageStorage archivos en un directorio del disco, servidos como estáticos bajo BaseURL
+type LocalImageStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+// NewLocalImageStorage crea el almacenamiento en dir con las URL bajo baseURL
+func NewLocalImageStorage(dir, baseURL string) *LocalImageStorage {
+	return &LocalImageStorage{Dir: dir, BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Put escribe el archivo en un temporal y lo renombra, así nunca se sirve a medias
+func (s *LocalImageStorage) Put(key string, data []byte, contentType string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Delete borra el archivo; si ya no existe no es un error
+func (s *LocalImageStorage) Delete(key string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// URL dirección pública del archivo
+func (s *LocalImageStorage) URL(key string) string {
+	return s.BaseURL + "/" + strings.TrimLeft(path.Clean("/"+key), "/")
+}
+
+// path ruta del archivo en el disco, siempre dentro de Dir
+func (s *LocalImageStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean == "/" || clean != "/"+key {
+		return "", ErrInvalidStorageKey
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(clean[1:])), nil
+}
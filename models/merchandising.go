@@ -0,0 +1,266 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+
+	H "mercadillo-global/helpers"
+)
+
+// Estrategias de un slot de merchandising
+const (
+	SlotStrategyManual   = "manual"   // Lista fija de productos (ProductIDs) en ese orden
+	SlotStrategyRule     = "rule"     // Consulta según Rule
+	SlotStrategyRotating = "rotating" // Pool de Rule que rota cada RotateMinutes con una semilla determinística
+)
+
+// MerchandisingCacheDuration tiempo máximo que se reutilizan los productos calculados de un slot
+var MerchandisingCacheDuration = 5 * time.Minute
+
+// merchandisingCache productos calculados de cada slot
+var merchandisingCache = H.NewCache()
+
+// errInvalidMerchandisingSlot slot mal configurado (regla o product_ids que no son JSON válido)
+var errInvalidMerchandisingSlot = errors.New("invalid merchandising slot")
+
+// MerchandisingSlot espacio con nombre de la portada (hero, featured, deals, new-arrivals) y su estrategia
+type MerchandisingSlot struct {
+	ID            string     `json:"id" gorm:"type:char(36);primaryKey"`
+	Slug          string     `json:"slug" gorm:"type:varchar(100);not null;index"`
+	Title         string     `json:"title" gorm:"type:varchar(255);not null"`
+	Strategy      string     `json:"strategy" gorm:"type:enum('manual','rule','rotating');default:'rule'"`
+	ProductIDs    string     `json:"product_ids" gorm:"type:json;comment:'Manual strategy: ordered product IDs'"`
+	Rule          string     `json:"rule" gorm:"type:json;comment:'Rule and rotating strategies: MerchandisingRule'"`
+	PoolSize      int        `json:"pool_size" gorm:"default:100"`
+	RotateMinutes int        `json:"rotate_minutes" gorm:"default:60"`
+	MaxProducts   int        `json:"max_products" gorm:"default:10"`
+	Position      int        `json:"position" gorm:"default:0;index"`
+	StartsAt      *time.Time `json:"starts_at"`
+	EndsAt        *time.Time `json:"ends_at"`
+	IsActive      bool       `json:"is_active" gorm:"default:true;index"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// MerchandisingRule filtros y orden de los slots por regla o rotativos
+type MerchandisingRule struct {
+	CategoryID    string   `json:"category_id,omitempty"` // Incluye subcategorías
+	MinPrice      *int     `json:"min_price,omitempty"`
+	MaxPrice      *int     `json:"max_price,omitempty"`
+	MinRating     *float64 `json:"min_rating,omitempty"`
+	MinDiscount   int      `json:"min_discount,omitempty"` // Porcentaje mínimo de descuento sobre original_price
+	FreeShipping  *bool    `json:"free_shipping,omitempty"`
+	InStock       bool     `json:"in_stock,omitempty"`
+	NewerThanDays int      `json:"newer_than_days,omitempty"`
+	SortBy        string   `json:"sort_by,omitempty"` // rating (defecto), sales, newest, discount, price_asc, price_desc
+}
+
+// MerchandisingSlotProducts slot con los productos que le tocan en este momento
+type MerchandisingSlotProducts struct {
+	Slot     MerchandisingSlot
+	Products []Product
+}
+
+// DefaultMerchandisingSlots slots usados mientras no haya ninguno configurado en merchandising_slots
+var DefaultMerchandisingSlots = []MerchandisingSlot{
+	{ID: "default-featured", Slug: "featured", Title: "Productos destacados", Strategy: SlotStrategyRotating,
+		Rule: `{"in_stock":true,"sort_by":"rating"}`, PoolSize: 100, RotateMinutes: 60, MaxProducts: 12, IsActive: true},
+	{ID: "default-deals", Slug: "deals", Title: "Ofertas", Strategy: SlotStrategyRule,
+		Rule: `{"in_stock":true,"min_discount":10,"sort_by":"discount"}`, MaxProducts: 6, Position: 1, IsActive: true},
+	{ID: "default-new-arrivals", Slug: "new-arrivals", Title: "Recién llegados", Strategy: SlotStrategyRule,
+		Rule: `{"in_stock":true,"newer_than_days":30,"sort_by":"newest"}`, MaxProducts: 6, Position: 2, IsActive: true},
+}
+
+func (ms *MerchandisingSlot) BeforeCreate(tx *gorm.DB) error {
+	if H.IsEmpty(ms.ID) {
+		ms.ID = H.NewUUID()
+	}
+	return nil
+}
+
+// GetActiveMerchandisingSlots slots activos y vigentes en la fecha indicada, por posición
+func GetActiveMerchandisingSlots(db *gorm.DB, now time.Time) ([]MerchandisingSlot, error) {
+	var slots []MerchandisingSlot
+	err := db.Where("is_active = ? AND (starts_at IS NULL OR starts_at <= ?) AND (ends_at IS NULL OR ends_at > ?)", true, now, now).
+		Order("position, slug").
+		Find(&slots).Error
+	return slots, err
+}
+
+// GetHomeMerchandising slots vigentes (o los por defecto si no hay ninguno configurado) con sus productos; omite los
+// vacíos y los mal configurados, que quedan en el log
+func GetHomeMerchandising(db *gorm.DB, now time.Time) ([]MerchandisingSlotProducts, error) {
+	slots, err := GetActiveMerchandisingSlots(db, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(slots) == 0 {
+		slots = DefaultMerchandisingSlots
+	}
+
+	result := make([]MerchandisingSlotProducts, 0, len(slots))
+	for _, slot := range slots {
+		products, err := slot.GetProducts(db, now)
+		if errors.Is(err, errInvalidMerchandisingSlot) {
+			log.Println("Skipping merchandising slot ", slot.Slug, ": ", err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("merchandising slot %s: %w", slot.Slug, err)
+		}
+		if len(products) > 0 {
+			result = append(result, MerchandisingSlotProducts{Slot: slot, Products: products})
+		}
+	}
+	return result, nil
+}
+
+// GetProducts productos del slot en el momento indicado. El resultado es estable dentro de cada ventana de
+// rotación, así que se cachea por slot y ventana
+func (ms *MerchandisingSlot) GetProducts(db *gorm.DB, now time.Time) ([]Product, error) {
+	bucket := ms.rotationBucket(now)
+	cacheKey := fmt.Sprintf("%s:%d:%d", ms.ID, ms.UpdatedAt.Unix(), bucket)
+	if products, ok := merchandisingCache.Get(cacheKey).([]Product); ok {
+		return products, nil
+	}
+
+	var products []Product
+	var err error
+	switch ms.Strategy {
+	case SlotStrategyManual:
+		products, err = ms.manualProducts(db)
+	case SlotStrategyRotating:
+		products, err = ms.rotatingProducts(db, bucket)
+	default:
+		products, err = ms.ruleProducts(db, ms.maxProducts())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	merchandisingCache.Set(cacheKey, products, MerchandisingCacheDuration)
+	return products, nil
+}
+
+// manualProducts productos activos de ProductIDs en el orden configurado
+func (ms *MerchandisingSlot) manualProducts(db *gorm.DB) ([]Product, error) {
+	var ids []string
+	if !H.IsEmpty(ms.ProductIDs) {
+		if err := json.Unmarshal([]byte(ms.ProductIDs), &ids); err != nil {
+			return nil, fmt.Errorf("%w: product_ids: %v", errInvalidMerchandisingSlot, err)
+		}
+	}
+	if len(ids) > ms.maxProducts() {
+		ids = ids[:ms.maxProducts()]
+	}
+	return getActiveProductsByIDs(db, ids)
+}
+
+// rotatingProducts toma MaxProducts del pool de la regla mezclado con una semilla del slot y la ventana actual:
+// todas las instancias muestran lo mismo durante la ventana
+func (ms *MerchandisingSlot) rotatingProducts(db *gorm.DB, bucket int64) ([]Product, error) {
+	poolSize := ms.PoolSize
+	if poolSize <= 0 {
+		poolSize = 100
+	}
+	pool, err := ms.ruleProducts(db, poolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	seed := fnv.New64a()
+	fmt.Fprintf(seed, "%s:%d", ms.ID, bucket)
+	random := rand.New(rand.NewSource(int64(seed.Sum64())))
+	random.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+
+	if len(pool) > ms.maxProducts() {
+		pool = pool[:ms.maxProducts()]
+	}
+	return pool, nil
+}
+
+// ruleProducts productos activos que cumplen la regla del slot
+func (ms *MerchandisingSlot) ruleProducts(db *gorm.DB, limit int) ([]Product, error) {
+	var rule MerchandisingRule
+	if !H.IsEmpty(ms.Rule) {
+		if err := json.Unmarshal([]byte(ms.Rule), &rule); err != nil {
+			return nil, fmt.Errorf("%w: rule: %v", errInvalidMerchandisingSlot, err)
+		}
+	}
+
+	query := db.Table("products p").Select("p.*").Where("p.status = ?", "active")
+	if rule.CategoryID != "" {
+		query = query.Where("p.id IN (SELECT pc.product_id FROM product_categories pc WHERE pc.category_id IN ?)",
+			GetCategoryDescendantIDs(rule.CategoryID))
+	}
+	if rule.MinPrice != nil {
+		query = query.Where("p.price >= ?", *rule.MinPrice)
+	}
+	if rule.MaxPrice != nil {
+		query = query.Where("p.price <= ?", *rule.MaxPrice)
+	}
+	if rule.MinRating != nil {
+		query = query.Where("p.rating >= ?", *rule.MinRating)
+	}
+	if rule.MinDiscount > 0 {
+		query = query.Where("p.original_price > 0 AND (p.original_price - p.price) * 100 >= ? * p.original_price", rule.MinDiscount)
+	}
+	if rule.FreeShipping != nil {
+		query = query.Where("p.free_shipping = ?", *rule.FreeShipping)
+	}
+	if rule.InStock {
+		query = query.Where("p.stock > 0")
+	}
+	if rule.NewerThanDays > 0 {
+		query = query.Where("p.created_at >= ?", time.Now().AddDate(0, 0, -rule.NewerThanDays))
+	}
+
+	products := make([]Product, 0)
+	err := query.Order(merchandisingOrderBy(rule.SortBy)).Limit(limit).Find(&products).Error
+	return products, err
+}
+
+// merchandisingOrderBy orden de una regla; siempre termina en p.id para que el resultado sea determinístico
+func merchandisingOrderBy(sortBy string) string {
+	switch sortBy {
+	case "sales":
+		return "p.sold DESC, p.rating DESC, p.id"
+	case "newest":
+		return "p.created_at DESC, p.id"
+	case "discount":
+		return "CASE WHEN p.original_price > 0 THEN (p.original_price - p.price) / p.original_price ELSE 0 END DESC, p.id"
+	case "price_asc":
+		return "p.price ASC, p.id"
+	case "price_desc":
+		return "p.price DESC, p.id"
+	default:
+		return "p.rating DESC, p.review_count DESC, p.created_at DESC, p.id"
+	}
+}
+
+// rotationBucket ventana de rotación actual (0 para estrategias que no rotan)
+func (ms *MerchandisingSlot) rotationBucket(now time.Time) int64 {
+	if ms.Strategy != SlotStrategyRotating {
+		return 0
+	}
+	minutes := ms.RotateMinutes
+	if minutes <= 0 {
+		minutes = 60
+	}
+	return now.Unix() / int64(minutes*60)
+}
+
+// maxProducts máximo de productos del slot (10 si no está configurado)
+func (ms *MerchandisingSlot) maxProducts() int {
+	if ms.MaxProducts <= 0 {
+		return 10
+	}
+	return ms.MaxProducts
+}
@@ -3,7 +3,13 @@ package models
 type Filter struct {
 	ID      string
 	Name    string
-	Options map[string]string
+	Options []FilterOption
+}
+
+type FilterOption struct {
+	Value string
+	Label string
+	Count int64
 }
 
 type Pagination struct {
@@ -15,32 +21,81 @@ type Pagination struct {
 }
 
 type HomePageData struct {
-	Title            string
-	FeaturedProducts []EnrichedProduct
-	Categories       []Category
-	PageTemplate     string
+	Title          string
+	Slots          []HomeSlot // Slots de merchandising vigentes, en orden
+	Categories     []Category
+	RecentlyViewed []EnrichedProduct
+	PageTemplate   string
+}
+
+// HomeSlot slot de merchandising con sus productos listos para las tarjetas
+type HomeSlot struct {
+	Slug     string
+	Title    string
+	Products []EnrichedProduct
 }
 
 type CategoryPageData struct {
-	Title        string
-	CategoryId   string
-	CategoryName string
-	Products     []EnrichedProduct
-	Filters      []Filter
-	Pagination   Pagination
-	PageTemplate string
+	Title           string
+	CategoryId      string
+	CategoryName    string
+	Breadcrumbs     []CategoryFlat
+	Products        []EnrichedProduct
+	Filters         []Filter
+	Pagination      Pagination
+	Bestsellers     []EnrichedProduct // "Más vendidos" de la categoría (ventas recientes)
+	PrevPageURL     string
+	NextPageURL     string
+	ShipTo          ShipTo
+	ShipToCountries []FilterOption
+	PageTemplate    string
 }
 
 type ProductPageData struct {
-	Title        string
-	Product      EnrichedProduct
-	Questions    []Question
-	Reviews      []Review
-	PageTemplate string
+	Title           string
+	Product         EnrichedProduct
+	Breadcrumbs     []CategoryFlat
+	Questions       []Question
+	Reviews         []Review
+	SimilarProducts []EnrichedProduct // Misma categoría, atributos en común y precio similar
+	SellerProducts  []EnrichedProduct // Más publicaciones del vendedor
+	RecentlyViewed  []EnrichedProduct // Vistos recientemente por el visitante (sin el producto actual)
+	StockError      string            // No se pudo apartar el stock al pulsar "Comprar ahora"
+	Purchased       bool              // Se acaba de confirmar la compra en el checkout
+	MaxQuantity     int               // Unidades por compra (StockReservationMaxQuantity)
+	CSRFToken       string
+	PageTemplate    string
 }
 
 type CheckoutPageData struct {
-	Title        string
-	Product      EnrichedProduct
-	PageTemplate string
+	Title          string
+	Product        EnrichedProduct
+	Variant        *VariantView      // Variante elegida, si el producto tiene
+	Reservation    *StockReservation // Stock apartado para el visitante
+	Quantity       int
+	FormattedPrice string // Precio unitario (el de la variante si lo cambia)
+	FormattedTotal string
+	CSRFToken      string
+	PageTemplate   string
+}
+
+type SearchPageData struct {
+	Title           string
+	Query           string
+	Products        []EnrichedProduct
+	Filters         SearchFilters
+	Facets          []Filter
+	Total           int64
+	TotalCapped     bool
+	Suggestion      string // Corrección ortográfica ("¿Quisiste decir...?")
+	Corrected       bool   // Los resultados son de Suggestion y no de Query
+	SuggestionURL   string
+	ExactURL        string
+	Page            int
+	TotalPages      int
+	PrevPageURL     string
+	NextPageURL     string
+	ShipTo          ShipTo
+	ShipToCountries []FilterOption
+	PageTemplate    string
 }
@@ -3,6 +3,7 @@ package models
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
 
 	"gorm.io/gorm"
@@ -14,7 +15,8 @@ type Product struct {
 	ID             string    `json:"id" gorm:"type:char(36);primaryKey"`
 	ShortKey       string    `json:"short_key" gorm:"type:varchar(20);uniqueIndex;not null"`
 	Slug           string    `json:"slug" gorm:"type:varchar(255);uniqueIndex;not null"`
-	UserID         string    `json:"user_id" gorm:"type:char(36);not null;index"`
+	UserID         string    `json:"user_id" gorm:"type:char(36);not null;index;uniqueIndex:idx_products_user_sku,priority:1"`
+	SKU            *string   `json:"sku" gorm:"type:varchar(100);uniqueIndex:idx_products_user_sku,priority:2;comment:'Seller reference, unique per seller'"`
 	Title          string    `json:"title" gorm:"type:varchar(500);not null"`
 	Price          int       `json:"price" gorm:"not null"`
 	OriginalPrice  int       `json:"original_price" gorm:"default:0"`
@@ -33,16 +35,22 @@ type Product struct {
 	Status         string    `json:"status" gorm:"type:enum('active','wait_for_ia','wait_for_human_review','pause','draft');default:'draft'"`
 	KYC            bool      `json:"kyc" gorm:"default:false"`
 	FromCompany    bool      `json:"from_company" gorm:"default:false"`
+	TrendingScore  float64   `json:"trending_score" gorm:"default:0;index;comment:'Time-decayed popularity from views, sales and reviews'"`
+	SoldSnapshot   *int      `json:"-" gorm:"comment:'Sold already counted in product_daily_stats'"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
 
+	// Puntaje calculado por las consultas de búsqueda (columna "relevance"), no existe en la tabla
+	Relevance float64 `json:"relevance,omitempty" gorm:"->;-:migration"`
+
 	// Relations
 	User              User               `json:"user" gorm:"foreignKey:UserID"`
 	Questions         []Question         `json:"questions" gorm:"foreignKey:ProductID"`
 	Reviews           []Review           `json:"reviews" gorm:"foreignKey:ProductID"`
 	Attributes        []ProductAttribute `json:"attributes" gorm:"foreignKey:ProductID"`
 	Warehouses        []ProductWarehouse `json:"warehouses" gorm:"foreignKey:ProductID"`
-	Categories        []Category         `json:"categories" gorm:"many2many:product_categories;"`
+	Variants          []ProductVariant   `json:"variants" gorm:"foreignKey:ProductID"`
+	Categories        []Category         `json:"categories" gorm:"-"` // De categories.json según ProductCategories, no es una tabla
 	ProductCategories []ProductCategory  `json:"product_categories" gorm:"foreignKey:ProductID"`
 }
 
@@ -56,7 +64,7 @@ type ProductCategory struct {
 
 	// Relations
 	Product  Product  `json:"product" gorm:"foreignKey:ProductID"`
-	Category Category `json:"category" gorm:"foreignKey:CategoryID"`
+	Category Category `json:"category" gorm:"-"` // De categories.json
 }
 
 type ProductAttribute struct {
@@ -88,12 +96,16 @@ type EnrichedProduct struct {
 	ShippingOptions        []ShippingCost       `json:"shipping_options"`
 	PrimaryCategory        *Category            `json:"primary_category"` // La categoría principal del producto
 	AllCategories          map[string]*Category `json:"all_categories"`   // Todas las categorías del producto
+	Link                   string               `json:"-"`                // Enlace de la tarjeta si no es /product/{id} (ej. con ?sq= de búsqueda)
+	Image                  string               `json:"image"`            // Primera imagen del producto
+	Variants               []VariantView        `json:"variants"`         // Variantes para el selector de la página de producto
+	VariantOptions         []VariantOption      `json:"variant_options"`
 }
 
 // Specification struct for JSON serialization
 type Specification struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
+	Name  string `json:"name" validate:"required,max=100"`
+	Value string `json:"value" validate:"required,max=500"`
 }
 
 // ProductVariation estructura para variaciones de producto
@@ -106,13 +118,15 @@ type ProductVariation struct {
 
 // CategoryFilters estructura para filtros de categoría
 type CategoryFilters struct {
-	PriceMin     *int   `json:"price_min,omitempty"`
-	PriceMax     *int   `json:"price_max,omitempty"`
-	Rating       *int   `json:"rating,omitempty"`
-	Reviews      *int   `json:"reviews,omitempty"`
-	Sales        *int   `json:"sales,omitempty"`
-	FreeShipping *bool  `json:"free_shipping,omitempty"`
-	SortBy       string `json:"sort_by,omitempty"`
+	PriceMin     *int                `json:"price_min,omitempty"`
+	PriceMax     *int                `json:"price_max,omitempty"`
+	Rating       *int                `json:"rating,omitempty"`
+	Reviews      *int                `json:"reviews,omitempty"`
+	Sales        *int                `json:"sales,omitempty"`
+	FreeShipping *bool               `json:"free_shipping,omitempty"`
+	SortBy       string              `json:"sort_by,omitempty"`
+	Attributes   map[string][]string `json:"attributes,omitempty"` // slug del atributo -> valores aceptados (attr[marca]=...)
+	ShipTo       *ShipTo             `json:"ship_to,omitempty"`    // Solo productos que llegan a este destino
 }
 
 // GORM Hooks
@@ -137,6 +151,15 @@ func (pc *ProductCategory) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// FirstImage primera imagen del producto ("" si no tiene)
+func (p Product) FirstImage() string {
+	var images []string
+	if json.Unmarshal([]byte(p.Images), &images) != nil || len(images) == 0 {
+		return ""
+	}
+	return images[0]
+}
+
 // GenerateSearchContent genera contenido optimizado para búsqueda usando IA
 func (p *Product) GenerateSearchContent() {
 	// Esta función debería integrarse con tu servicio de IA
@@ -178,18 +201,43 @@ func (p *Product) GenerateSearchContent() {
 		// keywords += spec.Value + ", "
 	}
 
-	// Generar search_content optimizado
-	p.SearchContent = fmt.Sprintf("%s %s %s %s",
+	// Generar search_content optimizado, normalizado igual que las consultas (acentos, raíces, palabras vacías)
+	p.SearchContent = NormalizeSearchContent(fmt.Sprintf("%s %s %s %s",
 		p.Title,
 		categoryNames,
 		p.Description,
-		specText)
+		specText))
 
-	// Generar keywords
+	// Generar keywords, normalizadas como search_content
 	p.SearchKeywords = fmt.Sprintf("%s, %s, %s",
 		p.Title,
 		categoryNames,
 		keywords)
+	p.SearchKeywords = NormalizeSearchKeywords(p.SearchKeywords)
+}
+
+// getActiveProductsByIDs productos activos con los IDs indicados, en el mismo orden (los que no existen se omiten)
+func getActiveProductsByIDs(db *gorm.DB, ids []string) ([]Product, error) {
+	if len(ids) == 0 {
+		return []Product{}, nil
+	}
+
+	var products []Product
+	if err := db.Where("id IN ? AND status = ?", ids, "active").Find(&products).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+	ordered := make([]Product, 0, len(products))
+	for _, id := range ids {
+		if product, ok := byID[id]; ok {
+			ordered = append(ordered, product)
+		}
+	}
+	return ordered, nil
 }
 
 // GetProductWithWarehouses obtiene un producto con todos sus almacenes y atributos
@@ -205,7 +253,6 @@ func GetProductWithWarehouses(db *gorm.DB, productID string) (*EnrichedProduct,
 		Preload("Warehouses.Attributes.ProductWarehouse").
 		Preload("Attributes").
 		Preload("Attributes.ProductWarehouse").
-		Preload("Categories").
 		Preload("ProductCategories").
 		Preload("Questions").
 		Preload("Questions.QuestionVotes").
@@ -287,95 +334,213 @@ func calculateDiscount(originalPrice, price int) int {
 }
 
 // GetProductsByCategoryCursor versión ultra-optimizada usando cursor pagination encriptado
-// Más eficiente para millones de registros que OFFSET/LIMIT
-func GetProductsByCategoryCursor(db *gorm.DB, categoryID string, encryptedCursor string, limit int, filters CategoryFilters) ([]Product, string, bool, error) {
+// Más eficiente para millones de registros que OFFSET/LIMIT. Un cursor con dirección "prev"
+// devuelve la página anterior leyendo el keyset en orden inverso
+func GetProductsByCategoryCursor(db *gorm.DB, categoryID string, encryptedCursor string, limit int, filters CategoryFilters) ([]Product, Pagination, error) {
 	var products []Product
+	pagination := Pagination{ItemsPerPage: limit}
 
-	query := db.Table("products p").
-		Select("p.*").
-		Joins("INNER JOIN product_categories pc ON p.id = pc.product_id").
-		Where("pc.category_id = ? AND p.status = ?", categoryID, "active")
+	query := categoryBaseQuery(db, categoryID).Select("p.*")
 
 	// Desencriptar cursor si existe para obtener datos de paginación
-	var cursorData H.CursorData
-	if encryptedCursor != "" {
-		if decoded, err := H.DecryptCursor(encryptedCursor); err == nil {
-			cursorData = decoded
-		}
-		// Si hay error al desencriptar, cursorData permanece vacío y se inicia desde el principio
+	// Un cursor inválido, expirado o generado con otros filtros se rechaza (H.ErrInvalidCursor)
+	cursorData, err := H.DecryptCursor(encryptedCursor)
+	if err != nil {
+		return nil, pagination, err
 	}
 
-	// Aplicar filtros combinando filtros de usuario y condiciones de cursor
-	query = applyCategoryFiltersWithCursor(query, filters, cursorData)
+	// Los filtros de atributos viajan dentro del cursor cuando la URL no los trae
+	if len(filters.Attributes) == 0 && len(cursorData.Attributes) > 0 {
+		filters.Attributes = cursorData.Attributes
+	}
 
-	// Determinar orden basado en filtros
-	orderBy := "p.created_at DESC"
-	if filters.SortBy != "" {
-		switch filters.SortBy {
-		case "price_asc":
-			orderBy = "p.price ASC, p.created_at DESC"
-		case "price_desc":
-			orderBy = "p.price DESC, p.created_at DESC"
-		case "rating":
-			orderBy = "p.rating DESC, p.created_at DESC"
-		case "sales":
-			orderBy = "p.sold DESC, p.created_at DESC"
-		case "newest":
-			orderBy = "p.created_at DESC"
-		}
+	if cursorData.Timestamp != "" && cursorData.FilterHash != categoryCursorHash(categoryID, filters) {
+		return nil, pagination, H.ErrCursorFilterMismatch
 	}
 
-	// Obtener productos con orden
-	err := query.Order(orderBy).
+	backward := cursorData.Timestamp != "" && cursorData.Direction == H.CursorDirectionPrev
+
+	// Aplicar filtros combinando filtros de usuario y condiciones de cursor
+	query = applyCategoryFiltersWithCursor(query, filters, cursorData)
+
+	// Obtener productos con orden (invertido si se pide la página anterior)
+	err = query.Order(categoryOrderBy(filters.SortBy, backward)).
 		Limit(limit + 1). // +1 para saber si hay más páginas
 		Find(&products).Error
 
 	if err != nil {
-		return nil, "", false, err
+		return nil, pagination, err
 	}
 
-	// Determinar si hay más páginas
+	// Determinar si hay más páginas en la dirección consultada
 	hasMore := len(products) > limit
 	if hasMore {
 		products = products[:limit] // Remover el elemento extra
 	}
 
-	// Generar cursor encriptado para la siguiente página
-	var nextEncryptedCursor string
-	if hasMore && len(products) > 0 {
-		lastProduct := products[len(products)-1]
-
-		// Crear datos del cursor basado en el tipo de ordenamiento
-		cursorData := H.CursorData{
-			Timestamp: lastProduct.CreatedAt.Format("2006-01-02T15:04:05Z"),
-			SortBy:    filters.SortBy,
+	if backward {
+		// Se leyó en orden inverso: voltear para mostrar en el orden normal
+		for i, j := 0, len(products)-1; i < j; i, j = i+1, j-1 {
+			products[i], products[j] = products[j], products[i]
 		}
+		pagination.HasPrev = hasMore
+		pagination.HasNext = len(products) > 0 // El producto del cursor queda en la página siguiente
+	} else {
+		pagination.HasNext = hasMore
+		pagination.HasPrev = cursorData.Timestamp != "" // Si hay cursor, hay página anterior
+	}
 
-		// Agregar campo específico según el ordenamiento
-		switch filters.SortBy {
-		case "price_asc", "price_desc":
-			cursorData.Price = &lastProduct.Price
-		case "rating":
-			cursorData.Rating = &lastProduct.Rating
-		case "sales":
-			cursorData.Sold = &lastProduct.Sold
+	// Generar cursores encriptados hacia ambos lados
+	if len(products) > 0 {
+		if pagination.HasNext {
+			pagination.NextCursor = encryptCategoryCursor(categoryID, products[len(products)-1], filters, H.CursorDirectionNext)
+		}
+		if pagination.HasPrev {
+			pagination.PrevCursor = encryptCategoryCursor(categoryID, products[0], filters, H.CursorDirectionPrev)
 		}
+	}
+
+	return products, pagination, nil
+}
+
+// encryptCategoryCursor genera el cursor encriptado que apunta al producto indicado en la dirección dada
+func encryptCategoryCursor(categoryID string, product Product, filters CategoryFilters, direction string) string {
+	// Crear datos del cursor basado en el tipo de ordenamiento
+	cursorData := H.CursorData{
+		Timestamp:  product.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		SortBy:     filters.SortBy,
+		Direction:  direction,
+		FilterHash: categoryCursorHash(categoryID, filters),
+		Attributes: filters.Attributes,
+		ID:         product.ID,
+	}
+
+	// Agregar campo específico según el ordenamiento
+	switch filters.SortBy {
+	case "price_asc", "price_desc":
+		cursorData.Price = &product.Price
+	case "rating":
+		cursorData.Rating = &product.Rating
+	case "sales":
+		cursorData.Sold = &product.Sold
+	case "trending":
+		cursorData.Trending = &product.TrendingScore
+	}
+
+	encryptedCursor, err := H.EncryptCursor(cursorData)
+	if err != nil {
+		// En caso de error al encriptar, continuar sin cursor
+		// En producción deberías loggear este error
+		return ""
+	}
+	return encryptedCursor
+}
+
+// categoryCursorHash hash de la categoría y filtros (incluido el orden) a los que queda atado un cursor
+func categoryCursorHash(categoryID string, filters CategoryFilters) string {
+	return H.CursorFilterHash(struct {
+		CategoryID string          `json:"category_id"`
+		Filters    CategoryFilters `json:"filters"`
+	}{categoryID, filters})
+}
+
+// categorySortKey columna principal del ordenamiento y si es ascendente ("" = solo por fecha)
+func categorySortKey(sortBy string) (string, bool) {
+	switch sortBy {
+	case "price_asc":
+		return "p.price", true
+	case "price_desc":
+		return "p.price", false
+	case "rating":
+		return "p.rating", false
+	case "sales":
+		return "p.sold", false
+	case "trending":
+		return "p.trending_score", false
+	}
+	return "", false
+}
 
-		// Encriptar cursor
-		nextEncryptedCursor, err = H.EncryptCursor(cursorData)
-		if err != nil {
-			// En caso de error al encriptar, continuar sin cursor
-			// En producción deberías loggear este error
-			nextEncryptedCursor = ""
+// categoryOrderBy orden del listado; termina en p.id para desempatar productos creados en el mismo segundo. En
+// reversa se invierten todas las columnas del keyset
+func categoryOrderBy(sortBy string, reverse bool) string {
+	direction := func(ascending bool) string {
+		if ascending != reverse {
+			return "ASC"
 		}
+		return "DESC"
 	}
 
-	return products, nextEncryptedCursor, hasMore, nil
+	orderBy := "p.created_at " + direction(false) + ", p.id " + direction(false)
+	if column, ascending := categorySortKey(sortBy); column != "" {
+		orderBy = column + " " + direction(ascending) + ", " + orderBy
+	}
+	return orderBy
+}
+
+// categoryBaseQuery consulta base de productos activos de una categoría y todas sus subcategorías
+// Se usa subconsulta en lugar de JOIN para no duplicar productos asignados a varias categorías
+func categoryBaseQuery(db *gorm.DB, categoryID string) *gorm.DB {
+	return db.Table("products p").
+		Where("p.id IN (SELECT pc.product_id FROM product_categories pc WHERE pc.category_id IN ?) AND p.status = ?",
+			GetCategoryDescendantIDs(categoryID), "active")
 }
 
 // applyCategoryFiltersWithCursor aplica filtros de categoría junto con condiciones de cursor de forma integrada
 func applyCategoryFiltersWithCursor(query *gorm.DB, filters CategoryFilters, cursorData H.CursorData) *gorm.DB {
 	// PASO 1: Aplicar SIEMPRE todos los filtros del usuario (sin importar el cursor)
+	query = applyCategoryFilters(query, filters)
+
+	// PASO 2: Aplicar condición de cursor SOLO para paginación (AND con los filtros de arriba)
+	if cursorData.Timestamp != "" {
+		// Hacia adelante se continúa después del cursor; hacia atrás se toman los anteriores
+		backward := cursorData.Direction == H.CursorDirectionPrev
+		timestampOp := "<"
+		if backward {
+			timestampOp = ">"
+		}
+
+		// Fecha y desempate por id (los cursores generados antes del desempate no traen id)
+		timestampSQL := "p.created_at " + timestampOp + " ?"
+		timestampArgs := []interface{}{cursorData.Timestamp}
+		if cursorData.ID != "" {
+			timestampSQL = fmt.Sprintf("(p.created_at %s ? OR (p.created_at = ? AND p.id %s ?))", timestampOp, timestampOp)
+			timestampArgs = append(timestampArgs, cursorData.Timestamp, cursorData.ID)
+		}
+
+		column, ascending := categorySortKey(filters.SortBy)
+		var value interface{}
+		switch {
+		case (filters.SortBy == "price_asc" || filters.SortBy == "price_desc") && cursorData.Price != nil:
+			value = *cursorData.Price
+		case filters.SortBy == "rating" && cursorData.Rating != nil:
+			value = *cursorData.Rating
+		case filters.SortBy == "sales" && cursorData.Sold != nil:
+			value = *cursorData.Sold
+		case filters.SortBy == "trending" && cursorData.Trending != nil:
+			value = *cursorData.Trending
+		}
+
+		if column != "" && value != nil {
+			// Ej. price_desc hacia adelante: price < cursor_price OR (price = cursor_price AND
+			// (created_at < cursor_timestamp OR (created_at = cursor_timestamp AND id < cursor_id)))
+			valueOp := "<"
+			if ascending != backward {
+				valueOp = ">"
+			}
+			query = query.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND %s))", column, valueOp, column, timestampSQL),
+				append([]interface{}{value, value}, timestampArgs...)...)
+		} else {
+			// Para ordenamiento por fecha o sin ordenamiento específico
+			query = query.Where(timestampSQL, timestampArgs...)
+		}
+	}
+
+	return query
+}
+
+// applyCategoryFilters aplica los filtros del usuario sin condiciones de cursor
+func applyCategoryFilters(query *gorm.DB, filters CategoryFilters) *gorm.DB {
 	if filters.PriceMin != nil {
 		query = query.Where("p.price >= ?", *filters.PriceMin)
 	}
@@ -402,45 +567,23 @@ func applyCategoryFiltersWithCursor(query *gorm.DB, filters CategoryFilters, cur
 	if filters.FreeShipping != nil {
 		query = query.Where("p.free_shipping = ?", *filters.FreeShipping)
 	}
+	if filters.ShipTo != nil && filters.ShipTo.Country != "" {
+		shipToSQL, args := shipToProductIDsSQL(*filters.ShipTo)
+		query = query.Where("(p.is_service = TRUE OR p.id IN ("+shipToSQL+"))", args...)
+	}
 
-	// PASO 2: Aplicar condición de cursor SOLO para paginación (AND con los filtros de arriba)
-	if cursorData.Timestamp != "" {
-		switch filters.SortBy {
-		case "price_asc":
-			if cursorData.Price != nil {
-				// Continuar desde donde quedamos: price > cursor_price OR (price = cursor_price AND created_at < cursor_timestamp)
-				query = query.Where("(p.price > ? OR (p.price = ? AND p.created_at < ?))",
-					*cursorData.Price, *cursorData.Price, cursorData.Timestamp)
-			} else {
-				query = query.Where("p.created_at < ?", cursorData.Timestamp)
-			}
-		case "price_desc":
-			if cursorData.Price != nil {
-				// Continuar desde donde quedamos: price < cursor_price OR (price = cursor_price AND created_at < cursor_timestamp)
-				query = query.Where("(p.price < ? OR (p.price = ? AND p.created_at < ?))",
-					*cursorData.Price, *cursorData.Price, cursorData.Timestamp)
-			} else {
-				query = query.Where("p.created_at < ?", cursorData.Timestamp)
-			}
-		case "rating":
-			if cursorData.Rating != nil {
-				// Continuar desde donde quedamos: rating < cursor_rating OR (rating = cursor_rating AND created_at < cursor_timestamp)
-				query = query.Where("(p.rating < ? OR (p.rating = ? AND p.created_at < ?))",
-					*cursorData.Rating, *cursorData.Rating, cursorData.Timestamp)
-			} else {
-				query = query.Where("p.created_at < ?", cursorData.Timestamp)
-			}
-		case "sales":
-			if cursorData.Sold != nil {
-				// Continuar desde donde quedamos: sold < cursor_sold OR (sold = cursor_sold AND created_at < cursor_timestamp)
-				query = query.Where("(p.sold < ? OR (p.sold = ? AND p.created_at < ?))",
-					*cursorData.Sold, *cursorData.Sold, cursorData.Timestamp)
-			} else {
-				query = query.Where("p.created_at < ?", cursorData.Timestamp)
-			}
-		default:
-			// Para ordenamiento por fecha o sin ordenamiento específico
-			query = query.Where("p.created_at < ?", cursorData.Timestamp)
+	// Atributos: OR entre valores del mismo atributo, AND entre atributos distintos. También valen las opciones de
+	// las variantes (talla, color...), que ya no son filas de product_attributes
+	slugs := make([]string, 0, len(filters.Attributes))
+	for slug := range filters.Attributes {
+		slugs = append(slugs, slug)
+	}
+	sort.Strings(slugs)
+	for _, slug := range slugs {
+		if values := filters.Attributes[slug]; len(values) > 0 {
+			query = query.Where("(p.id IN (SELECT pa.product_id FROM product_attributes pa WHERE pa.attribute_slug = ? AND JSON_UNQUOTE(pa.value) IN ?)"+
+				" OR p.id IN (SELECT v.product_id FROM product_variants v WHERE JSON_UNQUOTE(JSON_EXTRACT(v.options, ?)) IN ?))",
+				slug, values, variantOptionPath(slug), values)
 		}
 	}
 
@@ -0,0 +1,619 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	H "mercadillo-global/helpers"
+)
+
+// Configuración de las imágenes de productos
+var (
+	ProductImagesDir          = "uploads"        // Directorio del almacenamiento local (servido en /uploads)
+	ProductImageMaxBytes      = int64(15 << 20)  // Tamaño máximo del archivo subido
+	ProductImageMaxPixels     = 25 * 1000 * 1000 // Evita descomprimir imágenes gigantes (hasta 4 bytes por píxel)
+	ProductImageMaxRendering  = 2                // Imágenes que se procesan a la vez; las demás subidas esperan
+	ProductImageMaxPerProduct = 20               // Igual que el máximo de images en ProductRequest
+	ProductImageQuality       = 82               // Calidad de los JPEG
+)
+
+var (
+	// productImageRendering semáforo de ProductImageMaxRendering: decodificar y reducir usa mucha memoria
+	productImageRenderingOnce sync.Once
+	productImageRendering     chan struct{}
+
+	// productImageRenditionsCache tamaños generados de cada imagen subida, por ID (no cambian una vez creada)
+	productImageRenditionsCache = H.NewCache()
+)
+
+// productImageRenditionsCacheDuration tiempo que se recuerdan los tamaños generados de una imagen
+const productImageRenditionsCacheDuration = 24 * time.Hour
+
+// ImageSize tamaño generado de cada imagen: el lado mayor queda en MaxSide px como mucho (nunca se agranda)
+type ImageSize struct {
+	Name    string
+	MaxSide int
+}
+
+// ProductImageSizes de mayor a menor: cada tamaño se reduce del anterior
+var ProductImageSizes = []ImageSize{
+	{Name: "zoom", MaxSide: 1200},
+	{Name: "card", MaxSide: 400},
+	{Name: "thumb", MaxSide: 160},
+}
+
+// ImageDimensions ancho y alto en px de un tamaño generado
+type ImageDimensions struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Formatos en que se guarda cada tamaño (el JPEG es el que va en Product.Images y el que entienden todos)
+const (
+	ImageFormatJPEG = "jpg"
+	ImageFormatWebP = "webp"
+)
+
+// Errores de las imágenes de productos
+var (
+	ErrInvalidImage         = errors.New("invalid image")
+	ErrProductImageNotFound = errors.New("product image not found")
+)
+
+// ProductImage imagen subida por el vendedor. Los archivos de cada tamaño y formato están en el ImageStorage
+// (ver productImageKey) y el JPEG "zoom" es la URL que se agrega a Product.Images
+type ProductImage struct {
+	ID          string    `json:"id" gorm:"type:char(36);primaryKey"`
+	ProductID   string    `json:"product_id" gorm:"type:char(36);not null;index"`
+	Width       int       `json:"width" gorm:"not null;comment:'Original width, already rotated'"`
+	Height      int       `json:"height" gorm:"not null"`
+	ContentType string    `json:"content_type" gorm:"type:varchar(20);not null;comment:'Type of the uploaded file'"`
+	Renditions  string    `json:"renditions" gorm:"type:json;comment:'Size name -> width and height of the generated files'"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (i *ProductImage) BeforeCreate(tx *gorm.DB) error {
+	if H.IsEmpty(i.ID) {
+		i.ID = H.NewUUID()
+	}
+	return nil
+}
+
+// URL dirección pública de un tamaño y formato de la imagen
+func (i ProductImage) URL(size, format string) string {
+	return GetImageStorage().URL(productImageKey(i.ProductID, i.ID, size, format))
+}
+
+// URLs todas las direcciones de la imagen: "card" (JPEG), "card.webp", etc.
+func (i ProductImage) URLs() map[string]string {
+	urls := make(map[string]string, len(ProductImageSizes)*2)
+	for _, size := range ProductImageSizes {
+		urls[size.Name] = i.URL(size.Name, ImageFormatJPEG)
+		urls[size.Name+"."+ImageFormatWebP] = i.URL(size.Name, ImageFormatWebP)
+	}
+	return urls
+}
+
+// RenditionDimensions ancho y alto de cada tamaño generado. Las imágenes subidas antes de guardar Renditions los
+// calculan del original con el mismo criterio que fitImage
+func (i ProductImage) RenditionDimensions() map[string]ImageDimensions {
+	dimensions := make(map[string]ImageDimensions, len(ProductImageSizes))
+	if !H.IsEmpty(i.Renditions) {
+		json.Unmarshal([]byte(i.Renditions), &dimensions)
+	}
+	for _, size := range ProductImageSizes {
+		if _, ok := dimensions[size.Name]; !ok && i.Width > 0 && i.Height > 0 {
+			width, height := fitSize(i.Width, i.Height, size.MaxSide)
+			dimensions[size.Name] = ImageDimensions{Width: width, Height: height}
+		}
+	}
+	return dimensions
+}
+
+// keys claves de todos los archivos de la imagen
+func (i ProductImage) keys() []string {
+	keys := make([]string, 0, len(ProductImageSizes)*2)
+	for _, size := range ProductImageSizes {
+		keys = append(keys, productImageKey(i.ProductID, i.ID, size.Name, ImageFormatJPEG),
+			productImageKey(i.ProductID, i.ID, size.Name, ImageFormatWebP))
+	}
+	return keys
+}
+
+// productImageKey clave de un archivo: products/<producto>/<imagen>-<tamaño>.<formato>
+func productImageKey(productID, imageID, size, format string) string {
+	return fmt.Sprintf("products/%s/%s-%s.%s", productID, imageID, size, format)
+}
+
+// imageDecoder lectura de un formato de imagen aceptado
+type imageDecoder struct {
+	decode       func(io.Reader) (image.Image, error)
+	decodeConfig func(io.Reader) (image.Config, error)
+}
+
+// productImageDecoders formatos aceptados, por el tipo que detecta http.DetectContentType (no se confía en la
+// extensión ni en el Content-Type del cliente)
+var productImageDecoders = map[string]imageDecoder{
+	"image/jpeg": {jpeg.Decode, jpeg.DecodeConfig},
+	"image/png":  {png.Decode, png.DecodeConfig},
+	"image/gif":  {gif.Decode, gif.DecodeConfig},
+	"image/webp": {webp.Decode, webp.DecodeConfig},
+}
+
+// imageRendition archivo generado de una imagen
+type imageRendition struct {
+	Key         string
+	Data        []byte
+	ContentType string
+}
+
+// GetProductImages imágenes subidas de un producto del vendedor
+func GetProductImages(db *gorm.DB, userID, productID string) ([]ProductImage, error) {
+	var count int64
+	if err := db.Model(&Product{}).Where("id = ? AND user_id = ?", productID, userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrProductNotFound
+	}
+
+	images := make([]ProductImage, 0)
+	err := db.Where("product_id = ?", productID).Order("created_at, id").Find(&images).Error
+	return images, err
+}
+
+// AddProductImage procesa la imagen subida (tipo real, rotación EXIF, tamaños en JPEG y WebP sin metadatos), guarda
+// los archivos y agrega la imagen al final de Product.Images. Si el producto está publicado vuelve a wait_for_ia
+func AddProductImage(db *gorm.DB, userID, productID string, file io.Reader) (*ProductImage, error) {
+	data, err := io.ReadAll(io.LimitReader(file, ProductImageMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > ProductImageMaxBytes {
+		return nil, fmt.Errorf("%w: the file is larger than %d MB", ErrInvalidImage, ProductImageMaxBytes>>20)
+	}
+
+	var count int64
+	if err := db.Model(&Product{}).Where("id = ? AND user_id = ?", productID, userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrProductNotFound
+	}
+
+	productImage := &ProductImage{ID: H.NewUUID(), ProductID: productID}
+	renditions, err := renderProductImageLimited(productImage, data)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := GetImageStorage()
+	for i, rendition := range renditions {
+		if err := storage.Put(rendition.Key, rendition.Data, rendition.ContentType); err != nil {
+			deleteImageFiles(productImage.keys()[:i])
+			return nil, err
+		}
+	}
+
+	var change *ProductStatusHistory
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var product Product
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "status", "images").First(&product, "id = ?", productID).Error
+		if err != nil {
+			return err
+		}
+		images := productImageURLs(product)
+		if len(images) >= ProductImageMaxPerProduct {
+			return fmt.Errorf("%w: the product already has %d images", ErrInvalidImage, len(images))
+		}
+		images = append(images, productImage.URL("zoom", ImageFormatJPEG))
+		if err := tx.Model(&product).Update("images", encodeJSON(images)).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(productImage).Error; err != nil {
+			return err
+		}
+
+		// Una imagen nueva en un producto publicado vuelve a revisión de la IA, como cualquier cambio de contenido
+		if product.Status == "active" || product.Status == "pause" {
+			seller := StatusActor{Type: ActorSeller, ID: userID}
+			change, err = ProductWorkflow.Apply(tx, product.ID, "wait_for_ia", seller, "content changed")
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		deleteImageFiles(productImage.keys())
+		return nil, err
+	}
+	if change != nil {
+		change.Fire()
+	}
+	return productImage, nil
+}
+
+// DeleteProductImage saca la imagen de Product.Images y borra sus archivos
+func DeleteProductImage(db *gorm.DB, userID, productID, imageID string) error {
+	var productImage ProductImage
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var product Product
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "images").
+			First(&product, "id = ? AND user_id = ?", productID, userID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrProductNotFound
+		}
+		if err != nil {
+			return err
+		}
+		err = tx.First(&productImage, "id = ? AND product_id = ?", imageID, productID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrProductImageNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		images := make([]string, 0)
+		for _, url := range productImageURLs(product) {
+			if productImageIDFromURL(url) != productImage.ID {
+				images = append(images, url)
+			}
+		}
+		if err := tx.Model(&product).Update("images", encodeJSON(images)).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&productImage).Error
+	})
+	if err != nil {
+		return err
+	}
+	deleteImageFiles(productImage.keys())
+	return nil
+}
+
+// PruneProductImages borra las imágenes subidas que ya no están en Product.Images (el vendedor las quitó con PUT,
+// PATCH o una importación). Va fuera de la transacción del guardado para no borrar archivos de un cambio que se
+// revierte; si falla, la próxima vez se vuelve a intentar
+func PruneProductImages(db *gorm.DB, productID string) error {
+	var product Product
+	if err := db.Select("id", "images").First(&product, "id = ?", productID).Error; err != nil {
+		return err
+	}
+	inUse := make(map[string]bool)
+	for _, url := range productImageURLs(product) {
+		inUse[productImageIDFromURL(url)] = true
+	}
+
+	var images []ProductImage
+	if err := db.Where("product_id = ?", productID).Find(&images).Error; err != nil {
+		return err
+	}
+	for _, productImage := range images {
+		if inUse[productImage.ID] {
+			continue
+		}
+		if err := db.Delete(&productImage).Error; err != nil {
+			return err
+		}
+		deleteImageFiles(productImage.keys())
+	}
+	return nil
+}
+
+// deleteProductImages borra todas las imágenes subidas del producto (al borrar el producto) y devuelve las claves
+// de sus archivos, que se borran después del commit
+func deleteProductImages(tx *gorm.DB, productID string) ([]string, error) {
+	var images []ProductImage
+	if err := tx.Where("product_id = ?", productID).Find(&images).Error; err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(images)*len(ProductImageSizes)*2)
+	for _, productImage := range images {
+		keys = append(keys, productImage.keys()...)
+	}
+	return keys, tx.Where("product_id = ?", productID).Delete(&ProductImage{}).Error
+}
+
+// deleteImageFiles borra archivos del almacenamiento; los errores solo se registran (quedan archivos huérfanos)
+func deleteImageFiles(keys []string) {
+	storage := GetImageStorage()
+	for _, key := range keys {
+		if err := storage.Delete(key); err != nil {
+			log.Println("Error deleting image file ", key, ": ", err)
+		}
+	}
+}
+
+// productImageURLs URLs de Product.Images
+func productImageURLs(product Product) []string {
+	images := make([]string, 0)
+	json.Unmarshal([]byte(product.Images), &images)
+	return images
+}
+
+// renderProductImageLimited renderProductImage con a lo sumo ProductImageMaxRendering imágenes a la vez
+func renderProductImageLimited(productImage *ProductImage, data []byte) ([]imageRendition, error) {
+	productImageRenderingOnce.Do(func() {
+		productImageRendering = make(chan struct{}, max(1, ProductImageMaxRendering))
+	})
+	productImageRendering <- struct{}{}
+	defer func() { <-productImageRendering }()
+	return renderProductImage(productImage, data)
+}
+
+// renderProductImage valida el archivo y genera todos los tamaños en JPEG y WebP. Como se reescriben los píxeles
+// no queda ningún metadato del original (EXIF, GPS); la orientación EXIF se aplica antes de perderla
+func renderProductImage(productImage *ProductImage, data []byte) ([]imageRendition, error) {
+	contentType := http.DetectContentType(data)
+	decoder, ok := productImageDecoders[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not a supported image type (JPEG, PNG, GIF or WebP)", ErrInvalidImage, contentType)
+	}
+	config, err := decoder.decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if config.Width < 1 || config.Height < 1 || config.Width*config.Height > ProductImageMaxPixels {
+		return nil, fmt.Errorf("%w: the image is %dx%d px, the maximum is %d megapixels",
+			ErrInvalidImage, config.Width, config.Height, ProductImageMaxPixels/1000000)
+	}
+	src, err := decoder.decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	orientation := 1
+	if contentType == "image/jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	productImage.ContentType = contentType
+	productImage.Width, productImage.Height = config.Width, config.Height
+	if orientation >= 5 {
+		productImage.Width, productImage.Height = config.Height, config.Width
+	}
+
+	// Los tamaños son cuadrados, así que se puede reducir antes de rotar (rotar lo chico es más barato)
+	renditions := make([]imageRendition, 0, len(ProductImageSizes)*2)
+	dimensions := make(map[string]ImageDimensions, len(ProductImageSizes))
+	var current image.Image = src
+	for _, size := range ProductImageSizes {
+		resized := fitImage(current, size.MaxSide)
+		current = resized
+		oriented := orientImage(resized, orientation)
+		dimensions[size.Name] = ImageDimensions{Width: oriented.Rect.Dx(), Height: oriented.Rect.Dy()}
+
+		var jpegData bytes.Buffer
+		if err := jpeg.Encode(&jpegData, oriented, &jpeg.Options{Quality: ProductImageQuality}); err != nil {
+			return nil, err
+		}
+		var webpData bytes.Buffer
+		if err := nativewebp.Encode(&webpData, oriented, nil); err != nil {
+			return nil, err
+		}
+		renditions = append(renditions,
+			imageRendition{Key: productImageKey(productImage.ProductID, productImage.ID, size.Name, ImageFormatJPEG), Data: jpegData.Bytes(), ContentType: "image/jpeg"},
+			imageRendition{Key: productImageKey(productImage.ProductID, productImage.ID, size.Name, ImageFormatWebP), Data: webpData.Bytes(), ContentType: "image/webp"})
+	}
+	productImage.Renditions = encodeJSON(dimensions)
+	return renditions, nil
+}
+
+// fitImage reduce la imagen para que su lado mayor sea maxSide (no la agranda) sobre fondo blanco, porque el JPEG
+// no tiene transparencia
+func fitImage(src image.Image, maxSide int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := fitSize(bounds.Dx(), bounds.Dy(), maxSide)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	return dst
+}
+
+// fitSize ancho y alto para que el lado mayor sea maxSide como mucho, con la misma proporción
+func fitSize(width, height, maxSide int) (int, int) {
+	if width <= maxSide && height <= maxSide {
+		return width, height
+	}
+	if width >= height {
+		return maxSide, max(1, height*maxSide/width)
+	}
+	return max(1, width*maxSide/height), maxSide
+}
+
+// orientImage aplica la orientación EXIF (1-8): espejos y giros para que la imagen se vea derecha sin el EXIF
+func orientImage(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Espejo horizontal
+				dx, dy = width-1-x, y
+			case 3: // 180°
+				dx, dy = width-1-x, height-1-y
+			case 4: // Espejo vertical
+				dx, dy = x, height-1-y
+			case 5: // Transpuesta
+				dx, dy = y, x
+			case 6: // 90° horario
+				dx, dy = height-1-y, x
+			case 7: // Transversa
+				dx, dy = height-1-y, width-1-x
+			case 8: // 90° antihorario
+				dx, dy = y, width-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation tag Orientation (0x0112) del EXIF de un JPEG, 1 si no tiene
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF: // Relleno
+			i++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // Marcadores sin longitud
+			i += 2
+			continue
+		case marker == 0xDA || marker == 0xD9: // Empiezan los datos de la imagen: ya no hay metadatos
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) >= 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation busca Orientation en el primer IFD del bloque TIFF del EXIF
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < entries; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if value := int(order.Uint16(tiff[entry+8:])); value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// productImageURLPattern URL de un archivo de imagen subida: el prefijo hasta el ID, el tamaño y el formato
+var productImageURLPattern = regexp.MustCompile(`^(.*/products/[^/]+/([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}))-([a-z]+)\.(jpg|webp)$`)
+
+// productImageIDFromURL ID de la imagen subida de la URL, "" si es una URL externa
+func productImageIDFromURL(url string) string {
+	if match := productImageURLPattern.FindStringSubmatch(url); match != nil {
+		return match[2]
+	}
+	return ""
+}
+
+// ImageURL URL de otro tamaño ("thumb", "card", "zoom") de una imagen subida. Las URL externas quedan igual
+func ImageURL(url, size string) string {
+	match := productImageURLPattern.FindStringSubmatch(url)
+	if match == nil {
+		return url
+	}
+	return match[1] + "-" + size + "." + ImageFormatJPEG
+}
+
+// ImageSrcset atributo srcset con los tamaños JPEG de una imagen subida ("" si es una URL externa)
+func ImageSrcset(db *gorm.DB, url string) string {
+	return imageSrcset(db, url, ImageFormatJPEG)
+}
+
+// ImageWebPSrcset srcset con los tamaños WebP, para <source type="image/webp"> ("" si es una URL externa)
+func ImageWebPSrcset(db *gorm.DB, url string) string {
+	return imageSrcset(db, url, ImageFormatWebP)
+}
+
+// imageSrcset srcset de un formato con el ancho real de cada tamaño. Las imágenes chicas no se agrandan, así que
+// varios tamaños pueden tener el mismo ancho: queda solo el más liviano
+func imageSrcset(db *gorm.DB, url, format string) string {
+	match := productImageURLPattern.FindStringSubmatch(url)
+	if match == nil {
+		return ""
+	}
+	dimensions := productImageRenditions(db, match[2])
+	candidates := make([]string, 0, len(ProductImageSizes))
+	widths := make(map[int]bool)
+	for i := len(ProductImageSizes) - 1; i >= 0; i-- {
+		size := ProductImageSizes[i]
+		width := dimensions[size.Name].Width
+		if width <= 0 || widths[width] {
+			continue
+		}
+		widths[width] = true
+		candidates = append(candidates, fmt.Sprintf("%s-%s.%s %dw", match[1], size.Name, format, width))
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// productImageRenditions tamaños generados de una imagen subida, desde productImageRenditionsCache o la base
+// (vacío si la imagen no existe o falla la consulta)
+func productImageRenditions(db *gorm.DB, imageID string) map[string]ImageDimensions {
+	if dimensions, ok := productImageRenditionsCache.Get(imageID).(map[string]ImageDimensions); ok {
+		return dimensions
+	}
+
+	var productImage ProductImage
+	err := db.Select("id", "width", "height", "renditions").Where("id = ?", imageID).Limit(1).Find(&productImage).Error
+	if err != nil {
+		log.Println("Error loading image ", imageID, ": ", err)
+		return nil
+	}
+	dimensions := productImage.RenditionDimensions()
+	productImageRenditionsCache.Set(imageID, dimensions, productImageRenditionsCacheDuration)
+	return dimensions
+}
@@ -0,0 +1,233 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"image"
+	"image/png"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	H "mercadillo-global/helpers"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestRenderProductImageRenditions(t *testing.T) {
+	var data bytes.Buffer
+	if err := png.Encode(&data, image.NewRGBA(image.Rect(0, 0, 300, 200))); err != nil {
+		t.Fatal(err)
+	}
+
+	productImage := &ProductImage{ID: "11111111-2222-3333-4444-555555555555", ProductID: "p1"}
+	renditions, err := renderProductImage(productImage, data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renditions) != len(ProductImageSizes)*2 {
+		t.Errorf("renditions = %d, want %d", len(renditions), len(ProductImageSizes)*2)
+	}
+
+	// Las imágenes chicas no se agrandan: zoom y card quedan del tamaño original
+	want := map[string]ImageDimensions{"zoom": {300, 200}, "card": {300, 200}, "thumb": {160, 106}}
+	dimensions := productImage.RenditionDimensions()
+	for name, size := range want {
+		if dimensions[name] != size {
+			t.Errorf("%s = %+v, want %+v", name, dimensions[name], size)
+		}
+	}
+
+	// Sin Renditions (imágenes anteriores) se calculan del original
+	legacy := ProductImage{Width: productImage.Width, Height: productImage.Height}
+	for name, size := range want {
+		if got := legacy.RenditionDimensions()[name]; got != size {
+			t.Errorf("legacy %s = %+v, want %+v", name, got, size)
+		}
+	}
+
+	productImageRenditionsCache.Set(productImage.ID, dimensions, productImageRenditionsCacheDuration)
+	t.Cleanup(func() { productImageRenditionsCache.Delete(productImage.ID) })
+	url := "/uploads/products/p1/" + productImage.ID + "-zoom.jpg"
+	wantSrcset := "/uploads/products/p1/" + productImage.ID + "-thumb.webp 160w, " +
+		"/uploads/products/p1/" + productImage.ID + "-card.webp 300w"
+	if got := ImageWebPSrcset(nil, url); got != wantSrcset {
+		t.Errorf("srcset = %q, want %q", got, wantSrcset)
+	}
+	if got := ImageSrcset(nil, "https://example.com/photo.jpg"); got != "" {
+		t.Errorf("external srcset = %q, want empty", got)
+	}
+}
+
+func TestRenderProductImageRejectsLargeImages(t *testing.T) {
+	maxPixels := ProductImageMaxPixels
+	ProductImageMaxPixels = 100 * 100
+	t.Cleanup(func() { ProductImageMaxPixels = maxPixels })
+
+	var data bytes.Buffer
+	if err := png.Encode(&data, image.NewRGBA(image.Rect(0, 0, 101, 100))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := renderProductImage(&ProductImage{}, data.Bytes()); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("err = %v, want ErrInvalidImage for an image over ProductImageMaxPixels", err)
+	}
+}
+
+func TestAddProductImageSendsPublishedProductsToReview(t *testing.T) {
+	SetImageStorage(&memoryImageStorage{files: make(map[string][]byte)})
+	t.Cleanup(func() { SetImageStorage(nil) })
+
+	e := echo.New()
+	H.Listener.Load(&e.Logger)
+	events := make(chan H.EventArgs, 1)
+	H.Listener.AddListener(ProductWorkflow.EventName(), func(event_uuid string, args H.EventArgs) {
+		events <- args
+	})
+
+	var data bytes.Buffer
+	if err := png.Encode(&data, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		status     string
+		wantReview bool
+	}{
+		{"active", true},
+		{"pause", true},
+		{"draft", false},
+		{"wait_for_human_review", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			db, pool := newRecordingDB(t, map[string]string{"ID": "p1", "ProductID": "p1", "Status": tt.status, "Images": "[]"})
+			if _, err := AddProductImage(db, "seller-1", "p1", bytes.NewReader(data.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+
+			statements := strings.Join(pool.statements(), "\n")
+			if got := strings.Contains(statements, "INSERT INTO `product_status_history`"); got != tt.wantReview {
+				t.Errorf("status history recorded = %v, want %v:\n%s", got, tt.wantReview, statements)
+			}
+			if !tt.wantReview {
+				return
+			}
+			select {
+			case args := <-events:
+				if args["from"] != tt.status || args["to"] != "wait_for_ia" || args["actor_type"] != ActorSeller ||
+					args["reason"] != "content changed" {
+					t.Errorf("event = %v", args)
+				}
+			case <-time.After(time.Second):
+				t.Error("status change event was not fired")
+			}
+		})
+	}
+}
+
+// memoryImageStorage guarda los archivos en memoria
+type memoryImageStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (s *memoryImageStorage) Put(key string, data []byte, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[key] = data
+	return nil
+}
+
+func (s *memoryImageStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, key)
+	return nil
+}
+
+func (s *memoryImageStorage) URL(key string) string {
+	return "/uploads/" + key
+}
+
+// recordingConnPool conexión falsa que anota las sentencias de escritura; las lecturas no llegan a la conexión
+type recordingConnPool struct {
+	mu    sync.Mutex
+	execs []string
+}
+
+func (p *recordingConnPool) statements() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.execs...)
+}
+
+func (p *recordingConnPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (p *recordingConnPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.execs = append(p.execs, query)
+	return recordingResult{}, nil
+}
+
+func (p *recordingConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, errors.New("query not supported")
+}
+
+func (p *recordingConnPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
+func (p *recordingConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	return &recordingTx{p}, nil
+}
+
+type recordingResult struct{}
+
+func (recordingResult) LastInsertId() (int64, error) { return 0, nil }
+func (recordingResult) RowsAffected() (int64, error) { return 1, nil }
+
+type recordingTx struct {
+	*recordingConnPool
+}
+
+func (*recordingTx) Commit() error   { return nil }
+func (*recordingTx) Rollback() error { return nil }
+
+// newRecordingDB base de datos falsa: las consultas devuelven una fila con los campos de row (y 1 en los Count)
+func newRecordingDB(t *testing.T, row map[string]string) (*gorm.DB, *recordingConnPool) {
+	t.Helper()
+	pool := &recordingConnPool{}
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: pool, SkipInitializeWithVersion: true}),
+		&gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Callback().Query().Replace("gorm:query", func(tx *gorm.DB) {
+		dest := reflect.Indirect(reflect.ValueOf(tx.Statement.Dest))
+		switch dest.Kind() {
+		case reflect.Int64:
+			dest.SetInt(1)
+		case reflect.Struct:
+			for name, value := range row {
+				if field := dest.FieldByName(name); field.IsValid() && field.Kind() == reflect.String {
+					field.SetString(value)
+				}
+			}
+		}
+		tx.RowsAffected = 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, pool
+}
@@ -0,0 +1,882 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	H "mercadillo-global/helpers"
+)
+
+// Configuración de la importación masiva de productos
+var (
+	ProductImportsDir       = "imports" // Archivos subidos pendientes de procesar
+	ProductImportPollEvery  = 5 * time.Second
+	ProductImportStaleAfter = 2 * time.Minute // Sin heartbeat en este tiempo, otro worker retoma la importación
+	ProductImportMaxRows    = 10000
+)
+
+// Errores de la importación masiva
+var (
+	ErrProductImportNotFound = errors.New("import not found")
+	ErrInvalidImportFile     = errors.New("invalid import file")
+)
+
+// Estados de ProductImport
+const (
+	ImportPending = "pending"
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// ProductImport importación de un CSV/XLSX de productos del vendedor. ProcessedRows es el punto desde el que se
+// retoma si el worker se detiene a mitad del archivo
+type ProductImport struct {
+	ID            string     `json:"id" gorm:"type:char(36);primaryKey"`
+	UserID        string     `json:"user_id" gorm:"type:char(36);not null;index"`
+	FileName      string     `json:"file_name" gorm:"type:varchar(255);not null"`
+	Format        string     `json:"format" gorm:"type:enum('csv','xlsx');not null"`
+	Language      string     `json:"language" gorm:"type:varchar(2);default:'es'"`
+	Publish       bool       `json:"publish" gorm:"default:false;comment:'New products go to wait_for_ia instead of draft'"`
+	Status        string     `json:"status" gorm:"type:enum('pending','running','done','failed');default:'pending';index"`
+	TotalRows     int        `json:"total_rows" gorm:"default:0"`
+	ProcessedRows int        `json:"processed_rows" gorm:"default:0"`
+	CreatedCount  int        `json:"created_count" gorm:"default:0"`
+	UpdatedCount  int        `json:"updated_count" gorm:"default:0"`
+	ErrorCount    int        `json:"error_count" gorm:"default:0"`
+	LastError     string     `json:"last_error,omitempty" gorm:"type:text"`
+	HeartbeatAt   *time.Time `json:"heartbeat_at" gorm:"type:timestamp null"`
+	StartedAt     *time.Time `json:"started_at" gorm:"type:timestamp null"`
+	FinishedAt    *time.Time `json:"finished_at" gorm:"type:timestamp null"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (ProductImport) TableName() string {
+	return "product_imports"
+}
+
+func (i *ProductImport) BeforeCreate(tx *gorm.DB) error {
+	if H.IsEmpty(i.ID) {
+		i.ID = H.NewUUID()
+	}
+	return nil
+}
+
+// Progress porcentaje procesado del archivo
+func (i ProductImport) Progress() float64 {
+	if i.TotalRows == 0 {
+		if i.Status == ImportDone {
+			return 100
+		}
+		return 0
+	}
+	return float64(i.ProcessedRows) * 100 / float64(i.TotalRows)
+}
+
+// ProductImportError error de una fila del archivo (Row es el número de fila en la hoja, la cabecera es la 1)
+type ProductImportError struct {
+	ID        string    `json:"id" gorm:"type:char(36);primaryKey"`
+	ImportID  string    `json:"import_id" gorm:"type:char(36);not null;index"`
+	Row       int       `json:"row" gorm:"not null"`
+	Field     string    `json:"field" gorm:"type:varchar(100)"`
+	Message   string    `json:"message" gorm:"type:text;not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (ProductImportError) TableName() string {
+	return "product_import_errors"
+}
+
+func (e *ProductImportError) BeforeCreate(tx *gorm.DB) error {
+	if H.IsEmpty(e.ID) {
+		e.ID = H.NewUUID()
+	}
+	return nil
+}
+
+// ImportFieldError error de validación de un campo de una fila
+type ImportFieldError = ProductFieldError
+
+// ImportValidateFunc valida un DTO (ProductRequest o ProductPatchRequest) con los mensajes en lang; en main se
+// arma sobre H.CustomValidator
+type ImportValidateFunc func(data interface{}, lang string) []ImportFieldError
+
+// importHeaderAliases nombre de columna (con H.Slugify) -> campo. Las columnas que no están aquí son atributos
+var importHeaderAliases = map[string]string{
+	"sku": "sku", "referencia": "sku",
+	"title": "title", "titulo": "title", "name": "title", "nombre": "title",
+	"description": "description", "descripcion": "description",
+	"price": "price", "precio": "price",
+	"original-price": "original_price", "precio-original": "original_price",
+	"currency": "currency", "currency-id": "currency", "moneda": "currency",
+	"category": "category_id", "category-id": "category_id", "categories": "category_id",
+	"categoria": "category_id", "categorias": "category_id",
+	"images": "images", "imagenes": "images",
+	"specifications": "specifications", "especificaciones": "specifications",
+	"free-shipping": "free_shipping", "envio-gratis": "free_shipping",
+	"is-service": "is_service", "servicio": "is_service",
+	"stock": "stock", "existencias": "stock",
+}
+
+// importBracketHeader columnas "stock[almacén]" (por ID o nombre del almacén) y "attr[atributo]"
+var importBracketHeader = regexp.MustCompile(`(?i)^(stock|existencias|attr|atributo)\s*\[(.+)\]$`)
+
+// importColumn columna del archivo: Field es un campo de importHeaderAliases, "warehouse" (Key = ID del almacén)
+// o "attribute" (Key = slug del atributo)
+type importColumn struct {
+	Header string
+	Field  string
+	Key    string
+}
+
+// importRowReader filas de un CSV o de la primera hoja de un XLSX; Next devuelve io.EOF al terminar
+type importRowReader interface {
+	Next() ([]string, error)
+	Close() error
+}
+
+type csvRowReader struct {
+	file   *os.File
+	reader *csv.Reader
+}
+
+func (r *csvRowReader) Next() ([]string, error) {
+	return r.reader.Read()
+}
+
+func (r *csvRowReader) Close() error {
+	return r.file.Close()
+}
+
+type xlsxRowReader struct {
+	file *excelize.File
+	rows *excelize.Rows
+}
+
+func (r *xlsxRowReader) Next() ([]string, error) {
+	if !r.rows.Next() {
+		if err := r.rows.Error(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	return r.rows.Columns()
+}
+
+func (r *xlsxRowReader) Close() error {
+	r.rows.Close()
+	return r.file.Close()
+}
+
+// openImportRows abre el archivo según el formato. El CSV puede venir separado por coma o por punto y coma (Excel
+// en español) y con BOM
+func openImportRows(path, format string) (importRowReader, error) {
+	if format == "xlsx" {
+		file, err := excelize.OpenFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sheets := file.GetSheetList()
+		if len(sheets) == 0 {
+			file.Close()
+			return nil, errors.New("the workbook has no sheets")
+		}
+		rows, err := file.Rows(sheets[0])
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &xlsxRowReader{file: file, rows: rows}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(file)
+	if bom, _ := buffered.Peek(3); bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		buffered.Discard(3)
+	}
+	firstLine, _ := buffered.Peek(4096)
+	if end := bytes.IndexByte(firstLine, '\n'); end >= 0 {
+		firstLine = firstLine[:end]
+	}
+
+	reader := csv.NewReader(buffered)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+	return &csvRowReader{file: file, reader: reader}, nil
+}
+
+// parseImportHeader traduce la cabecera a columnas. Las de stock por almacén deben nombrar un almacén activo
+// del vendedor; si no, no se puede importar el archivo
+func parseImportHeader(db *gorm.DB, userID string, header []string) ([]importColumn, error) {
+	var warehouses []Warehouse
+	if err := db.Where("user_id = ? AND is_active = ?", userID, true).Find(&warehouses).Error; err != nil {
+		return nil, err
+	}
+
+	columns := make([]importColumn, len(header))
+	seen := make(map[string]bool)
+	for i, name := range header {
+		name = H.Trim(name)
+		column := importColumn{Header: name}
+		if match := importBracketHeader.FindStringSubmatch(name); match != nil {
+			key := H.Trim(match[2])
+			if prefix := strings.ToLower(match[1]); prefix == "stock" || prefix == "existencias" {
+				column.Field = "warehouse"
+				for _, warehouse := range warehouses {
+					if warehouse.ID == key || strings.EqualFold(H.Trim(warehouse.Name), key) {
+						column.Key = warehouse.ID
+						break
+					}
+				}
+				if column.Key == "" {
+					return nil, fmt.Errorf("%w: column %q: %q is not an active warehouse of the seller", ErrInvalidImportFile, name, key)
+				}
+			} else {
+				column.Field = "attribute"
+				column.Key = H.Slugify(key)
+			}
+		} else if field, ok := importHeaderAliases[H.Slugify(name)]; ok {
+			column.Field = field
+		} else if slug := H.Slugify(name); slug != "" {
+			column.Field = "attribute"
+			column.Key = slug
+		}
+		if column.Field == "" {
+			continue
+		}
+
+		id := column.Field + ":" + column.Key
+		if seen[id] {
+			return nil, fmt.Errorf("%w: column %q is repeated", ErrInvalidImportFile, name)
+		}
+		seen[id] = true
+		columns[i] = column
+	}
+
+	if !seen["sku:"] && !seen["title:"] {
+		return nil, fmt.Errorf("%w: the header needs a sku or a title column", ErrInvalidImportFile)
+	}
+	return columns, nil
+}
+
+// CreateProductImport guarda el archivo subido y deja la importación pendiente para el worker. Revisa antes la
+// cabecera y la cantidad de filas para rechazar en el momento los archivos que no se pueden importar
+func CreateProductImport(db *gorm.DB, userID, fileName string, file io.Reader, publish bool, lang string) (*ProductImport, error) {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")
+	if format != "csv" && format != "xlsx" {
+		return nil, fmt.Errorf("%w: only .csv and .xlsx files are supported", ErrInvalidImportFile)
+	}
+
+	productImport := &ProductImport{
+		ID:       H.NewUUID(),
+		UserID:   userID,
+		FileName: truncateRunes(filepath.Base(fileName), 255),
+		Format:   format,
+		Language: lang,
+		Publish:  publish,
+		Status:   ImportPending,
+	}
+	if err := os.MkdirAll(ProductImportsDir, 0o755); err != nil {
+		return nil, err
+	}
+	path := productImportPath(productImport)
+	if err := writeImportFile(path, file); err != nil {
+		return nil, err
+	}
+
+	total, err := countImportRows(db, productImport)
+	if err == nil {
+		productImport.TotalRows = total
+		err = db.Create(productImport).Error
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return productImport, nil
+}
+
+// writeImportFile copia el archivo subido a path
+func writeImportFile(path string, src io.Reader) error {
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(path)
+		return err
+	}
+	return dst.Close()
+}
+
+// countImportRows valida la cabecera y cuenta las filas de datos
+func countImportRows(db *gorm.DB, productImport *ProductImport) (int, error) {
+	rows, err := openImportRows(productImportPath(productImport), productImport.Format)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+	}
+	defer rows.Close()
+
+	header, err := rows.Next()
+	if err != nil {
+		return 0, fmt.Errorf("%w: the file has no header", ErrInvalidImportFile)
+	}
+	if _, err := parseImportHeader(db, productImport.UserID, header); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for {
+		_, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%w: row %d: %v", ErrInvalidImportFile, total+2, err)
+		}
+		total++
+		if total > ProductImportMaxRows {
+			return 0, fmt.Errorf("%w: the file has more than %d rows", ErrInvalidImportFile, ProductImportMaxRows)
+		}
+	}
+	return total, nil
+}
+
+// productImportPath archivo de la importación en ProductImportsDir
+func productImportPath(productImport *ProductImport) string {
+	return filepath.Join(ProductImportsDir, productImport.ID+"."+productImport.Format)
+}
+
+// GetProductImports importaciones del vendedor, las más recientes primero
+func GetProductImports(db *gorm.DB, userID string, limit int) ([]ProductImport, error) {
+	imports := make([]ProductImport, 0)
+	err := db.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&imports).Error
+	return imports, err
+}
+
+// GetProductImport importación del vendedor
+func GetProductImport(db *gorm.DB, userID, importID string) (*ProductImport, error) {
+	var productImport ProductImport
+	err := db.First(&productImport, "id = ? AND user_id = ?", importID, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrProductImportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &productImport, nil
+}
+
+// GetProductImportErrors reporte de errores por fila de la importación
+func GetProductImportErrors(db *gorm.DB, importID string) ([]ProductImportError, error) {
+	importErrors := make([]ProductImportError, 0)
+	err := db.Where("import_id = ?", importID).Order("`row`, created_at").Find(&importErrors).Error
+	return importErrors, err
+}
+
+// RunProductImportWorker procesa las importaciones pendientes una a una; cada ProductImportPollEvery busca más
+func RunProductImportWorker(getDB func() *gorm.DB, validate ImportValidateFunc) {
+	for {
+		productImport, err := ClaimProductImport(getDB())
+		if err != nil {
+			log.Println("Error claiming product import: ", err)
+		}
+		if productImport == nil {
+			time.Sleep(ProductImportPollEvery)
+			continue
+		}
+		if err := RunProductImport(getDB(), productImport, validate); err != nil {
+			log.Println("Error importing products from ", productImport.ID, ": ", err)
+		}
+	}
+}
+
+// ClaimProductImport toma la importación pendiente más antigua, o una en curso que dejó de dar señales (el worker
+// que la tenía se detuvo), y la marca en curso. Devuelve nil si no hay ninguna
+func ClaimProductImport(db *gorm.DB) (*ProductImport, error) {
+	var productImport ProductImport
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? OR (status = ? AND (heartbeat_at IS NULL OR heartbeat_at < ?))",
+				ImportPending, ImportRunning, now.Add(-ProductImportStaleAfter)).
+			Order("created_at").
+			First(&productImport).Error
+		if err != nil {
+			return err
+		}
+
+		productImport.Status = ImportRunning
+		productImport.HeartbeatAt = &now
+		if productImport.StartedAt == nil {
+			productImport.StartedAt = &now
+		}
+		return tx.Model(&productImport).Select("status", "heartbeat_at", "started_at").Updates(&productImport).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &productImport, nil
+}
+
+// productImportJob estado de una importación en curso
+type productImportJob struct {
+	db       *gorm.DB
+	record   *ProductImport
+	user     User
+	columns  []importColumn
+	validate ImportValidateFunc
+}
+
+// RunProductImport procesa el archivo desde ProcessedRows. Cada fila se guarda en su propia transacción junto con
+// sus errores y el avance, así que si el proceso se corta se retoma en la fila siguiente. Un error de base de
+// datos detiene la importación (queda en curso y se retoma cuando vence el heartbeat); un archivo ilegible la
+// marca como fallida
+func RunProductImport(db *gorm.DB, productImport *ProductImport, validate ImportValidateFunc) error {
+	job := &productImportJob{db: db, record: productImport, validate: validate}
+	if err := db.First(&job.user, "id = ?", productImport.UserID).Error; err != nil {
+		return job.fail(err)
+	}
+
+	rows, err := openImportRows(productImportPath(productImport), productImport.Format)
+	if err != nil {
+		return job.fail(err)
+	}
+	defer rows.Close()
+
+	header, err := rows.Next()
+	if err != nil {
+		return job.fail(fmt.Errorf("the file has no header: %w", err))
+	}
+	if job.columns, err = parseImportHeader(db, productImport.UserID, header); err != nil {
+		return job.fail(err)
+	}
+
+	for rowIndex := 0; ; rowIndex++ {
+		cells, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return job.fail(fmt.Errorf("row %d: %w", rowIndex+2, err))
+		}
+		if rowIndex < productImport.ProcessedRows {
+			continue
+		}
+		if err := job.importRow(rowIndex+2, cells); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	err = db.Model(productImport).Updates(map[string]interface{}{"status": ImportDone, "finished_at": now}).Error
+	if err != nil {
+		return err
+	}
+	os.Remove(productImportPath(productImport))
+	return nil
+}
+
+// fail marca la importación como fallida y borra el archivo
+func (job *productImportJob) fail(cause error) error {
+	now := time.Now()
+	err := job.db.Model(job.record).Updates(map[string]interface{}{
+		"status": ImportFailed, "last_error": cause.Error(), "finished_at": now,
+	}).Error
+	if err != nil {
+		return err
+	}
+	os.Remove(productImportPath(job.record))
+	return cause
+}
+
+// importRow valida la fila y crea o actualiza el producto (por SKU del vendedor). Los errores de la fila quedan
+// en el reporte y no detienen la importación
+func (job *productImportJob) importRow(rowNumber int, cells []string) error {
+	var (
+		product       *Product
+		changes       []*ProductStatusHistory
+		fieldErrors   []ImportFieldError
+		created       bool
+		imagesChanged bool
+	)
+	err := job.db.Transaction(func(tx *gorm.DB) error {
+		row, rowErrors := job.parseRow(cells)
+		fieldErrors = rowErrors
+		imagesChanged = row.Patch.Images != nil
+		if len(fieldErrors) == 0 && !row.isEmpty() {
+			// Savepoint: si el producto no se puede guardar se descarta solo lo de esta fila
+			err := tx.Transaction(func(savepoint *gorm.DB) error {
+				var err error
+				product, created, changes, fieldErrors, err = job.upsertProduct(savepoint, row)
+				if err == nil && len(fieldErrors) > 0 {
+					return errInvalidImportRow
+				}
+				return err
+			})
+			var rulesErr *ProductRulesError
+			if errors.Is(err, errInvalidImportRow) {
+				err = nil
+			} else if errors.As(err, &rulesErr) {
+				fieldErrors = rulesErr.Errors
+				err = nil
+			} else if errors.Is(err, ErrInvalidProductInput) || errors.Is(err, ErrIllegalStatusTransition) {
+				fieldErrors = []ImportFieldError{{Message: err.Error()}}
+				err = nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, fieldError := range fieldErrors {
+			rowError := ProductImportError{ImportID: job.record.ID, Row: rowNumber, Field: fieldError.Field, Message: fieldError.Message}
+			if err := tx.Create(&rowError).Error; err != nil {
+				return err
+			}
+		}
+
+		progress := map[string]interface{}{
+			"processed_rows": gorm.Expr("processed_rows + 1"),
+			"heartbeat_at":   time.Now(),
+		}
+		switch {
+		case len(fieldErrors) > 0:
+			progress["error_count"] = gorm.Expr("error_count + 1")
+			product, changes = nil, nil
+		case product != nil && created:
+			progress["created_count"] = gorm.Expr("created_count + 1")
+		case product != nil:
+			progress["updated_count"] = gorm.Expr("updated_count + 1")
+		}
+		return tx.Model(&ProductImport{}).Where("id = ?", job.record.ID).Updates(progress).Error
+	})
+	if err != nil {
+		return err
+	}
+	job.record.ProcessedRows++
+
+	for _, change := range changes {
+		change.Fire()
+	}
+	if product != nil && !created && imagesChanged {
+		if err := PruneProductImages(job.db, product.ID); err != nil {
+			log.Println("Error pruning product images: ", err)
+		}
+	}
+	if product != nil {
+		if saved, err := GetSellerProduct(job.db, product.UserID, product.ID); err == nil {
+			if err := GetSearchEngine(job.db).Index(*saved); err != nil {
+				log.Println("Error indexing imported product: ", err)
+			}
+		}
+	}
+	return nil
+}
+
+// errInvalidImportRow revierte el savepoint de una fila con errores de validación
+var errInvalidImportRow = errors.New("invalid import row")
+
+// importRowData valores de una fila; las celdas vacías no cambian el producto
+type importRowData struct {
+	Patch      ProductPatchRequest
+	Stock      map[string]int    // ID del almacén -> cantidad
+	Attributes map[string]string // slug -> valor
+	Order      []string          // slugs de los atributos en el orden del archivo
+}
+
+func (row importRowData) isEmpty() bool {
+	return row.Patch == (ProductPatchRequest{}) && len(row.Stock) == 0 && len(row.Attributes) == 0
+}
+
+// parseRow lee las celdas de la fila según las columnas de la cabecera
+func (job *productImportJob) parseRow(cells []string) (importRowData, []ImportFieldError) {
+	row := importRowData{Stock: make(map[string]int), Attributes: make(map[string]string)}
+	fieldErrors := make([]ImportFieldError, 0)
+	invalid := func(column importColumn, message string) {
+		fieldErrors = append(fieldErrors, ImportFieldError{Field: column.Header, Message: message})
+	}
+
+	for i, column := range job.columns {
+		if column.Field == "" || i >= len(cells) {
+			continue
+		}
+		value := H.Trim(cells[i])
+		if value == "" {
+			continue
+		}
+
+		switch column.Field {
+		case "sku":
+			row.Patch.SKU = &value
+		case "title":
+			row.Patch.Title = &value
+		case "description":
+			row.Patch.Description = &value
+		case "currency":
+			row.Patch.CurrencyID = &value
+		case "price", "original_price", "stock":
+			number, err := strconv.Atoi(strings.ReplaceAll(value, " ", ""))
+			if err != nil {
+				invalid(column, "must be an integer")
+				continue
+			}
+			switch column.Field {
+			case "price":
+				row.Patch.Price = &number
+			case "original_price":
+				row.Patch.OriginalPrice = &number
+			default:
+				row.Patch.Stock = &number
+			}
+		case "free_shipping", "is_service":
+			flag, ok := parseImportBool(value)
+			if !ok {
+				invalid(column, "must be yes/no, true/false or 1/0")
+				continue
+			}
+			if column.Field == "free_shipping" {
+				row.Patch.FreeShipping = &flag
+			} else {
+				row.Patch.IsService = &flag
+			}
+		case "category_id":
+			categories := make([]ProductCategoryRequest, 0)
+			for _, id := range splitImportList(value, "|") {
+				categories = append(categories, ProductCategoryRequest{CategoryID: id, IsPrimary: len(categories) == 0})
+			}
+			row.Patch.Categories = &categories
+		case "images":
+			images := splitImportList(value, "|")
+			row.Patch.Images = &images
+		case "specifications":
+			specifications := make([]Specification, 0)
+			for _, item := range splitImportList(value, ";") {
+				name, specValue, ok := strings.Cut(item, ":")
+				if !ok {
+					invalid(column, fmt.Sprintf("%q must be \"name: value\"", item))
+					continue
+				}
+				specifications = append(specifications, Specification{Name: H.Trim(name), Value: H.Trim(specValue)})
+			}
+			row.Patch.Specifications = &specifications
+		case "warehouse":
+			quantity, err := strconv.Atoi(strings.ReplaceAll(value, " ", ""))
+			if err != nil {
+				invalid(column, "must be an integer")
+				continue
+			}
+			row.Stock[column.Key] = quantity
+		case "attribute":
+			row.Attributes[column.Key] = value
+			row.Order = append(row.Order, column.Key)
+		}
+	}
+	return row, fieldErrors
+}
+
+// upsertProduct actualiza el producto del vendedor con el SKU de la fila o crea uno nuevo (también si la fila no
+// tiene SKU). Devuelve los errores de validación de la fila sin guardar nada si los hay
+func (job *productImportJob) upsertProduct(tx *gorm.DB, row importRowData) (*Product, bool, []*ProductStatusHistory, []ImportFieldError, error) {
+	var existing *Product
+	if row.Patch.SKU != nil {
+		var product Product
+		err := sellerProductQuery(tx).First(&product, "user_id = ? AND sku = ?", job.user.ID, *row.Patch.SKU).Error
+		if err == nil {
+			existing = &product
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil, nil, err
+		}
+	}
+
+	patch := row.Patch
+	if len(row.Stock) > 0 {
+		warehouses := importWarehouses(existing, row.Stock)
+		patch.Warehouses = &warehouses
+	}
+	if len(row.Attributes) > 0 {
+		attributes := importAttributes(existing, row)
+		patch.Attributes = &attributes
+	}
+
+	if existing == nil {
+		product := &Product{UserID: job.user.ID, ShortKey: newShortKey(), CurrencyID: "USD", Status: "draft"}
+		req := importProductRequest(patch)
+		if job.record.Publish {
+			req.Status = "wait_for_ia"
+		}
+		if patch.IsService == nil {
+			req.IsService = importCategoriesAreService(req.Categories)
+		}
+		if fieldErrors := job.validate(&req, job.record.Language); len(fieldErrors) > 0 {
+			return nil, false, nil, fieldErrors, nil
+		}
+		changes, err := saveProductTx(tx, product, req.Patch(), true)
+		return product, true, changes, nil, err
+	}
+
+	if job.record.Publish && existing.Status == "draft" {
+		status := "wait_for_ia"
+		patch.Status = &status
+	}
+	if fieldErrors := job.validate(&patch, job.record.Language); len(fieldErrors) > 0 {
+		return nil, false, nil, fieldErrors, nil
+	}
+	changes, err := saveProductTx(tx, existing, patch, false)
+	return existing, false, changes, nil, err
+}
+
+// importCategoriesAreService si la fila no dice si es un servicio, lo es cuando su categoría primaria lo es
+func importCategoriesAreService(categories []ProductCategoryRequest) bool {
+	if len(categories) == 0 {
+		return false
+	}
+	return categoryFlags(categories[0].CategoryID).IsService
+}
+
+// importProductRequest producto nuevo con los campos de la fila
+func importProductRequest(patch ProductPatchRequest) ProductRequest {
+	req := ProductRequest{SKU: patch.SKU}
+	if patch.Title != nil {
+		req.Title = *patch.Title
+	}
+	if patch.Description != nil {
+		req.Description = *patch.Description
+	}
+	if patch.Price != nil {
+		req.Price = *patch.Price
+	}
+	if patch.OriginalPrice != nil {
+		req.OriginalPrice = *patch.OriginalPrice
+	}
+	if patch.CurrencyID != nil {
+		req.CurrencyID = *patch.CurrencyID
+	}
+	if patch.Images != nil {
+		req.Images = *patch.Images
+	}
+	if patch.Stock != nil {
+		req.Stock = *patch.Stock
+	}
+	if patch.IsService != nil {
+		req.IsService = *patch.IsService
+	}
+	if patch.FreeShipping != nil {
+		req.FreeShipping = *patch.FreeShipping
+	}
+	if patch.Specifications != nil {
+		req.Specifications = *patch.Specifications
+	}
+	if patch.Categories != nil {
+		req.Categories = *patch.Categories
+	}
+	if patch.Attributes != nil {
+		req.Attributes = *patch.Attributes
+	}
+	if patch.Warehouses != nil {
+		req.Warehouses = *patch.Warehouses
+	}
+	return req
+}
+
+// importWarehouses almacenes del producto con las cantidades de la fila; los demás datos de los almacenes que ya
+// tenía (peso, dimensiones) se conservan
+func importWarehouses(existing *Product, stock map[string]int) []ProductWarehouseRequest {
+	warehouses := make([]ProductWarehouseRequest, 0, len(stock))
+	updated := make(map[string]bool, len(stock))
+	if existing != nil {
+		for _, pw := range existing.Warehouses {
+			request := ProductWarehouseRequest{WarehouseID: pw.WarehouseID, Quantity: pw.Quantity, Weight: pw.Weight}
+			json.Unmarshal([]byte(pw.Dimensions), &request.Dimensions)
+			json.Unmarshal([]byte(pw.Specifications), &request.Specifications)
+			if quantity, ok := stock[pw.WarehouseID]; ok {
+				request.Quantity = quantity
+				updated[pw.WarehouseID] = true
+			}
+			warehouses = append(warehouses, request)
+		}
+	}
+	for warehouseID, quantity := range stock {
+		if updated[warehouseID] {
+			continue
+		}
+		warehouses = append(warehouses, ProductWarehouseRequest{WarehouseID: warehouseID, Quantity: quantity})
+	}
+	return warehouses
+}
+
+// importAttributes atributos del producto con los valores de la fila, que reemplazan a los globales con el mismo slug
+func importAttributes(existing *Product, row importRowData) []ProductAttributeRequest {
+	attributes := make([]ProductAttributeRequest, 0, len(row.Attributes))
+	if existing != nil {
+		for _, attribute := range existing.Attributes {
+			if _, ok := row.Attributes[attribute.AttributeSlug]; ok && attribute.ProductWarehouseID == nil {
+				continue
+			}
+			request := ProductAttributeRequest{Slug: attribute.AttributeSlug, Value: json.RawMessage(attribute.Value)}
+			if attribute.ProductWarehouseID != nil {
+				for _, pw := range existing.Warehouses {
+					if pw.ID == *attribute.ProductWarehouseID {
+						warehouseID := pw.WarehouseID
+						request.WarehouseID = &warehouseID
+					}
+				}
+			}
+			attributes = append(attributes, request)
+		}
+	}
+	for _, slug := range row.Order {
+		value, _ := json.Marshal(row.Attributes[slug])
+		attributes = append(attributes, ProductAttributeRequest{Slug: slug, Value: value})
+	}
+	return attributes
+}
+
+// splitImportList separa una celda con varios valores, sin vacíos
+func splitImportList(value, separator string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, separator) {
+		if item = H.Trim(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseImportBool sí/no en español o inglés
+func parseImportBool(value string) (bool, bool) {
+	switch strings.ToLower(H.RemoveAccents(value)) {
+	case "1", "true", "yes", "y", "si", "s", "x", "verdadero":
+		return true, true
+	case "0", "false", "no", "n", "falso":
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,805 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	H "mercadillo-global/helpers"
+)
+
+// Errores de la API de productos del vendedor
+var (
+	ErrProductNotFound     = errors.New("product not found")
+	ErrInvalidProductInput = errors.New("invalid product")
+	ErrProductInUse        = errors.New("product has questions or reviews")
+)
+
+// ProductRequest cuerpo de POST /api/v1/products y de PUT /api/v1/products/:productId (reemplaza todo el producto)
+type ProductRequest struct {
+	SKU            *string                   `json:"sku" validate:"omitempty,max=100"` // Referencia del vendedor
+	Title          string                    `json:"title" validate:"required,min=3,max=255"`
+	Description    string                    `json:"description" validate:"max=20000"`
+	Price          int                       `json:"price" validate:"required,gt=0"`
+	OriginalPrice  int                       `json:"original_price" validate:"gte=0"`
+	CurrencyID     string                    `json:"currency_id" validate:"omitempty,len=3,alpha"`
+	Images         []string                  `json:"images" validate:"max=20,dive,required,max=500"`
+	Stock          int                       `json:"stock" validate:"gte=0"`
+	IsService      bool                      `json:"is_service"`
+	FreeShipping   bool                      `json:"free_shipping"`
+	Specifications []Specification           `json:"specifications" validate:"max=100,dive"`
+	Status         string                    `json:"status" validate:"omitempty,oneof=draft wait_for_ia active pause"`
+	Categories     []ProductCategoryRequest  `json:"categories" validate:"required,min=1,max=10,dive"`
+	Attributes     []ProductAttributeRequest `json:"attributes" validate:"max=100,dive"`
+	Warehouses     []ProductWarehouseRequest `json:"warehouses" validate:"max=50,dive"`
+	Variants       []ProductVariantRequest   `json:"variants" validate:"max=100,dive"`
+}
+
+// ProductPatchRequest cuerpo de PATCH /api/v1/products/:productId: solo se cambia lo enviado. Las listas
+// (categorías, atributos, almacenes, variantes) se reemplazan completas cuando vienen
+type ProductPatchRequest struct {
+	SKU            *string                    `json:"sku" validate:"omitempty,max=100"`
+	Title          *string                    `json:"title" validate:"omitempty,min=3,max=255"`
+	Description    *string                    `json:"description" validate:"omitempty,max=20000"`
+	Price          *int                       `json:"price" validate:"omitempty,gt=0"`
+	OriginalPrice  *int                       `json:"original_price" validate:"omitempty,gte=0"`
+	CurrencyID     *string                    `json:"currency_id" validate:"omitempty,len=3,alpha"`
+	Images         *[]string                  `json:"images" validate:"omitempty,max=20,dive,required,max=500"`
+	Stock          *int                       `json:"stock" validate:"omitempty,gte=0"`
+	IsService      *bool                      `json:"is_service"`
+	FreeShipping   *bool                      `json:"free_shipping"`
+	Specifications *[]Specification           `json:"specifications" validate:"omitempty,max=100,dive"`
+	Status         *string                    `json:"status" validate:"omitempty,oneof=draft wait_for_ia active pause"`
+	Categories     *[]ProductCategoryRequest  `json:"categories" validate:"omitempty,min=1,max=10,dive"`
+	Attributes     *[]ProductAttributeRequest `json:"attributes" validate:"omitempty,max=100,dive"`
+	Warehouses     *[]ProductWarehouseRequest `json:"warehouses" validate:"omitempty,max=50,dive"`
+	Variants       *[]ProductVariantRequest   `json:"variants" validate:"omitempty,max=100,dive"`
+}
+
+// ProductCategoryRequest categoría del producto; si ninguna es primaria se toma la primera
+type ProductCategoryRequest struct {
+	CategoryID string `json:"category_id" validate:"required,max=36"`
+	IsPrimary  bool   `json:"is_primary"`
+}
+
+// ProductAttributeRequest atributo del producto; con WarehouseID aplica solo al stock de ese almacén
+type ProductAttributeRequest struct {
+	Slug        string          `json:"slug" validate:"required,max=100"`
+	Value       json.RawMessage `json:"value" validate:"required"`
+	WarehouseID *string         `json:"warehouse_id" validate:"omitempty,max=36"`
+}
+
+// ProductWarehouseRequest stock del producto en un almacén del vendedor
+type ProductWarehouseRequest struct {
+	WarehouseID    string          `json:"warehouse_id" validate:"required,max=36"`
+	Quantity       int             `json:"quantity" validate:"gte=0"`
+	Weight         float64         `json:"weight" validate:"gte=0"` // en KG
+	Dimensions     *DimensionsCm   `json:"dimensions"`
+	Specifications []Specification `json:"specifications" validate:"max=100,dive"`
+}
+
+// productEditableColumns columnas que el vendedor puede cambiar (sold, rating, etc. los mantiene el sistema y
+// status cambia con ProductWorkflow)
+var productEditableColumns = []string{"sku", "title", "slug", "price", "original_price", "currency_id", "images", "stock",
+	"is_service", "free_shipping", "description", "specifications", "search_content", "search_keywords"}
+
+// shortKeyAlphabet caracteres de ShortKey, sin los que se confunden (0/O, 1/I)
+const shortKeyAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Patch cambios de un PUT: todos los campos del producto
+func (r ProductRequest) Patch() ProductPatchRequest {
+	return ProductPatchRequest{
+		SKU:            r.SKU,
+		Title:          &r.Title,
+		Description:    &r.Description,
+		Price:          &r.Price,
+		OriginalPrice:  &r.OriginalPrice,
+		CurrencyID:     &r.CurrencyID,
+		Images:         &r.Images,
+		Stock:          &r.Stock,
+		IsService:      &r.IsService,
+		FreeShipping:   &r.FreeShipping,
+		Specifications: &r.Specifications,
+		Status:         &r.Status,
+		Categories:     &r.Categories,
+		Attributes:     &r.Attributes,
+		Warehouses:     &r.Warehouses,
+		Variants:       &r.Variants,
+	}
+}
+
+// CreateProduct crea el producto del vendedor con sus categorías, atributos y almacenes en una transacción.
+// Queda en borrador salvo que pida otro estado permitido desde draft (wait_for_ia para publicarlo)
+func CreateProduct(db *gorm.DB, userID string, req ProductRequest) (*Product, error) {
+	product := &Product{UserID: userID, ShortKey: newShortKey(), CurrencyID: "USD", Status: "draft"}
+	if err := saveProduct(db, product, req.Patch(), true); err != nil {
+		return nil, err
+	}
+	return GetSellerProduct(db, userID, product.ID)
+}
+
+// UpdateProduct aplica los cambios (PUT o PATCH) a un producto del vendedor
+func UpdateProduct(db *gorm.DB, userID, productID string, req ProductPatchRequest) (*Product, error) {
+	product, err := GetSellerProduct(db, userID, productID)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveProduct(db, product, req, false); err != nil {
+		return nil, err
+	}
+	return GetSellerProduct(db, userID, productID)
+}
+
+// DeleteProduct borra un producto del vendedor con sus relaciones. Si ya tiene preguntas o reseñas no se borra
+// (ErrProductInUse): hay que pausarlo
+func DeleteProduct(db *gorm.DB, userID, productID string) error {
+	product, err := GetSellerProduct(db, userID, productID)
+	if err != nil {
+		return err
+	}
+
+	var imageKeys []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var activity int64
+		err := tx.Raw("SELECT (SELECT COUNT(*) FROM questions WHERE product_id = ?) + (SELECT COUNT(*) FROM reviews WHERE product_id = ?)",
+			product.ID, product.ID).Scan(&activity).Error
+		if err != nil {
+			return err
+		}
+		if activity > 0 {
+			return ErrProductInUse
+		}
+
+		if err := deleteProductAttributes(tx, product.ID); err != nil {
+			return err
+		}
+		if err := deleteProductVariants(tx, product.ID, nil); err != nil {
+			return err
+		}
+		if err := tx.Where("product_id = ?", product.ID).Delete(&StockReservation{}).Error; err != nil {
+			return err
+		}
+		if err := deleteProductWarehouses(tx, product.ID, nil); err != nil {
+			return err
+		}
+		if err := tx.Where("product_id = ?", product.ID).Delete(&ProductCategory{}).Error; err != nil {
+			return err
+		}
+		if imageKeys, err = deleteProductImages(tx, product.ID); err != nil {
+			return err
+		}
+		return tx.Delete(&Product{}, "id = ?", product.ID).Error
+	})
+	if err != nil {
+		return err
+	}
+	deleteImageFiles(imageKeys)
+	return nil
+}
+
+// GetSellerProduct producto del vendedor con las relaciones que maneja la API y que necesita el índice de búsqueda
+func GetSellerProduct(db *gorm.DB, userID, productID string) (*Product, error) {
+	var product Product
+	err := sellerProductQuery(db).First(&product, "id = ? AND user_id = ?", productID, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// sellerProductQuery consulta de productos con las relaciones de GetSellerProduct
+func sellerProductQuery(db *gorm.DB) *gorm.DB {
+	return db.Preload("ProductCategories").
+		Preload("Attributes").
+		Preload("Warehouses.Warehouse").
+		Preload("Warehouses.ShippingCosts").
+		Preload("Variants.Stocks")
+}
+
+// saveProduct aplica los cambios al producto y guarda producto y relaciones en una sola transacción. El estado
+// cambia a través de ProductWorkflow (el vendedor no puede, por ejemplo, activar un borrador)
+func saveProduct(db *gorm.DB, product *Product, req ProductPatchRequest, isNew bool) error {
+	var changes []*ProductStatusHistory
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		changes, err = saveProductTx(tx, product, req, isNew)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		change.Fire()
+	}
+	if req.Images != nil && !isNew {
+		if err := PruneProductImages(db, product.ID); err != nil {
+			log.Println("Error pruning product images: ", err)
+		}
+	}
+	return nil
+}
+
+// saveProductTx hace el trabajo de saveProduct dentro de tx y devuelve los cambios de estado, cuyos eventos
+// hay que disparar después del commit
+func saveProductTx(tx *gorm.DB, product *Product, req ProductPatchRequest, isNew bool) ([]*ProductStatusHistory, error) {
+	before := productContentOf(product)
+	if err := applyProductFields(product, req); err != nil {
+		return nil, err
+	}
+	if req.Categories != nil {
+		categories, err := productCategoriesFromRequest(*req.Categories)
+		if err != nil {
+			return nil, err
+		}
+		product.ProductCategories = categories
+	}
+	after := productContentOf(product)
+
+	// Las reglas de las categorías se revisan al crear y cuando cambia algo de lo que dependen, para no bloquear
+	// la edición de productos anteriores a ellas
+	checkRules := isNew || req.Categories != nil || req.IsService != nil || req.Attributes != nil
+	// El contenido de búsqueda que escribió la IA se conserva mientras no cambie aquello de lo que sale
+	if isNew || before.searchSource() != after.searchSource() {
+		product.GenerateSearchContent()
+	}
+
+	seller := StatusActor{Type: ActorSeller, ID: product.UserID}
+	changes := make([]*ProductStatusHistory, 0)
+	err := func() error {
+		if checkRules {
+			if err := checkProductCategoryRules(tx, product, req.Attributes); err != nil {
+				return err
+			}
+		}
+		if product.SKU != nil {
+			var count int64
+			err := tx.Model(&Product{}).Where("user_id = ? AND sku = ? AND id <> ?", product.UserID, *product.SKU, product.ID).
+				Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return fmt.Errorf("%w: sku %s is already used by another product", ErrInvalidProductInput, *product.SKU)
+			}
+		}
+		if req.Warehouses != nil {
+			if err := checkSellerWarehouses(tx, product.UserID, *req.Warehouses); err != nil {
+				return err
+			}
+		}
+
+		if isNew {
+			if err := tx.Omit(clause.Associations).Create(product).Error; err != nil {
+				return err
+			}
+			change, err := ProductWorkflow.RecordCreated(tx, product.ID, product.ID, product.Status, seller)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, change)
+		} else {
+			if err := tx.Model(product).Select(productEditableColumns).Updates(product).Error; err != nil {
+				return err
+			}
+		}
+
+		if req.Status != nil && *req.Status != "" && *req.Status != product.Status {
+			change, err := ProductWorkflow.Apply(tx, product.ID, *req.Status, seller, "")
+			if err != nil {
+				return err
+			}
+			product.Status = change.ToStatus
+			changes = append(changes, change)
+		}
+
+		// Un producto publicado cuyo contenido cambia vuelve a revisión de la IA
+		if !isNew && before.reviewed() != after.reviewed() && (product.Status == "active" || product.Status == "pause") {
+			change, err := ProductWorkflow.Apply(tx, product.ID, "wait_for_ia", seller, "content changed")
+			if err != nil {
+				return err
+			}
+			product.Status = change.ToStatus
+			changes = append(changes, change)
+		}
+
+		if req.Categories != nil {
+			if err := tx.Where("product_id = ?", product.ID).Delete(&ProductCategory{}).Error; err != nil {
+				return err
+			}
+			for i := range product.ProductCategories {
+				product.ProductCategories[i].ID = ""
+				product.ProductCategories[i].ProductID = product.ID
+			}
+			if err := tx.Omit(clause.Associations).Create(&product.ProductCategories).Error; err != nil {
+				return err
+			}
+		}
+
+		// Los atributos pueden apuntar a almacenes del producto, así que se borran antes de tocar los almacenes
+		if req.Attributes != nil || req.Warehouses != nil {
+			if err := deleteProductAttributes(tx, product.ID); err != nil {
+				return err
+			}
+		}
+
+		warehouseIDs, err := syncProductWarehouses(tx, product, req.Warehouses)
+		if err != nil {
+			return err
+		}
+
+		attributes := req.Attributes
+		if attributes == nil && req.Warehouses != nil {
+			// Se conservan los atributos actuales que siguen teniendo su almacén
+			attributes = attributeRequestsFromProduct(product, warehouseIDs)
+		}
+		if attributes != nil {
+			if err := createProductAttributes(tx, product.ID, *attributes, warehouseIDs); err != nil {
+				return err
+			}
+		}
+
+		if req.Variants != nil {
+			if err := syncProductVariants(tx, product, *req.Variants, warehouseIDs); err != nil {
+				return err
+			}
+		} else if len(warehouseIDs) > 0 && !variantsHaveWarehouseStock(product.Variants) {
+			// Las variantes con el stock en la propia variante se quedarían sin stock al recalcularlo por almacén
+			return fmt.Errorf("%w: the product now has warehouses, send the variants with their stock per warehouse", ErrInvalidProductInput)
+		}
+		// Con variantes, su stock manda sobre el de los almacenes y el del producto
+		return refreshVariantStock(tx, product.ID, len(warehouseIDs) > 0)
+	}()
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// productContent campos de contenido del producto: los revisa la IA y de ellos sale el contenido de búsqueda
+type productContent struct {
+	Title          string
+	Description    string
+	Images         string
+	Specifications string
+	Categories     string
+}
+
+// productContentOf contenido actual del producto. Las columnas JSON se comparan ya decodificadas (MySQL las
+// devuelve con otro formato) y las categorías ordenadas
+func productContentOf(product *Product) productContent {
+	categoryIDs := make([]string, 0, len(product.ProductCategories))
+	for _, category := range product.ProductCategories {
+		categoryIDs = append(categoryIDs, category.CategoryID)
+	}
+	sort.Strings(categoryIDs)
+	return productContent{
+		Title:          product.Title,
+		Description:    product.Description,
+		Images:         canonicalJSON(product.Images),
+		Specifications: canonicalJSON(product.Specifications),
+		Categories:     strings.Join(categoryIDs, ","),
+	}
+}
+
+// searchSource campos de los que sale GenerateSearchContent
+func (c productContent) searchSource() productContent {
+	c.Images = ""
+	return c
+}
+
+// reviewed campos que revisa la IA antes de publicar
+func (c productContent) reviewed() productContent {
+	c.Categories = ""
+	return c
+}
+
+// applyProductFields copia al producto los campos enviados y recalcula el slug si cambió el título
+func applyProductFields(product *Product, req ProductPatchRequest) error {
+	if req.SKU != nil {
+		// Vacío quita la referencia: NULL no choca con el índice único de (user_id, sku)
+		product.SKU = nil
+		if sku := H.Trim(*req.SKU); sku != "" {
+			product.SKU = &sku
+		}
+	}
+	if req.Title != nil {
+		product.Title = H.Trim(*req.Title)
+		product.Slug = productSlug(product.Title, product.ShortKey)
+	}
+	if req.Description != nil {
+		product.Description = *req.Description
+	}
+	if req.Price != nil {
+		product.Price = *req.Price
+	}
+	if req.OriginalPrice != nil {
+		product.OriginalPrice = *req.OriginalPrice
+	}
+	if req.CurrencyID != nil && *req.CurrencyID != "" {
+		product.CurrencyID = strings.ToUpper(*req.CurrencyID)
+	}
+	if req.Images != nil {
+		images := *req.Images
+		if images == nil {
+			images = []string{}
+		}
+		product.Images = encodeJSON(images)
+	}
+	if req.Stock != nil {
+		product.Stock = *req.Stock
+	}
+	if req.IsService != nil {
+		product.IsService = *req.IsService
+	}
+	if req.FreeShipping != nil {
+		product.FreeShipping = *req.FreeShipping
+	}
+	if req.Specifications != nil {
+		product.Specifications = encodeJSON(nonNilSpecifications(*req.Specifications))
+	}
+	if req.Warehouses != nil && len(*req.Warehouses) > 0 {
+		// Con almacenes el stock es la suma de sus cantidades
+		product.Stock = 0
+		for _, warehouse := range *req.Warehouses {
+			product.Stock += warehouse.Quantity
+		}
+	}
+
+	if product.OriginalPrice > 0 && product.OriginalPrice < product.Price {
+		return fmt.Errorf("%w: original_price must be 0 or greater than or equal to price", ErrInvalidProductInput)
+	}
+	return nil
+}
+
+// productCategoriesFromRequest valida que las categorías existan y deja exactamente una primaria
+func productCategoriesFromRequest(requests []ProductCategoryRequest) ([]ProductCategory, error) {
+	categories := make([]ProductCategory, 0, len(requests))
+	seen := make(map[string]bool)
+	hasPrimary := false
+	for _, request := range requests {
+		if GetCategoryByID(request.CategoryID) == nil {
+			return nil, fmt.Errorf("%w: category %s does not exist", ErrInvalidProductInput, request.CategoryID)
+		}
+		if seen[request.CategoryID] {
+			continue
+		}
+		seen[request.CategoryID] = true
+
+		isPrimary := request.IsPrimary && !hasPrimary
+		hasPrimary = hasPrimary || isPrimary
+		categories = append(categories, ProductCategory{CategoryID: request.CategoryID, IsPrimary: isPrimary})
+	}
+	if !hasPrimary && len(categories) > 0 {
+		categories[0].IsPrimary = true
+	}
+	return categories, nil
+}
+
+// ProductFieldError error de validación de un campo del producto
+type ProductFieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ProductRulesError el producto no cumple las reglas de sus categorías; es un ErrInvalidProductInput
+type ProductRulesError struct {
+	Errors []ProductFieldError
+}
+
+func (e *ProductRulesError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fieldError := range e.Errors {
+		messages = append(messages, fieldError.Message)
+	}
+	return fmt.Sprintf("%s: %s", ErrInvalidProductInput, strings.Join(messages, "; "))
+}
+
+func (e *ProductRulesError) Unwrap() error {
+	return ErrInvalidProductInput
+}
+
+// checkProductCategoryRules reglas de las categorías del producto: deben ser hojas, los atributos deben ser de
+// alguna de ellas, el producto es un servicio si y solo si sus categorías lo son, y las categorías con KYC
+// exigen que el vendedor tenga el KYC aprobado. Sin attributes se revisan los que ya tiene el producto
+func checkProductCategoryRules(tx *gorm.DB, product *Product, attributes *[]ProductAttributeRequest) error {
+	fieldErrors := make([]ProductFieldError, 0)
+	allowed := make(map[string]bool)
+	kycStatus := ""
+	for _, productCategory := range product.ProductCategories {
+		categoryID := productCategory.CategoryID
+		category := GetCategoryByID(categoryID)
+		if category == nil {
+			fieldErrors = append(fieldErrors, ProductFieldError{Field: "category_id", Message: fmt.Sprintf("category %s does not exist", categoryID)})
+			continue
+		}
+		if len(category.Children) > 0 {
+			fieldErrors = append(fieldErrors, ProductFieldError{Field: "category_id",
+				Message: fmt.Sprintf("category %s has subcategories, use one of them", categoryID)})
+		}
+
+		flags := categoryFlags(categoryID)
+		if flags.IsService != product.IsService {
+			message := fmt.Sprintf("category %s is for products, not services", categoryID)
+			if flags.IsService {
+				message = fmt.Sprintf("category %s is for services", categoryID)
+			}
+			fieldErrors = append(fieldErrors, ProductFieldError{Field: "is_service", Message: message})
+		}
+		if flags.KYC {
+			if kycStatus == "" {
+				err := tx.Model(&User{}).Select("kyc_status").Where("id = ?", product.UserID).Scan(&kycStatus).Error
+				if err != nil {
+					return err
+				}
+			}
+			if kycStatus != "approved" {
+				fieldErrors = append(fieldErrors, ProductFieldError{Field: "category_id",
+					Message: fmt.Sprintf("category %s requires an approved KYC", categoryID)})
+			}
+		}
+		for _, path := range GetCategoryPath(categoryID) {
+			for _, name := range GetCategoryAttributes(path.ID) {
+				allowed[H.Slugify(name)] = true
+			}
+		}
+	}
+
+	slugs := make([]string, 0)
+	if attributes != nil {
+		for _, attribute := range *attributes {
+			slugs = append(slugs, attribute.Slug)
+		}
+	} else {
+		for _, attribute := range product.Attributes {
+			slugs = append(slugs, attribute.AttributeSlug)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, slug := range slugs {
+		if !allowed[H.Slugify(slug)] && !seen[slug] {
+			seen[slug] = true
+			fieldErrors = append(fieldErrors, ProductFieldError{Field: slug,
+				Message: fmt.Sprintf("attribute %s does not belong to the product categories", slug)})
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		return &ProductRulesError{Errors: fieldErrors}
+	}
+	return nil
+}
+
+// categoryFlags restricciones de la categoría, heredadas de sus categorías padre
+func categoryFlags(categoryID string) Category {
+	var flags Category
+	for _, path := range GetCategoryPath(categoryID) {
+		if category := GetCategoryByID(path.ID); category != nil {
+			flags.IsService = flags.IsService || category.IsService
+			flags.KYC = flags.KYC || category.KYC
+			flags.Only18 = flags.Only18 || category.Only18
+			flags.OnlyCompany = flags.OnlyCompany || category.OnlyCompany
+		}
+	}
+	return flags
+}
+
+// checkSellerWarehouses los almacenes deben ser del vendedor, estar activos y no repetirse
+func checkSellerWarehouses(tx *gorm.DB, userID string, requests []ProductWarehouseRequest) error {
+	if len(requests) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(requests))
+	for _, request := range requests {
+		if exists, _ := H.InArray(request.WarehouseID, ids); exists {
+			return fmt.Errorf("%w: warehouse %s is repeated", ErrInvalidProductInput, request.WarehouseID)
+		}
+		ids = append(ids, request.WarehouseID)
+	}
+
+	var count int64
+	err := tx.Model(&Warehouse{}).Where("id IN ? AND user_id = ? AND is_active = ?", ids, userID, true).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if int(count) != len(ids) {
+		return fmt.Errorf("%w: every warehouse must be an active warehouse of the seller", ErrInvalidProductInput)
+	}
+	return nil
+}
+
+// syncProductWarehouses actualiza las filas de los almacenes que siguen, crea las nuevas y borra las que ya no
+// vienen (con sus costos de envío). Devuelve almacén -> product_warehouse_id de cómo queda el producto
+func syncProductWarehouses(tx *gorm.DB, product *Product, requests *[]ProductWarehouseRequest) (map[string]string, error) {
+	current := make(map[string]ProductWarehouse, len(product.Warehouses))
+	for _, pw := range product.Warehouses {
+		current[pw.WarehouseID] = pw
+	}
+
+	warehouseIDs := make(map[string]string, len(current))
+	if requests == nil {
+		for warehouseID, pw := range current {
+			warehouseIDs[warehouseID] = pw.ID
+		}
+		return warehouseIDs, nil
+	}
+
+	for _, request := range *requests {
+		pw := ProductWarehouse{ProductID: product.ID, WarehouseID: request.WarehouseID}
+		if existing, ok := current[request.WarehouseID]; ok {
+			pw.ID = existing.ID
+		}
+		pw.Quantity = request.Quantity
+		pw.Weight = request.Weight
+		pw.Dimensions = encodeJSON(request.Dimensions)
+		pw.Specifications = encodeJSON(nonNilSpecifications(request.Specifications))
+
+		var err error
+		if pw.ID == "" {
+			err = tx.Omit(clause.Associations).Create(&pw).Error
+		} else {
+			err = tx.Model(&pw).Select("quantity", "weight", "dimensions", "specifications").Updates(&pw).Error
+		}
+		if err != nil {
+			return nil, err
+		}
+		warehouseIDs[request.WarehouseID] = pw.ID
+	}
+
+	removed := make([]string, 0)
+	for warehouseID, pw := range current {
+		if _, ok := warehouseIDs[warehouseID]; !ok {
+			removed = append(removed, pw.ID)
+		}
+	}
+	if len(removed) > 0 {
+		if err := deleteProductWarehouses(tx, product.ID, removed); err != nil {
+			return nil, err
+		}
+	}
+	return warehouseIDs, nil
+}
+
+// createProductAttributes crea los atributos; warehouseIDs traduce el almacén de cada atributo a su product_warehouse_id
+func createProductAttributes(tx *gorm.DB, productID string, requests []ProductAttributeRequest, warehouseIDs map[string]string) error {
+	if len(requests) == 0 {
+		return nil
+	}
+
+	attributes := make([]ProductAttribute, 0, len(requests))
+	for _, request := range requests {
+		slug := H.Slugify(request.Slug)
+		if slug == "" || string(request.Value) == "null" {
+			return fmt.Errorf("%w: attribute %q needs a slug and a value", ErrInvalidProductInput, request.Slug)
+		}
+
+		attribute := ProductAttribute{ProductID: productID, AttributeSlug: slug, Value: string(request.Value)}
+		if request.WarehouseID != nil {
+			productWarehouseID, ok := warehouseIDs[*request.WarehouseID]
+			if !ok {
+				return fmt.Errorf("%w: attribute %s uses warehouse %s, which is not one of the product warehouses",
+					ErrInvalidProductInput, slug, *request.WarehouseID)
+			}
+			attribute.ProductWarehouseID = &productWarehouseID
+		}
+		attributes = append(attributes, attribute)
+	}
+	return tx.Omit(clause.Associations).Create(&attributes).Error
+}
+
+// attributeRequestsFromProduct atributos actuales del producto en formato de petición, sin los de almacenes que
+// ya no están en warehouseIDs
+func attributeRequestsFromProduct(product *Product, warehouseIDs map[string]string) *[]ProductAttributeRequest {
+	warehouseByRow := make(map[string]string, len(product.Warehouses))
+	for _, pw := range product.Warehouses {
+		warehouseByRow[pw.ID] = pw.WarehouseID
+	}
+
+	requests := make([]ProductAttributeRequest, 0, len(product.Attributes))
+	for _, attribute := range product.Attributes {
+		request := ProductAttributeRequest{Slug: attribute.AttributeSlug, Value: json.RawMessage(attribute.Value)}
+		if attribute.ProductWarehouseID != nil {
+			warehouseID := warehouseByRow[*attribute.ProductWarehouseID]
+			if _, ok := warehouseIDs[warehouseID]; !ok {
+				continue
+			}
+			request.WarehouseID = &warehouseID
+		}
+		requests = append(requests, request)
+	}
+	return &requests
+}
+
+// deleteProductAttributes borra todos los atributos del producto (globales y por almacén)
+func deleteProductAttributes(tx *gorm.DB, productID string) error {
+	return tx.Where("product_id = ?", productID).Delete(&ProductAttribute{}).Error
+}
+
+// deleteProductWarehouses borra las filas de almacén indicadas (todas si ids es nil) con sus costos de envío, el
+// stock de las variantes en ellas y sus reservas
+func deleteProductWarehouses(tx *gorm.DB, productID string, ids []string) error {
+	query := tx.Where("product_id = ?", productID)
+	if ids != nil {
+		query = query.Where("id IN ?", ids)
+	}
+
+	var rowIDs []string
+	if err := query.Model(&ProductWarehouse{}).Pluck("id", &rowIDs).Error; err != nil {
+		return err
+	}
+	if len(rowIDs) == 0 {
+		return nil
+	}
+	if err := tx.Where("product_warehouse_id IN ?", rowIDs).Delete(&ShippingCost{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("product_warehouse_id IN ?", rowIDs).Delete(&ProductVariantStock{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("product_warehouse_id IN ?", rowIDs).Delete(&StockReservation{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("id IN ?", rowIDs).Delete(&ProductWarehouse{}).Error
+}
+
+// productSlug slug del título terminado en la ShortKey, que lo hace único
+func productSlug(title, shortKey string) string {
+	slug := H.Slugify(title)
+	if len(slug) > 200 {
+		slug = strings.Trim(slug[:200], "-")
+	}
+	if slug == "" {
+		return strings.ToLower(shortKey)
+	}
+	return slug + "-" + strings.ToLower(shortKey)
+}
+
+// newShortKey clave corta aleatoria de 10 caracteres
+func newShortKey() string {
+	random := make([]byte, 10)
+	if _, err := rand.Read(random); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	key := make([]byte, len(random))
+	for i, b := range random {
+		key[i] = shortKeyAlphabet[int(b)%len(shortKeyAlphabet)]
+	}
+	return string(key)
+}
+
+// encodeJSON valor para una columna JSON (las listas vacías como [] en lugar de null)
+func encodeJSON(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "null"
+	}
+	return string(encoded)
+}
+
+// canonicalJSON value con el formato de encodeJSON, para comparar JSON leído de la base con el enviado
+func canonicalJSON(value string) string {
+	var decoded interface{}
+	if json.Unmarshal([]byte(value), &decoded) != nil {
+		return value
+	}
+	return encodeJSON(decoded)
+}
+
+// nonNilSpecifications lista vacía en lugar de nil, para guardar [] en la columna
+func nonNilSpecifications(specifications []Specification) []Specification {
+	if specifications == nil {
+		return []Specification{}
+	}
+	return specifications
+}
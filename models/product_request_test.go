@@ -0,0 +1,32 @@
+package models
+
+import "testing"
+
+func TestApplyProductFieldsSKU(t *testing.T) {
+	current := "OLD-1"
+	tests := []struct {
+		name string
+		sku  *string
+		want *string
+	}{
+		{"not sent", nil, &current},
+		{"trimmed", ptr("  NEW-1 "), ptr("NEW-1")},
+		{"empty", ptr(""), nil},
+		{"blank", ptr("   "), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := &Product{SKU: &current}
+			if err := applyProductFields(product, ProductPatchRequest{SKU: tt.sku}); err != nil {
+				t.Fatal(err)
+			}
+			if (product.SKU == nil) != (tt.want == nil) || (product.SKU != nil && *product.SKU != *tt.want) {
+				t.Errorf("SKU = %v, want %v", product.SKU, tt.want)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
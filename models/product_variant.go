@@ -0,0 +1,735 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	H "mercadillo-global/helpers"
+)
+
+// Configuración de las reservas de stock del checkout: cuánto duran, cuántas unidades aparta cada una y cuántas
+// puede tener activas a la vez un visitante o una IP, para que nadie acapare el stock
+var (
+	StockReservationTTL         = 15 * time.Minute
+	StockReservationMaxQuantity = 10
+	StockReservationMaxActive   = 3
+)
+
+// Errores de variantes y reservas de stock
+var (
+	ErrVariantNotFound     = errors.New("variant not found")
+	ErrVariantRequired     = errors.New("the product has variants, choose one")
+	ErrOutOfStock          = errors.New("not enough stock")
+	ErrReservationNotFound = errors.New("reservation not found")
+	ErrReservationExpired  = errors.New("reservation expired")
+	ErrReservationLimit    = errors.New("reservation limit reached")
+)
+
+// Estados de StockReservation
+const (
+	ReservationActive    = "active"
+	ReservationConfirmed = "confirmed"
+	ReservationReleased  = "released"
+)
+
+// ProductVariant combinación vendible del producto ("talla 42 / rojo") con su SKU, precio y stock. Options es un
+// JSON {"talla": "42", "color": "Rojo"} con los slugs de los atributos; OptionKey es su forma canónica, única por
+// producto. Con almacenes, Stock es la suma de Stocks
+type ProductVariant struct {
+	ID        string    `json:"id" gorm:"type:char(36);primaryKey"`
+	ProductID string    `json:"product_id" gorm:"type:char(36);not null;index;uniqueIndex:idx_product_variants_option_key,priority:1"`
+	SKU       *string   `json:"sku" gorm:"type:varchar(100)"`
+	Options   string    `json:"options" gorm:"type:json;not null"`
+	OptionKey string    `json:"-" gorm:"type:varchar(255);not null;uniqueIndex:idx_product_variants_option_key,priority:2"`
+	Price     *int      `json:"price" gorm:"comment:'Overrides the product price'"`
+	Barcode   string    `json:"barcode" gorm:"type:varchar(50)"`
+	Stock     int       `json:"stock" gorm:"default:0"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relations
+	Stocks []ProductVariantStock `json:"stocks" gorm:"foreignKey:VariantID"`
+}
+
+func (v *ProductVariant) BeforeCreate(tx *gorm.DB) error {
+	if H.IsEmpty(v.ID) {
+		v.ID = H.NewUUID()
+	}
+	return nil
+}
+
+// OptionValues opciones de la variante (slug -> valor)
+func (v ProductVariant) OptionValues() map[string]string {
+	options := make(map[string]string)
+	json.Unmarshal([]byte(v.Options), &options)
+	return options
+}
+
+// EffectivePrice precio de la variante, o el del producto si no lo cambia
+func (v ProductVariant) EffectivePrice(product Product) int {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return product.Price
+}
+
+// ProductVariantStock stock de la variante en un almacén del producto
+type ProductVariantStock struct {
+	ID                 string    `json:"id" gorm:"type:char(36);primaryKey"`
+	VariantID          string    `json:"variant_id" gorm:"type:char(36);not null;uniqueIndex:idx_product_variant_stocks_variant,priority:1"`
+	ProductWarehouseID string    `json:"product_warehouse_id" gorm:"type:char(36);not null;index;uniqueIndex:idx_product_variant_stocks_variant,priority:2"`
+	Quantity           int       `json:"quantity" gorm:"default:0"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+func (s *ProductVariantStock) BeforeCreate(tx *gorm.DB) error {
+	if H.IsEmpty(s.ID) {
+		s.ID = H.NewUUID()
+	}
+	return nil
+}
+
+// StockReservation stock apartado en el checkout hasta ExpiresAt. Se toma de la variante (en un almacén si tiene
+// stock por almacén) o, en productos sin variantes, del almacén o del stock del producto
+type StockReservation struct {
+	ID                 string    `json:"id" gorm:"type:char(36);primaryKey"`
+	ProductID          string    `json:"product_id" gorm:"type:char(36);not null;index:idx_stock_reservations_product,priority:1"`
+	VariantID          *string   `json:"variant_id" gorm:"type:char(36);index"`
+	ProductWarehouseID *string   `json:"product_warehouse_id" gorm:"type:char(36)"`
+	HolderID           string    `json:"-" gorm:"type:varchar(64);not null;index:idx_stock_reservations_holder;comment:'Visitor that holds the stock'"`
+	HolderIP           string    `json:"-" gorm:"type:varchar(45);not null;index:idx_stock_reservations_holder_ip"`
+	Quantity           int       `json:"quantity" gorm:"not null"`
+	Status             string    `json:"status" gorm:"type:enum('active','confirmed','released');default:'active';index:idx_stock_reservations_product,priority:2"`
+	ExpiresAt          time.Time `json:"expires_at" gorm:"index:idx_stock_reservations_product,priority:3"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+func (r *StockReservation) BeforeCreate(tx *gorm.DB) error {
+	if H.IsEmpty(r.ID) {
+		r.ID = H.NewUUID()
+	}
+	return nil
+}
+
+// ProductVariantRequest variante en el cuerpo de la API de productos del vendedor. Con almacenes el stock va en
+// Stocks; sin almacenes, en Stock
+type ProductVariantRequest struct {
+	SKU     *string                      `json:"sku" validate:"omitempty,max=100"`
+	Options map[string]string            `json:"options" validate:"required,min=1,max=5,dive,keys,required,max=100,endkeys,required,max=100"`
+	Price   *int                         `json:"price" validate:"omitempty,gt=0"`
+	Barcode string                       `json:"barcode" validate:"omitempty,max=50"`
+	Stock   int                          `json:"stock" validate:"gte=0"`
+	Stocks  []ProductVariantStockRequest `json:"stocks" validate:"max=50,dive"`
+}
+
+// ProductVariantStockRequest stock de la variante en uno de los almacenes del producto
+type ProductVariantStockRequest struct {
+	WarehouseID string `json:"warehouse_id" validate:"required,max=36"`
+	Quantity    int    `json:"quantity" validate:"gte=0"`
+}
+
+// VariantOption opción del selector de variantes (ej. talla) con sus valores en el orden de las variantes
+type VariantOption struct {
+	Slug   string   `json:"slug"`
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// VariantView variante como la usa el selector de la página de producto
+type VariantView struct {
+	ID             string            `json:"id"`
+	Options        map[string]string `json:"options"`
+	Price          int               `json:"price"`
+	FormattedPrice string            `json:"formatted_price"`
+	Available      int               `json:"available"`
+}
+
+// VariantOptionKey forma canónica de las opciones: slugs ordenados y valores sin distinguir mayúsculas ni acentos
+func VariantOptionKey(options map[string]string) string {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key+"="+strings.ToLower(H.RemoveAccents(H.Trim(options[key]))))
+	}
+	return truncateRunes(strings.Join(parts, "|"), 255)
+}
+
+// variantOptionPath ruta JSON de una opción en product_variants.options. Se vuelve a pasar por Slugify porque
+// el slug puede venir de la URL (attr[...]) y no debe romper la ruta
+func variantOptionPath(slug string) string {
+	return `$."` + H.Slugify(slug) + `"`
+}
+
+// normalizeVariantOptions slugs de atributo como claves y valores sin espacios de más
+func normalizeVariantOptions(options map[string]string) (map[string]string, error) {
+	normalized := make(map[string]string, len(options))
+	for key, value := range options {
+		slug := H.Slugify(key)
+		value = H.Trim(value)
+		if slug == "" || value == "" {
+			return nil, fmt.Errorf("%w: variant option %q needs a name and a value", ErrInvalidProductInput, key)
+		}
+		if _, ok := normalized[slug]; ok {
+			return nil, fmt.Errorf("%w: variant option %s is repeated", ErrInvalidProductInput, slug)
+		}
+		normalized[slug] = value
+	}
+	return normalized, nil
+}
+
+// syncProductVariants reemplaza las variantes del producto por las de la petición. Las que tienen las mismas
+// opciones conservan su ID (y sus reservas); las que ya no vienen se borran con su stock y sus reservas
+func syncProductVariants(tx *gorm.DB, product *Product, requests []ProductVariantRequest, warehouseIDs map[string]string) error {
+	current := make(map[string]ProductVariant, len(product.Variants))
+	for _, variant := range product.Variants {
+		current[variant.OptionKey] = variant
+	}
+
+	kept := make(map[string]bool, len(requests))
+	skus := make(map[string]bool, len(requests))
+	for _, request := range requests {
+		options, err := normalizeVariantOptions(request.Options)
+		if err != nil {
+			return err
+		}
+		key := VariantOptionKey(options)
+		if kept[key] {
+			return fmt.Errorf("%w: two variants have the options %s", ErrInvalidProductInput, key)
+		}
+		kept[key] = true
+		if request.SKU != nil && H.Trim(*request.SKU) != "" {
+			sku := H.Trim(*request.SKU)
+			if skus[sku] {
+				return fmt.Errorf("%w: variant sku %s is repeated", ErrInvalidProductInput, sku)
+			}
+			skus[sku] = true
+			request.SKU = &sku
+		} else {
+			request.SKU = nil
+		}
+		if len(request.Stocks) > 0 && len(warehouseIDs) == 0 {
+			return fmt.Errorf("%w: variant %s has stock per warehouse but the product has no warehouses", ErrInvalidProductInput, key)
+		}
+		// Con almacenes el stock de la variante sale de Stocks: sin ellos quedaría en 0
+		if len(request.Stocks) == 0 && len(warehouseIDs) > 0 {
+			return fmt.Errorf("%w: variant %s needs its stock per warehouse (stocks) because the product has warehouses", ErrInvalidProductInput, key)
+		}
+
+		variant := ProductVariant{ProductID: product.ID, OptionKey: key}
+		if existing, ok := current[key]; ok {
+			variant.ID = existing.ID
+		}
+		variant.SKU = request.SKU
+		variant.Options = encodeJSON(options)
+		variant.Price = request.Price
+		variant.Barcode = H.Trim(request.Barcode)
+		variant.Stock = request.Stock
+
+		if variant.ID == "" {
+			err = tx.Omit(clause.Associations).Create(&variant).Error
+		} else {
+			err = tx.Model(&variant).Select("sku", "options", "price", "barcode", "stock").Updates(&variant).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("variant_id = ?", variant.ID).Delete(&ProductVariantStock{}).Error; err != nil {
+			return err
+		}
+		stocks := make([]ProductVariantStock, 0, len(request.Stocks))
+		seen := make(map[string]bool, len(request.Stocks))
+		for _, stock := range request.Stocks {
+			productWarehouseID, ok := warehouseIDs[stock.WarehouseID]
+			if !ok {
+				return fmt.Errorf("%w: variant %s uses warehouse %s, which is not one of the product warehouses",
+					ErrInvalidProductInput, key, stock.WarehouseID)
+			}
+			if seen[productWarehouseID] {
+				return fmt.Errorf("%w: variant %s repeats warehouse %s", ErrInvalidProductInput, key, stock.WarehouseID)
+			}
+			seen[productWarehouseID] = true
+			stocks = append(stocks, ProductVariantStock{VariantID: variant.ID, ProductWarehouseID: productWarehouseID, Quantity: stock.Quantity})
+		}
+		if len(stocks) > 0 {
+			if err := tx.Create(&stocks).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	removed := make([]string, 0)
+	for key, variant := range current {
+		if !kept[key] {
+			removed = append(removed, variant.ID)
+		}
+	}
+	return deleteProductVariants(tx, product.ID, removed)
+}
+
+// variantsHaveWarehouseStock indica si todas las variantes tienen stock por almacén
+func variantsHaveWarehouseStock(variants []ProductVariant) bool {
+	for _, variant := range variants {
+		if len(variant.Stocks) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// deleteProductVariants borra las variantes indicadas (todas si ids es nil) con su stock y sus reservas
+func deleteProductVariants(tx *gorm.DB, productID string, ids []string) error {
+	if ids != nil && len(ids) == 0 {
+		return nil
+	}
+	variants := tx.Model(&ProductVariant{}).Select("id").Where("product_id = ?", productID)
+	if ids != nil {
+		variants = variants.Where("id IN ?", ids)
+	}
+	if err := tx.Where("variant_id IN (?)", variants).Delete(&ProductVariantStock{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("variant_id IN (?)", variants).Delete(&StockReservation{}).Error; err != nil {
+		return err
+	}
+	query := tx.Where("product_id = ?", productID)
+	if ids != nil {
+		query = query.Where("id IN ?", ids)
+	}
+	return query.Delete(&ProductVariant{}).Error
+}
+
+// refreshVariantStock recalcula los totales de un producto con variantes: con almacenes, el stock de cada variante
+// y de cada almacén es la suma del stock por almacén de las variantes; el del producto, la suma de las variantes
+func refreshVariantStock(tx *gorm.DB, productID string, withWarehouses bool) error {
+	var variants int64
+	if err := tx.Model(&ProductVariant{}).Where("product_id = ?", productID).Count(&variants).Error; err != nil {
+		return err
+	}
+	if variants == 0 {
+		return nil
+	}
+
+	if withWarehouses {
+		err := tx.Exec(`UPDATE product_variants v SET stock = (SELECT COALESCE(SUM(s.quantity), 0) FROM product_variant_stocks s
+			WHERE s.variant_id = v.id) WHERE v.product_id = ?`, productID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Exec(`UPDATE product_warehouses pw SET quantity = (SELECT COALESCE(SUM(s.quantity), 0) FROM product_variant_stocks s
+			WHERE s.product_warehouse_id = pw.id) WHERE pw.product_id = ?`, productID).Error
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Exec("UPDATE products SET stock = (SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id = ?) WHERE id = ?",
+		productID, productID).Error
+}
+
+// BuildVariantOptions opciones del selector de variantes; el nombre de cada opción es el del atributo de la
+// categoría con ese slug
+func BuildVariantOptions(variants []ProductVariant, categoryID string) []VariantOption {
+	names := make(map[string]string)
+	for _, path := range GetCategoryPath(categoryID) {
+		for _, name := range GetCategoryAttributes(path.ID) {
+			names[H.Slugify(name)] = name
+		}
+	}
+
+	options := make([]VariantOption, 0)
+	index := make(map[string]int)
+	for _, variant := range variants {
+		values := variant.OptionValues()
+		slugs := make([]string, 0, len(values))
+		for slug := range values {
+			slugs = append(slugs, slug)
+		}
+		sort.Strings(slugs)
+
+		for _, slug := range slugs {
+			i, ok := index[slug]
+			if !ok {
+				name := names[slug]
+				if name == "" {
+					name = strings.ToUpper(slug[:1]) + strings.ReplaceAll(slug[1:], "-", " ")
+				}
+				i = len(options)
+				index[slug] = i
+				options = append(options, VariantOption{Slug: slug, Name: name})
+			}
+			if exists, _ := H.InArray(values[slug], options[i].Values); !exists {
+				options[i].Values = append(options[i].Values, values[slug])
+			}
+		}
+	}
+	return options
+}
+
+// GetVariantViews variantes del producto para el selector, con el stock disponible (sin lo reservado)
+func GetVariantViews(db *gorm.DB, product Product, variants []ProductVariant) ([]VariantView, error) {
+	var reserved []struct {
+		VariantID string
+		Quantity  int
+	}
+	err := db.Model(&StockReservation{}).Select("variant_id, SUM(quantity) AS quantity").
+		Where("product_id = ? AND variant_id IS NOT NULL AND status = ? AND expires_at > ?", product.ID, ReservationActive, time.Now()).
+		Group("variant_id").Scan(&reserved).Error
+	if err != nil {
+		return nil, err
+	}
+	reservedByVariant := make(map[string]int, len(reserved))
+	for _, row := range reserved {
+		reservedByVariant[row.VariantID] = row.Quantity
+	}
+
+	views := make([]VariantView, 0, len(variants))
+	for _, variant := range variants {
+		price := variant.EffectivePrice(product)
+		available := variant.Stock - reservedByVariant[variant.ID]
+		if available < 0 {
+			available = 0
+		}
+		views = append(views, VariantView{
+			ID:             variant.ID,
+			Options:        variant.OptionValues(),
+			Price:          price,
+			FormattedPrice: H.MaybeFormatNumber(float64(price), true),
+			Available:      available,
+		})
+	}
+	return views, nil
+}
+
+// stockSource de dónde sale el stock de una reserva (el almacén es nil si el stock no es por almacén)
+type stockSource struct {
+	ProductWarehouseID *string
+	Quantity           int
+}
+
+// ReserveStock aparta quantity unidades del producto (de la variante si tiene) para holderID durante
+// StockReservationTTL. Si el visitante ya tenía una reserva activa de la misma variante se reemplaza. Se toma el
+// almacén con más stock disponible que alcance. ErrReservationLimit si pasa de StockReservationMaxQuantity o si el
+// visitante o su IP (holderIP) ya tienen StockReservationMaxActive reservas activas
+func ReserveStock(db *gorm.DB, productID, variantID string, quantity int, holderID, holderIP string) (*StockReservation, error) {
+	if quantity < 1 {
+		return nil, fmt.Errorf("%w: quantity must be at least 1", ErrOutOfStock)
+	}
+	if quantity > StockReservationMaxQuantity {
+		return nil, fmt.Errorf("%w: at most %d units per purchase", ErrReservationLimit, StockReservationMaxQuantity)
+	}
+
+	var reservation *StockReservation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Las reservas de un producto se hacen de a una
+		var product Product
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, "id = ? AND status = ?", productID, "active").Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrProductNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var variant *ProductVariant
+		var variants int64
+		if err := tx.Model(&ProductVariant{}).Where("product_id = ?", productID).Count(&variants).Error; err != nil {
+			return err
+		}
+		if variantID != "" {
+			var found ProductVariant
+			err := tx.Preload("Stocks").First(&found, "id = ? AND product_id = ?", variantID, productID).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrVariantNotFound
+			}
+			if err != nil {
+				return err
+			}
+			variant = &found
+		} else if variants > 0 {
+			return ErrVariantRequired
+		}
+
+		var previous *string
+		if variant != nil {
+			previous = &variant.ID
+		}
+		err = tx.Model(&StockReservation{}).
+			Where("product_id = ? AND holder_id = ? AND variant_id <=> ? AND status = ?", productID, holderID, previous, ReservationActive).
+			Update("status", ReservationReleased).Error
+		if err != nil {
+			return err
+		}
+
+		var active int64
+		err = tx.Model(&StockReservation{}).
+			Where("(holder_id = ? OR holder_ip = ?) AND status = ? AND expires_at > ?", holderID, holderIP, ReservationActive, time.Now()).
+			Count(&active).Error
+		if err != nil {
+			return err
+		}
+		if active >= int64(StockReservationMaxActive) {
+			return fmt.Errorf("%w: at most %d active reservations", ErrReservationLimit, StockReservationMaxActive)
+		}
+
+		sources, err := stockSources(tx, &product, variant)
+		if err != nil {
+			return err
+		}
+		var chosen *stockSource
+		best := 0
+		for i, source := range sources {
+			available, err := availableStock(tx, productID, previous, source)
+			if err != nil {
+				return err
+			}
+			if available >= quantity && available > best {
+				chosen, best = &sources[i], available
+			}
+		}
+		if chosen == nil {
+			return ErrOutOfStock
+		}
+
+		reservation = &StockReservation{
+			ProductID:          productID,
+			VariantID:          previous,
+			ProductWarehouseID: chosen.ProductWarehouseID,
+			HolderID:           holderID,
+			HolderIP:           holderIP,
+			Quantity:           quantity,
+			Status:             ReservationActive,
+			ExpiresAt:          time.Now().Add(StockReservationTTL),
+		}
+		return tx.Create(reservation).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// stockSources stock de la variante por almacén (o su total), o el de los almacenes del producto (o su total)
+func stockSources(tx *gorm.DB, product *Product, variant *ProductVariant) ([]stockSource, error) {
+	if variant != nil {
+		if len(variant.Stocks) == 0 {
+			return []stockSource{{Quantity: variant.Stock}}, nil
+		}
+		sources := make([]stockSource, 0, len(variant.Stocks))
+		for _, stock := range variant.Stocks {
+			productWarehouseID := stock.ProductWarehouseID
+			sources = append(sources, stockSource{ProductWarehouseID: &productWarehouseID, Quantity: stock.Quantity})
+		}
+		return sources, nil
+	}
+
+	var warehouses []ProductWarehouse
+	if err := tx.Where("product_id = ?", product.ID).Find(&warehouses).Error; err != nil {
+		return nil, err
+	}
+	if len(warehouses) == 0 {
+		return []stockSource{{Quantity: product.Stock}}, nil
+	}
+	sources := make([]stockSource, 0, len(warehouses))
+	for _, warehouse := range warehouses {
+		productWarehouseID := warehouse.ID
+		sources = append(sources, stockSource{ProductWarehouseID: &productWarehouseID, Quantity: warehouse.Quantity})
+	}
+	return sources, nil
+}
+
+// availableStock stock de la fuente menos las reservas activas que no vencieron
+func availableStock(tx *gorm.DB, productID string, variantID *string, source stockSource) (int, error) {
+	var reserved int
+	err := tx.Model(&StockReservation{}).Select("COALESCE(SUM(quantity), 0)").
+		Where("product_id = ? AND variant_id <=> ? AND product_warehouse_id <=> ? AND status = ? AND expires_at > ?",
+			productID, variantID, source.ProductWarehouseID, ReservationActive, time.Now()).
+		Scan(&reserved).Error
+	return source.Quantity - reserved, err
+}
+
+// GetStockReservation reserva activa del visitante, con su variante
+func GetStockReservation(db *gorm.DB, reservationID, holderID string) (*StockReservation, *ProductVariant, error) {
+	var reservation StockReservation
+	err := db.First(&reservation, "id = ? AND holder_id = ? AND status = ?", reservationID, holderID, ReservationActive).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrReservationNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if reservation.ExpiresAt.Before(time.Now()) {
+		return nil, nil, ErrReservationExpired
+	}
+	if reservation.VariantID == nil {
+		return &reservation, nil, nil
+	}
+
+	var variant ProductVariant
+	if err := db.First(&variant, "id = ?", *reservation.VariantID).Error; err != nil {
+		return nil, nil, err
+	}
+	return &reservation, &variant, nil
+}
+
+// ConfirmStockReservation convierte la reserva del visitante en venta: descuenta el stock de donde se apartó
+// (variante, almacén y producto) y suma a vendidos
+func ConfirmStockReservation(db *gorm.DB, reservationID, holderID string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var reservation StockReservation
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&reservation, "id = ? AND holder_id = ? AND status = ?", reservationID, holderID, ReservationActive).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrReservationNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if reservation.ExpiresAt.Before(time.Now()) {
+			return ErrReservationExpired
+		}
+
+		quantity := reservation.Quantity
+		if reservation.VariantID != nil {
+			if reservation.ProductWarehouseID != nil {
+				err := tx.Model(&ProductVariantStock{}).
+					Where("variant_id = ? AND product_warehouse_id = ?", *reservation.VariantID, *reservation.ProductWarehouseID).
+					Update("quantity", gorm.Expr("GREATEST(quantity - ?, 0)", quantity)).Error
+				if err != nil {
+					return err
+				}
+			}
+			err := tx.Model(&ProductVariant{}).Where("id = ?", *reservation.VariantID).
+				Update("stock", gorm.Expr("GREATEST(stock - ?, 0)", quantity)).Error
+			if err != nil {
+				return err
+			}
+		}
+		if reservation.ProductWarehouseID != nil {
+			err := tx.Model(&ProductWarehouse{}).Where("id = ?", *reservation.ProductWarehouseID).
+				Update("quantity", gorm.Expr("GREATEST(quantity - ?, 0)", quantity)).Error
+			if err != nil {
+				return err
+			}
+		}
+		err = tx.Model(&Product{}).Where("id = ?", reservation.ProductID).Updates(map[string]interface{}{
+			"stock": gorm.Expr("GREATEST(stock - ?, 0)", quantity),
+			"sold":  gorm.Expr("sold + ?", quantity),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&reservation).Update("status", ReservationConfirmed).Error
+	})
+}
+
+// ReleaseStockReservation libera la reserva del visitante antes de que venza
+func ReleaseStockReservation(db *gorm.DB, reservationID, holderID string) error {
+	result := db.Model(&StockReservation{}).Where("id = ? AND holder_id = ? AND status = ?", reservationID, holderID, ReservationActive).
+		Update("status", ReservationReleased)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrReservationNotFound
+	}
+	return nil
+}
+
+// MigrateAttributeVariants pasa a variantes los atributos por almacén (el modelo anterior de variaciones): cada
+// combinación distinta de atributos de un almacén es una variante, con el stock de los almacenes que la tienen.
+// Los atributos migrados se borran. Solo toca productos sin variantes, así que se puede correr más de una vez.
+// Devuelve cuántos productos migró
+func MigrateAttributeVariants(db *gorm.DB) (int, error) {
+	var productIDs []string
+	err := db.Raw(`SELECT DISTINCT pa.product_id FROM product_attributes pa WHERE pa.product_warehouse_id IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = pa.product_id)`).Scan(&productIDs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, productID := range productIDs {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return migrateProductAttributeVariants(tx, productID)
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("product %s: %w", productID, err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// migrateProductAttributeVariants migra un producto (ver MigrateAttributeVariants)
+func migrateProductAttributeVariants(tx *gorm.DB, productID string) error {
+	var warehouses []ProductWarehouse
+	err := tx.Preload("Attributes").Where("product_id = ?", productID).Order("created_at, id").Find(&warehouses).Error
+	if err != nil {
+		return err
+	}
+
+	variants := make([]*ProductVariant, 0)
+	byKey := make(map[string]*ProductVariant)
+	for _, warehouse := range warehouses {
+		if len(warehouse.Attributes) == 0 {
+			continue
+		}
+		options := make(map[string]string, len(warehouse.Attributes))
+		for _, attribute := range warehouse.Attributes {
+			if value := attributeOptionValue(attribute.Value); value != "" {
+				options[attribute.AttributeSlug] = value
+			}
+		}
+		if len(options) == 0 {
+			continue
+		}
+
+		key := VariantOptionKey(options)
+		variant, ok := byKey[key]
+		if !ok {
+			variant = &ProductVariant{ID: H.NewUUID(), ProductID: productID, Options: encodeJSON(options), OptionKey: key}
+			byKey[key] = variant
+			variants = append(variants, variant)
+		}
+		variant.Stocks = append(variant.Stocks, ProductVariantStock{VariantID: variant.ID, ProductWarehouseID: warehouse.ID, Quantity: warehouse.Quantity})
+		variant.Stock += warehouse.Quantity
+	}
+
+	for _, variant := range variants {
+		if err := tx.Create(variant).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Where("product_id = ? AND product_warehouse_id IS NOT NULL", productID).Delete(&ProductAttribute{}).Error; err != nil {
+		return err
+	}
+	if err := refreshVariantStock(tx, productID, true); err != nil {
+		return err
+	}
+	// Cambia updated_at para que los feeds de catálogo lo regeneren
+	return tx.Model(&Product{}).Where("id = ?", productID).Update("updated_at", time.Now()).Error
+}
+
+// attributeOptionValue valor de opción de un atributo guardado como JSON: el texto si es un string, el JSON tal
+// cual si no
+func attributeOptionValue(raw string) string {
+	var text string
+	if json.Unmarshal([]byte(raw), &text) == nil {
+		return H.Trim(text)
+	}
+	return H.Trim(raw)
+}
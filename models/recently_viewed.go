@@ -0,0 +1,91 @@
+package models
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	H "mercadillo-global/helpers"
+)
+
+// Configuración de vistos recientemente. La lista vive en la caché de sesiones, que está en memoria: se pierde
+// al reiniciar y la sesión sin uso se descarta a las 6 horas, así que no tiene sentido guardarla más tiempo
+var (
+	RecentlyViewedLimit    = 20
+	RecentlyViewedDuration = 6 * time.Hour
+)
+
+// recentlyViewedKey clave en la sesión (H.GetCacheSession) con los IDs vistos, el más reciente primero
+const recentlyViewedKey = "recently_viewed"
+
+// recentlyViewedMu serializa las lecturas y escrituras de la lista (UserCache no tiene actualización atómica)
+var recentlyViewedMu sync.Mutex
+
+// AddRecentlyViewed pone el producto al inicio de la lista de la sesión (H.VisitorSessionKey o H.UserSessionKey)
+func AddRecentlyViewed(sessionID, productID string) {
+	recentlyViewedMu.Lock()
+	defer recentlyViewedMu.Unlock()
+
+	session := H.GetCacheSession(sessionID)
+	ids := mergeRecentlyViewedIDs([]string{productID}, recentlyViewedIDs(session))
+	session.Set(recentlyViewedKey, ids, RecentlyViewedDuration)
+}
+
+// GetRecentlyViewedIDs IDs vistos por la sesión, el más reciente primero
+func GetRecentlyViewedIDs(sessionID string) []string {
+	recentlyViewedMu.Lock()
+	defer recentlyViewedMu.Unlock()
+	return append([]string{}, recentlyViewedIDs(H.GetCacheSession(sessionID))...)
+}
+
+// MergeRecentlyViewed pasa lo visto como anónimo a la lista del usuario (lo del visitante va primero) y vacía la del visitante
+func MergeRecentlyViewed(visitorID, userID string) {
+	if visitorID == "" || userID == "" {
+		return
+	}
+
+	recentlyViewedMu.Lock()
+	defer recentlyViewedMu.Unlock()
+
+	visitorSession := H.GetCacheSession(H.VisitorSessionKey(visitorID))
+	visitorIDs := recentlyViewedIDs(visitorSession)
+	if len(visitorIDs) == 0 {
+		return
+	}
+
+	userSession := H.GetCacheSession(H.UserSessionKey(userID))
+	userSession.Set(recentlyViewedKey, mergeRecentlyViewedIDs(visitorIDs, recentlyViewedIDs(userSession)), RecentlyViewedDuration)
+	visitorSession.Delete(recentlyViewedKey)
+}
+
+// GetRecentlyViewedProducts productos activos vistos por la sesión en el orden en que se vieron, sin los excluidos
+func GetRecentlyViewedProducts(db *gorm.DB, sessionID string, exclude ...string) ([]Product, error) {
+	ids := make([]string, 0)
+	for _, id := range GetRecentlyViewedIDs(sessionID) {
+		if exists, _ := H.InArray(id, exclude); !exists {
+			ids = append(ids, id)
+		}
+	}
+	return getActiveProductsByIDs(db, ids)
+}
+
+// recentlyViewedIDs lista guardada en la sesión (nil si no hay)
+func recentlyViewedIDs(session *H.UserCache) []string {
+	ids, _ := session.Get(recentlyViewedKey).([]string)
+	return ids
+}
+
+// mergeRecentlyViewedIDs une las listas sin repetidos respetando el orden y el máximo de RecentlyViewedLimit
+func mergeRecentlyViewedIDs(first, second []string) []string {
+	ids := make([]string, 0, RecentlyViewedLimit)
+	for _, id := range append(append([]string{}, first...), second...) {
+		if len(ids) >= RecentlyViewedLimit {
+			break
+		}
+		if id != "" {
+			ids = appendUnique(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	H "mercadillo-global/helpers"
+)
+
+// Configuración de productos relacionados
+var (
+	RelatedCacheDuration = 30 * time.Minute
+	relatedPriceRange    = 0.5 // Precio similar: entre 50% y 150% del precio del producto
+)
+
+// relatedCache relacionados de cada producto, por id
+var relatedCache = H.NewCache()
+
+// RelatedProducts productos similares y otras publicaciones del mismo vendedor
+type RelatedProducts struct {
+	Similar    []Product `json:"similar"`
+	FromSeller []Product `json:"from_seller"`
+}
+
+// GetRelatedProducts relacionados de un producto (con ProductCategories cargadas), cacheados por producto
+func GetRelatedProducts(db *gorm.DB, product Product, limit int) (*RelatedProducts, error) {
+	if related, ok := relatedCache.Get(product.ID).(*RelatedProducts); ok {
+		return related, nil
+	}
+
+	similar, err := GetSimilarProducts(db, product, limit)
+	if err != nil {
+		return nil, err
+	}
+	fromSeller, err := GetSellerProducts(db, product, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	related := &RelatedProducts{Similar: similar, FromSeller: fromSeller}
+	relatedCache.Set(product.ID, related, RelatedCacheDuration)
+	return related, nil
+}
+
+// GetSimilarProducts productos activos de la categoría primaria (y sus subcategorías) con precio similar,
+// ordenados por cantidad de atributos con el mismo valor, rating y ventas
+func GetSimilarProducts(db *gorm.DB, product Product, limit int) ([]Product, error) {
+	products := make([]Product, 0)
+	categoryID := productPrimaryCategoryID(product)
+	if categoryID == "" {
+		return products, nil
+	}
+
+	price := float64(product.Price)
+	err := categoryBaseQuery(db, categoryID).
+		Select("p.*").
+		Where("p.id <> ? AND p.price BETWEEN ? AND ?", product.ID, price*(1-relatedPriceRange), price*(1+relatedPriceRange)).
+		Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL: "(SELECT COUNT(DISTINCT pa.attribute_slug) FROM product_attributes pa " +
+				"JOIN product_attributes src ON src.attribute_slug = pa.attribute_slug AND src.value = pa.value " +
+				"WHERE pa.product_id = p.id AND src.product_id = ?) DESC, p.rating DESC, p.sold DESC, p.id",
+			Vars:               []interface{}{product.ID},
+			WithoutParentheses: true,
+		}}).
+		Limit(limit).
+		Find(&products).Error
+	return products, err
+}
+
+// GetSellerProducts otras publicaciones activas del mismo vendedor, las más vendidas primero
+func GetSellerProducts(db *gorm.DB, product Product, limit int) ([]Product, error) {
+	products := make([]Product, 0)
+	err := db.Where("user_id = ? AND status = ? AND id <> ?", product.UserID, "active", product.ID).
+		Order("sold DESC, rating DESC, id").
+		Limit(limit).
+		Find(&products).Error
+	return products, err
+}
+
+// productPrimaryCategoryID categoría primaria del producto o la primera asignada
+func productPrimaryCategoryID(product Product) string {
+	for _, pc := range product.ProductCategories {
+		if pc.IsPrimary {
+			return pc.CategoryID
+		}
+	}
+	if len(product.ProductCategories) > 0 {
+		return product.ProductCategories[0].CategoryID
+	}
+	return ""
+}
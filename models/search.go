@@ -1,9 +1,16 @@
 package models
 
 import (
+	"fmt"
+
 	"gorm.io/gorm"
+
+	H "mercadillo-global/helpers"
 )
 
+// SearchTotalCap máximo de resultados que se cuentan en modo cursor; por encima el total es aproximado
+const SearchTotalCap = 1000
+
 // SearchFilters estructura para filtros de búsqueda
 type SearchFilters struct {
 	Categories   []string `json:"categories"`
@@ -12,24 +19,82 @@ type SearchFilters struct {
 	MinRating    *float64 `json:"min_rating"`
 	IsService    *bool    `json:"is_service"`
 	FreeShipping *bool    `json:"free_shipping"`
+	Reviews      *int     `json:"reviews"`
+	Sales        *int     `json:"sales"`
 	Limit        int      `json:"limit"`
 	Offset       int      `json:"offset"`
+	ShipTo       *ShipTo  `json:"ship_to,omitempty"` // Solo productos que llegan a este destino
+	WithFacets   bool     `json:"-"`                 // Calcular conteos por faceta junto con los resultados
+	AutoCorrect  bool     `json:"-"`                 // Sin resultados, repetir la búsqueda con la corrección ortográfica
+	SortBy       string   `json:"sort_by,omitempty"` // Mismos valores que la categoría; vacío = relevancia
+	UseCursor    bool     `json:"-"`                 // Paginar por keyset (Cursor) en lugar de Offset; solo sin SortBy
+	Cursor       string   `json:"-"`                 // Cursor encriptado de la página anterior (vacío = primera página)
 }
 
 // SearchResult estructura para resultados de búsqueda
 type SearchResult struct {
-	Products   []Product `json:"products"`
-	Total      int64     `json:"total"`
-	Page       int       `json:"page"`
-	PerPage    int       `json:"per_page"`
-	TotalPages int       `json:"total_pages"`
+	Products   []Product   `json:"products"`
+	Total      int64       `json:"total"`
+	Page       int         `json:"page"`
+	PerPage    int         `json:"per_page"`
+	TotalPages int         `json:"total_pages"`
+	Facets     FacetCounts `json:"facets,omitempty"`
+
+	// "Quisiste decir": corrección sugerida cuando no hubo resultados; Corrected indica que los resultados son de ella
+	Suggestion string `json:"suggestion,omitempty"`
+	Corrected  bool   `json:"corrected,omitempty"`
+
+	// Id del registro en search_queries; se pasa como ?sq= al abrir un producto para medir clics
+	QueryID string `json:"query_id,omitempty"`
+
+	// Modo cursor: el total se cuenta hasta SearchTotalCap
+	TotalCapped bool   `json:"total_capped,omitempty"`
+	NextCursor  string `json:"next_cursor,omitempty"`
+	HasMore     bool   `json:"has_more,omitempty"`
 }
 
-// SearchProducts búsqueda principal usando search_content y search_keywords optimizados
-func SearchProducts(db *gorm.DB, query string, filters SearchFilters) (*SearchResult, error) {
-	var products []Product
-	var total int64
+// Modos de coincidencia de la cadena de búsqueda (optimizada, respaldo FULLTEXT y LIKE)
+const (
+	searchModeOptimized = iota
+	searchModeBasic
+	searchModeLike
+)
+
+// applySearchMatch agrega la condición de coincidencia de texto según el modo de búsqueda
+func applySearchMatch(query *gorm.DB, text string, mode int) *gorm.DB {
+	switch mode {
+	case searchModeBasic:
+		return query.Where("MATCH(title, description) AGAINST(? IN NATURAL LANGUAGE MODE)", text)
+	case searchModeLike:
+		return query.Where("title LIKE ?", "%"+text+"%")
+	default:
+		// search_content se guarda normalizado, la consulta pasa por el mismo pipeline (con sinónimos)
+		return query.Where("MATCH(search_content, search_keywords) AGAINST(? IN NATURAL LANGUAGE MODE)", NormalizeSearchQuery(text))
+	}
+}
+
+// searchRelevanceExpr expresión de relevancia de cada modo (LIKE no tiene puntaje)
+func searchRelevanceExpr(text string, mode int) (string, []interface{}) {
+	switch mode {
+	case searchModeBasic:
+		return "MATCH(title, description) AGAINST(? IN NATURAL LANGUAGE MODE)", []interface{}{text}
+	case searchModeLike:
+		return "0", nil
+	default:
+		return "MATCH(search_content, search_keywords) AGAINST(? IN NATURAL LANGUAGE MODE)", []interface{}{NormalizeSearchQuery(text)}
+	}
+}
+
+// searchModeQuery consulta de búsqueda con relevancia, coincidencia y filtros para un modo
+func searchModeQuery(db *gorm.DB, text string, filters SearchFilters, mode int) *gorm.DB {
+	relevance, args := searchRelevanceExpr(text, mode)
+	query := db.Model(&Product{}).Select("*, "+relevance+" AS relevance", args...)
+	return applySearchFilters(applySearchMatch(query, text, mode), filters)
+}
 
+// SearchProducts búsqueda principal: delega en el motor configurado (MySQL por defecto) y, sin resultados,
+// agrega la corrección ortográfica
+func SearchProducts(db *gorm.DB, query string, filters SearchFilters) (*SearchResult, error) {
 	// Configurar paginación por defecto
 	if filters.Limit <= 0 {
 		filters.Limit = 20
@@ -37,14 +102,33 @@ func SearchProducts(db *gorm.DB, query string, filters SearchFilters) (*SearchRe
 	if filters.Offset < 0 {
 		filters.Offset = 0
 	}
+	// El keyset sigue el orden por relevancia: con otro orden se pagina por Offset
+	if filters.SortBy != "" && filters.UseCursor {
+		filters.UseCursor = false
+		filters.Cursor = ""
+	}
 
-	// Query principal con full-text search optimizado
-	baseQuery := db.Model(&Product{}).
-		Select("*, MATCH(search_content, search_keywords) AGAINST(? IN NATURAL LANGUAGE MODE) AS relevance", query).
-		Where("MATCH(search_content, search_keywords) AGAINST(? IN NATURAL LANGUAGE MODE)", query)
+	result, err := GetSearchEngine(db).Query(query, filters)
+	if err != nil {
+		return nil, err
+	}
+	if result.Total == 0 && filters.Cursor == "" {
+		return withSpellingSuggestion(db, query, filters, result)
+	}
+	return result, nil
+}
 
-	// Aplicar filtros
-	baseQuery = applySearchFilters(baseQuery, filters)
+// searchMySQL búsqueda usando search_content y search_keywords optimizados, con respaldo en title/description y LIKE
+func searchMySQL(db *gorm.DB, query string, filters SearchFilters) (*SearchResult, error) {
+	var products []Product
+	var total int64
+
+	if filters.UseCursor {
+		return searchProductsWithCursor(db, query, filters)
+	}
+
+	// Query principal con full-text search optimizado (consulta normalizada y expandida con sinónimos)
+	baseQuery := searchModeQuery(db, query, filters, searchModeOptimized)
 
 	// Obtener total de resultados
 	if err := baseQuery.Count(&total).Error; err != nil {
@@ -56,9 +140,9 @@ func SearchProducts(db *gorm.DB, query string, filters SearchFilters) (*SearchRe
 		return SearchProductsBasic(db, query, filters)
 	}
 
-	// Obtener productos ordenados por relevancia
+	// Obtener productos ordenados por relevancia (u otro orden pedido)
 	err := baseQuery.
-		Order("relevance DESC, rating DESC, sold DESC").
+		Order(searchOrderBy(filters.SortBy)).
 		Limit(filters.Limit).
 		Offset(filters.Offset).
 		Find(&products).Error
@@ -71,13 +155,22 @@ func SearchProducts(db *gorm.DB, query string, filters SearchFilters) (*SearchRe
 	page := (filters.Offset / filters.Limit) + 1
 	totalPages := int((total + int64(filters.Limit) - 1) / int64(filters.Limit))
 
-	return &SearchResult{
+	result := &SearchResult{
 		Products:   products,
 		Total:      total,
 		Page:       page,
 		PerPage:    filters.Limit,
 		TotalPages: totalPages,
-	}, nil
+	}
+
+	// Facetas sobre la misma condición de búsqueda
+	if filters.WithFacets {
+		if result.Facets, err = getSearchFacets(db, query, filters, searchModeOptimized); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
 }
 
 // SearchProductsBasic búsqueda de respaldo usando title y description
@@ -94,12 +187,7 @@ func SearchProductsBasic(db *gorm.DB, query string, filters SearchFilters) (*Sea
 	}
 
 	// Query de respaldo con full-text search básico
-	baseQuery := db.Model(&Product{}).
-		Select("*, MATCH(title, description) AGAINST(? IN NATURAL LANGUAGE MODE) AS relevance", query).
-		Where("MATCH(title, description) AGAINST(? IN NATURAL LANGUAGE MODE)", query)
-
-	// Aplicar filtros
-	baseQuery = applySearchFilters(baseQuery, filters)
+	baseQuery := searchModeQuery(db, query, filters, searchModeBasic)
 
 	// Obtener total de resultados
 	if err := baseQuery.Count(&total).Error; err != nil {
@@ -107,16 +195,16 @@ func SearchProductsBasic(db *gorm.DB, query string, filters SearchFilters) (*Sea
 	}
 
 	// Si tampoco hay resultados, buscar por coincidencias parciales en el título
+	mode := searchModeBasic
 	if total == 0 {
-		baseQuery = db.Model(&Product{}).
-			Where("title LIKE ?", "%"+query+"%")
-		baseQuery = applySearchFilters(baseQuery, filters)
+		mode = searchModeLike
+		baseQuery = searchModeQuery(db, query, filters, mode)
 		baseQuery.Count(&total)
 	}
 
 	// Obtener productos
 	err := baseQuery.
-		Order("relevance DESC, rating DESC, sold DESC").
+		Order(searchOrderBy(filters.SortBy)).
 		Limit(filters.Limit).
 		Offset(filters.Offset).
 		Find(&products).Error
@@ -129,20 +217,181 @@ func SearchProductsBasic(db *gorm.DB, query string, filters SearchFilters) (*Sea
 	page := (filters.Offset / filters.Limit) + 1
 	totalPages := int((total + int64(filters.Limit) - 1) / int64(filters.Limit))
 
-	return &SearchResult{
+	result := &SearchResult{
 		Products:   products,
 		Total:      total,
 		Page:       page,
 		PerPage:    filters.Limit,
 		TotalPages: totalPages,
-	}, nil
+	}
+
+	// Facetas sobre la misma condición de búsqueda que produjo los resultados
+	if filters.WithFacets && total > 0 {
+		if result.Facets, err = getSearchFacets(db, query, filters, mode); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// searchOrderBy orden de los resultados; por defecto relevancia con desempate por rating y ventas
+func searchOrderBy(sortBy string) string {
+	switch sortBy {
+	case "price_asc":
+		return "price ASC, relevance DESC"
+	case "price_desc":
+		return "price DESC, relevance DESC"
+	case "rating":
+		return "rating DESC, relevance DESC"
+	case "sales":
+		return "sold DESC, relevance DESC"
+	case "trending":
+		return "trending_score DESC, relevance DESC"
+	case "newest":
+		return "created_at DESC"
+	}
+	return "relevance DESC, rating DESC, sold DESC"
+}
+
+// withSpellingSuggestion agrega la corrección ortográfica a un resultado vacío y, si se pidió, repite la búsqueda con ella
+func withSpellingSuggestion(db *gorm.DB, query string, filters SearchFilters, result *SearchResult) (*SearchResult, error) {
+	suggestion := SpellingSuggestion(query)
+	if suggestion == "" {
+		return result, nil
+	}
+
+	if filters.AutoCorrect && filters.Offset == 0 && filters.Cursor == "" {
+		filters.AutoCorrect = false // Una sola corrección
+		corrected, err := SearchProducts(db, suggestion, filters)
+		if err != nil {
+			return nil, err
+		}
+		if corrected.Total > 0 {
+			corrected.Suggestion = suggestion
+			corrected.Corrected = true
+			return corrected, nil
+		}
+	}
+
+	result.Suggestion = suggestion
+	return result, nil
+}
+
+// searchProductsWithCursor pagina la búsqueda por keyset (relevance, rating, sold, id) sin OFFSET ni COUNT completo
+func searchProductsWithCursor(db *gorm.DB, query string, filters SearchFilters) (*SearchResult, error) {
+	var products []Product
+
+	// Un cursor inválido, expirado o de otra búsqueda se rechaza (H.ErrInvalidCursor)
+	cursorData, err := H.DecryptCursor(filters.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	hasCursor := cursorData.ID != ""
+	if hasCursor && cursorData.FilterHash != searchCursorHash(query, filters) {
+		return nil, H.ErrCursorFilterMismatch
+	}
+
+	// El modo de coincidencia se decide en la primera página y viaja en el cursor
+	mode := cursorData.SearchMode
+	total, capped, err := cappedSearchTotal(db, query, filters, mode)
+	if err != nil {
+		return nil, err
+	}
+	for !hasCursor && total == 0 && mode < searchModeLike {
+		mode++
+		if total, capped, err = cappedSearchTotal(db, query, filters, mode); err != nil {
+			return nil, err
+		}
+	}
+
+	baseQuery := searchModeQuery(db, query, filters, mode)
+	if hasCursor && cursorData.Relevance != nil && cursorData.Rating != nil && cursorData.Sold != nil {
+		// Continuar después de la última fila: orden descendente salvo id (desempate ascendente)
+		relevance, args := searchRelevanceExpr(query, mode)
+		condition := fmt.Sprintf("(%[1]s < ? OR (%[1]s = ? AND (rating < ? OR (rating = ? AND (sold < ? OR (sold = ? AND id > ?))))))", relevance)
+		values := append(append([]interface{}{}, args...), *cursorData.Relevance)
+		values = append(append(values, args...), *cursorData.Relevance)
+		values = append(values, *cursorData.Rating, *cursorData.Rating, *cursorData.Sold, *cursorData.Sold, cursorData.ID)
+		baseQuery = baseQuery.Where(condition, values...)
+	}
+
+	err = baseQuery.
+		Order("relevance DESC, rating DESC, sold DESC, id ASC").
+		Limit(filters.Limit + 1). // +1 para saber si hay más páginas
+		Find(&products).Error
+	if err != nil {
+		return nil, err
+	}
+
+	hasMore := len(products) > filters.Limit
+	if hasMore {
+		products = products[:filters.Limit]
+	}
+
+	result := &SearchResult{
+		Products:    products,
+		Total:       total,
+		TotalCapped: capped,
+		PerPage:     filters.Limit,
+		HasMore:     hasMore,
+	}
+
+	if hasMore && len(products) > 0 {
+		last := products[len(products)-1]
+		result.NextCursor, err = H.EncryptCursor(H.CursorData{
+			ID:         last.ID,
+			Relevance:  &last.Relevance,
+			Rating:     &last.Rating,
+			Sold:       &last.Sold,
+			SearchMode: mode,
+			FilterHash: searchCursorHash(query, filters),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if filters.WithFacets && total > 0 {
+		if result.Facets, err = getSearchFacets(db, query, filters, mode); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// cappedSearchTotal cuenta los resultados hasta SearchTotalCap; capped indica que hay más
+func cappedSearchTotal(db *gorm.DB, query string, filters SearchFilters, mode int) (int64, bool, error) {
+	var total int64
+	limited := applySearchFilters(applySearchMatch(db.Model(&Product{}).Select("id"), query, mode), filters).
+		Limit(SearchTotalCap + 1)
+	if err := db.Table("(?) AS capped", limited).Count(&total).Error; err != nil {
+		return 0, false, err
+	}
+	if total > SearchTotalCap {
+		return SearchTotalCap, true, nil
+	}
+	return total, false, nil
+}
+
+// searchCursorHash hash de la búsqueda y filtros a los que queda atado un cursor (sin paginación)
+func searchCursorHash(query string, filters SearchFilters) string {
+	filters.Limit = 0
+	filters.Offset = 0
+	filters.Cursor = ""
+	return H.CursorFilterHash(struct {
+		Query   string        `json:"query"`
+		Filters SearchFilters `json:"filters"`
+	}{query, filters})
 }
 
 // applySearchFilters aplica los filtros comunes a las consultas de búsqueda
 func applySearchFilters(query *gorm.DB, filters SearchFilters) *gorm.DB {
-	// Filtrar por categorías
+	// Filtrar por categorías (incluyendo subcategorías) a través de product_categories
 	if len(filters.Categories) > 0 {
-		query = query.Where("category_id IN ?", filters.Categories)
+		query = query.Where("id IN (SELECT product_id FROM product_categories WHERE category_id IN ?)",
+			ExpandCategoryIDs(filters.Categories))
 	}
 
 	// Filtrar por rango de precios
@@ -163,6 +412,28 @@ func applySearchFilters(query *gorm.DB, filters SearchFilters) *gorm.DB {
 		query = query.Where("free_shipping = ?", *filters.FreeShipping)
 	}
 
+	// Filtrar por destino de envío (los servicios no se envían, siempre pasan)
+	if filters.ShipTo != nil && filters.ShipTo.Country != "" {
+		shipToSQL, args := shipToProductIDsSQL(*filters.ShipTo)
+		query = query.Where("(is_service = TRUE OR id IN ("+shipToSQL+"))", args...)
+	}
+
+	// Filtrar por cantidad de reviews y ventas (0 = ninguna)
+	if filters.Reviews != nil {
+		if *filters.Reviews == 0 {
+			query = query.Where("review_count = 0")
+		} else {
+			query = query.Where("review_count >= ?", *filters.Reviews)
+		}
+	}
+	if filters.Sales != nil {
+		if *filters.Sales == 0 {
+			query = query.Where("sold = 0")
+		} else {
+			query = query.Where("sold >= ?", *filters.Sales)
+		}
+	}
+
 	// Filtrar por tipo de servicio
 	if filters.IsService != nil {
 		query = query.Where("is_service = ?", *filters.IsService)
@@ -189,10 +460,10 @@ func SearchProductsByCategory(db *gorm.DB, categories []string, filters SearchFi
 	// Forzar las categorías en los filtros
 	filters.Categories = categories
 
-	// Query para productos en categorías específicas
-	baseQuery := db.Model(&Product{}).Where("category_id IN ?", categories)
+	// Query para productos en categorías específicas (el filtro de categorías lo aplica applySearchFilters)
+	baseQuery := db.Model(&Product{})
 
-	// Aplicar otros filtros
+	// Aplicar filtros
 	baseQuery = applySearchFilters(baseQuery, filters)
 
 	// Obtener total
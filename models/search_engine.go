@@ -0,0 +1,119 @@
+package models
+
+import (
+	"sync"
+
+	"gorm.io/gorm"
+
+	H "mercadillo-global/helpers"
+)
+
+// SearchEngine backend de búsqueda de productos. Query recibe los mismos filtros, facetas y orden que SearchProducts
+type SearchEngine interface {
+	Index(products ...Product) error
+	Delete(productIDs ...string) error
+	Query(query string, filters SearchFilters) (*SearchResult, error)
+}
+
+var (
+	searchEngineMu sync.RWMutex
+	searchEngine   SearchEngine
+)
+
+// SetSearchEngine reemplaza el motor de búsqueda global (nil = MySQL FULLTEXT)
+func SetSearchEngine(engine SearchEngine) {
+	searchEngineMu.Lock()
+	defer searchEngineMu.Unlock()
+	searchEngine = engine
+}
+
+// GetSearchEngine devuelve el motor configurado o el de MySQL sobre la conexión indicada
+func GetSearchEngine(db *gorm.DB) SearchEngine {
+	searchEngineMu.RLock()
+	defer searchEngineMu.RUnlock()
+	if searchEngine != nil {
+		return searchEngine
+	}
+	return NewMySQLSearchEngine(db)
+}
+
+// MySQLSearchEngine búsqueda con MATCH ... AGAINST sobre los índices FULLTEXT de products
+type MySQLSearchEngine struct {
+	db *gorm.DB
+}
+
+// NewMySQLSearchEngine crea el motor MySQL
+func NewMySQLSearchEngine(db *gorm.DB) *MySQLSearchEngine {
+	return &MySQLSearchEngine{db: db}
+}
+
+// Index guarda search_content y search_keywords (generándolos si faltan); MySQL mantiene el índice FULLTEXT
+func (e *MySQLSearchEngine) Index(products ...Product) error {
+	for _, product := range products {
+		if product.SearchContent == "" {
+			product.GenerateSearchContent()
+		}
+		err := e.db.Model(&Product{}).Where("id = ?", product.ID).UpdateColumns(map[string]interface{}{
+			"search_content":  product.SearchContent,
+			"search_keywords": product.SearchKeywords,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete no hace nada: las filas borradas o inactivas ya quedan fuera de las consultas
+func (e *MySQLSearchEngine) Delete(productIDs ...string) error {
+	return nil
+}
+
+// Query ejecuta la búsqueda FULLTEXT con sus respaldos
+func (e *MySQLSearchEngine) Query(query string, filters SearchFilters) (*SearchResult, error) {
+	return searchMySQL(e.db, query, filters)
+}
+
+// NormalizeSearchColumns reescribe search_content y search_keywords de todos los productos con la normalización
+// actual: los guardados antes de ella no coinciden con las consultas normalizadas (los vacíos se generan). No toca
+// updated_at y reindexa los productos activos que cambiaron. Devuelve cuántos productos cambió
+func NormalizeSearchColumns(db *gorm.DB) (int, error) {
+	updated := 0
+	var batch []Product
+	err := db.Preload("ProductCategories").
+		Preload("Warehouses.Warehouse").
+		Preload("Warehouses.ShippingCosts").
+		FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+			active := make([]Product, 0)
+			for _, product := range batch {
+				content, keywords := product.SearchContent, product.SearchKeywords
+				if H.IsEmpty(product.SearchContent) {
+					product.GenerateSearchContent()
+				} else {
+					product.SearchContent = NormalizeSearchContent(product.SearchContent)
+					product.SearchKeywords = NormalizeSearchKeywords(product.SearchKeywords)
+				}
+				if product.SearchContent == content && product.SearchKeywords == keywords {
+					continue
+				}
+
+				err := db.Model(&Product{}).Where("id = ?", product.ID).UpdateColumns(map[string]interface{}{
+					"search_content":  product.SearchContent,
+					"search_keywords": product.SearchKeywords,
+					"updated_at":      gorm.Expr("updated_at"),
+				}).Error
+				if err != nil {
+					return err
+				}
+				updated++
+				if product.Status == "active" {
+					active = append(active, product)
+				}
+			}
+			if len(active) == 0 {
+				return nil
+			}
+			return GetSearchEngine(db).Index(active...)
+		}).Error
+	return updated, err
+}
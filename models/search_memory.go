@@ -0,0 +1,412 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"gorm.io/gorm"
+
+	H "mercadillo-global/helpers"
+)
+
+// Parámetros de BM25
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// MemorySearchEngine índice invertido en memoria con puntaje BM25, para pruebas y despliegues pequeños
+type MemorySearchEngine struct {
+	mu          sync.RWMutex
+	docs        map[string]*memoryDoc
+	postings    map[string]map[string]int // término -> producto -> frecuencia
+	totalLength int
+}
+
+// memoryDoc producto indexado con sus categorías y términos
+type memoryDoc struct {
+	Product    Product
+	Categories []string
+	Warehouses []ProductWarehouse // Almacenes con sus costos de envío (filtro ShipTo)
+	Terms      map[string]int
+	Length     int
+}
+
+// memoryHit producto coincidente con su puntaje
+type memoryHit struct {
+	doc   *memoryDoc
+	score float64
+}
+
+// NewMemorySearchEngine crea un motor en memoria vacío
+func NewMemorySearchEngine() *MemorySearchEngine {
+	return &MemorySearchEngine{
+		docs:     make(map[string]*memoryDoc),
+		postings: make(map[string]map[string]int),
+	}
+}
+
+// IndexFromDB indexa todos los productos activos con sus categorías y cobertura de envío, por lotes
+func (e *MemorySearchEngine) IndexFromDB(db *gorm.DB) error {
+	var batch []Product
+	return db.Preload("ProductCategories").
+		Preload("Warehouses.Warehouse").
+		Preload("Warehouses.ShippingCosts").
+		Where("status = ?", "active").
+		FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+			return e.Index(batch...)
+		}).Error
+}
+
+// Index agrega o reemplaza productos. Se indexan search_content y search_keywords (o título y descripción si faltan)
+func (e *MemorySearchEngine) Index(products ...Product) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, product := range products {
+		e.remove(product.ID)
+
+		text := product.SearchContent + " " + product.SearchKeywords
+		if H.IsEmpty(product.SearchContent) {
+			text = product.Title + " " + product.Description + " " + product.SearchKeywords
+		}
+
+		doc := &memoryDoc{Product: product, Terms: make(map[string]int)}
+		for _, term := range searchWords(text) {
+			doc.Terms[term]++
+			doc.Length++
+		}
+		for _, pc := range product.ProductCategories {
+			doc.Categories = append(doc.Categories, pc.CategoryID)
+		}
+		for _, category := range product.Categories {
+			doc.Categories = appendUnique(doc.Categories, category.ID)
+		}
+		doc.Warehouses = product.Warehouses
+		// Las relaciones no se guardan en el índice
+		doc.Product.ProductCategories = nil
+		doc.Product.Categories = nil
+		doc.Product.Warehouses = nil
+
+		for term, frequency := range doc.Terms {
+			if e.postings[term] == nil {
+				e.postings[term] = make(map[string]int)
+			}
+			e.postings[term][product.ID] = frequency
+		}
+		e.docs[product.ID] = doc
+		e.totalLength += doc.Length
+	}
+	return nil
+}
+
+// Delete quita productos del índice
+func (e *MemorySearchEngine) Delete(productIDs ...string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, id := range productIDs {
+		e.remove(id)
+	}
+	return nil
+}
+
+// remove quita un producto del índice (requiere el lock de escritura)
+func (e *MemorySearchEngine) remove(productID string) {
+	doc, ok := e.docs[productID]
+	if !ok {
+		return
+	}
+	for term := range doc.Terms {
+		delete(e.postings[term], productID)
+		if len(e.postings[term]) == 0 {
+			delete(e.postings, term)
+		}
+	}
+	e.totalLength -= doc.Length
+	delete(e.docs, productID)
+}
+
+// Query busca con BM25 sobre los términos normalizados (con sinónimos), filtra, calcula facetas y pagina
+func (e *MemorySearchEngine) Query(query string, filters SearchFilters) (*SearchResult, error) {
+	e.mu.RLock()
+	hits := e.score(NormalizeSearchTerms(query, true))
+	e.mu.RUnlock()
+
+	matched := make([]memoryHit, 0, len(hits))
+	for _, hit := range hits {
+		if memoryMatchesFilters(hit.doc, filters) {
+			matched = append(matched, hit)
+		}
+	}
+	sortMemoryHits(matched, filters.SortBy)
+
+	result := &SearchResult{
+		Products: []Product{},
+		Total:    int64(len(matched)),
+		PerPage:  filters.Limit,
+	}
+
+	if filters.UseCursor {
+		// Keyset en memoria: continuar después de la última fila del cursor (siempre por relevancia)
+		sortMemoryHits(matched, "")
+		cursorData, err := H.DecryptCursor(filters.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		start := 0
+		if cursorData.ID != "" {
+			if cursorData.FilterHash != searchCursorHash(query, filters) || cursorData.Relevance == nil ||
+				cursorData.Rating == nil || cursorData.Sold == nil {
+				return nil, H.ErrCursorFilterMismatch
+			}
+			start = sort.Search(len(matched), func(i int) bool {
+				return memoryHitAfter(matched[i], *cursorData.Relevance, *cursorData.Rating, *cursorData.Sold, cursorData.ID)
+			})
+		}
+
+		end := min(start+filters.Limit, len(matched))
+		result.Products = memoryHitProducts(matched[start:end])
+		result.HasMore = end < len(matched)
+		if result.HasMore && end > start {
+			last := result.Products[len(result.Products)-1]
+			result.NextCursor, err = H.EncryptCursor(H.CursorData{
+				ID:         last.ID,
+				Relevance:  &last.Relevance,
+				Rating:     &last.Rating,
+				Sold:       &last.Sold,
+				FilterHash: searchCursorHash(query, filters),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		start := min(filters.Offset, len(matched))
+		end := min(start+filters.Limit, len(matched))
+		result.Products = memoryHitProducts(matched[start:end])
+		result.Page = (filters.Offset / filters.Limit) + 1
+		result.TotalPages = (len(matched) + filters.Limit - 1) / filters.Limit
+	}
+
+	if filters.WithFacets && len(hits) > 0 {
+		result.Facets = memoryFacets(hits, filters)
+	}
+
+	return result, nil
+}
+
+// score calcula BM25 de cada producto que contiene algún término (requiere el lock de lectura)
+func (e *MemorySearchEngine) score(terms []string) []memoryHit {
+	if len(e.docs) == 0 || len(terms) == 0 {
+		return nil
+	}
+
+	total := float64(len(e.docs))
+	averageLength := float64(e.totalLength) / total
+	scores := make(map[string]float64)
+	for _, term := range terms {
+		postings := e.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (total-df+0.5)/(df+0.5))
+		for id, frequency := range postings {
+			tf := float64(frequency)
+			norm := 1 - bm25B + bm25B*float64(e.docs[id].Length)/averageLength
+			scores[id] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+
+	hits := make([]memoryHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, memoryHit{doc: e.docs[id], score: score})
+	}
+	return hits
+}
+
+// memoryMatchesFilters aplica en memoria los mismos filtros que applySearchFilters
+func memoryMatchesFilters(doc *memoryDoc, filters SearchFilters) bool {
+	product := doc.Product
+	if product.Status != "active" {
+		return false
+	}
+
+	if len(filters.Categories) > 0 && !memoryInCategories(doc, ExpandCategoryIDs(filters.Categories)) {
+		return false
+	}
+
+	if filters.MinPrice != nil && product.Price < *filters.MinPrice {
+		return false
+	}
+	if filters.MaxPrice != nil && product.Price > *filters.MaxPrice {
+		return false
+	}
+	if filters.MinRating != nil && product.Rating < *filters.MinRating {
+		return false
+	}
+	if filters.FreeShipping != nil && product.FreeShipping != *filters.FreeShipping {
+		return false
+	}
+	if filters.ShipTo != nil && filters.ShipTo.Country != "" && !product.IsService &&
+		!warehousesShipTo(doc.Warehouses, *filters.ShipTo) {
+		return false
+	}
+	if filters.Reviews != nil && !matchesQuantity(product.ReviewCount, *filters.Reviews) {
+		return false
+	}
+	if filters.Sales != nil && !matchesQuantity(product.Sold, *filters.Sales) {
+		return false
+	}
+	if filters.IsService != nil && product.IsService != *filters.IsService {
+		return false
+	}
+	return true
+}
+
+// matchesQuantity 0 = ninguna, el resto es un mínimo
+func matchesQuantity(value, threshold int) bool {
+	if threshold == 0 {
+		return value == 0
+	}
+	return value >= threshold
+}
+
+// sortMemoryHits ordena igual que searchOrderBy; el desempate final por id hace el orden estable para el keyset
+func sortMemoryHits(hits []memoryHit, sortBy string) {
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, b := hits[i].doc.Product, hits[j].doc.Product
+		switch sortBy {
+		case "price_asc":
+			if a.Price != b.Price {
+				return a.Price < b.Price
+			}
+		case "price_desc":
+			if a.Price != b.Price {
+				return a.Price > b.Price
+			}
+		case "rating":
+			if a.Rating != b.Rating {
+				return a.Rating > b.Rating
+			}
+		case "sales":
+			if a.Sold != b.Sold {
+				return a.Sold > b.Sold
+			}
+		case "trending":
+			if a.TrendingScore != b.TrendingScore {
+				return a.TrendingScore > b.TrendingScore
+			}
+		case "newest":
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.After(b.CreatedAt)
+			}
+		}
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		if a.Rating != b.Rating {
+			return a.Rating > b.Rating
+		}
+		if a.Sold != b.Sold {
+			return a.Sold > b.Sold
+		}
+		return a.ID < b.ID
+	})
+}
+
+// memoryHitAfter indica si el resultado va después de la fila del cursor en orden (relevance, rating, sold DESC, id ASC)
+func memoryHitAfter(hit memoryHit, relevance, rating float64, sold int, id string) bool {
+	product := hit.doc.Product
+	if hit.score != relevance {
+		return hit.score < relevance
+	}
+	if product.Rating != rating {
+		return product.Rating < rating
+	}
+	if product.Sold != sold {
+		return product.Sold < sold
+	}
+	return product.ID > id
+}
+
+// memoryHitProducts copia los productos con su relevancia
+func memoryHitProducts(hits []memoryHit) []Product {
+	products := make([]Product, len(hits))
+	for i, hit := range hits {
+		products[i] = hit.doc.Product
+		products[i].Relevance = hit.score
+	}
+	return products
+}
+
+// memoryFacets cuenta las facetas sobre los productos coincidentes; cada faceta excluye su propio filtro
+func memoryFacets(hits []memoryHit, filters SearchFilters) FacetCounts {
+	facets := make(FacetCounts)
+	count := func(exclude string, value func(product Product) string) map[string]int64 {
+		counts := make(map[string]int64)
+		scoped := withoutSearchFilter(filters, exclude)
+		for _, hit := range hits {
+			if memoryMatchesFilters(hit.doc, scoped) {
+				counts[value(hit.doc.Product)]++
+			}
+		}
+		return counts
+	}
+
+	facets["price"] = count("price", func(product Product) string {
+		for _, bucket := range PriceBuckets {
+			if priceInBucket(product.Price, bucket) {
+				return bucket
+			}
+		}
+		return ""
+	})
+	facets["rating"] = cumulativeCounts(count("rating", func(product Product) string {
+		return strconv.Itoa(int(math.Floor(product.Rating)))
+	}), ratingThresholds)
+	facets["reviews"] = cumulativeCounts(count("reviews", func(product Product) string {
+		return strconv.Itoa(min(product.ReviewCount, 3))
+	}), quantityThresholds)
+	facets["sales"] = cumulativeCounts(count("sales", func(product Product) string {
+		return strconv.Itoa(min(product.Sold, 3))
+	}), quantityThresholds)
+	facets["shipping"] = count("shipping", func(product Product) string {
+		if product.FreeShipping {
+			return "free"
+		}
+		return "nonfree"
+	})
+
+	// Subcategorías: cada producto se cuenta una vez por subárbol
+	if categoryIDs := searchFacetCategoryIDs(filters); len(categoryIDs) > 0 {
+		categoryCounts := make(map[string]int64)
+		for _, hit := range hits {
+			if !memoryMatchesFilters(hit.doc, filters) {
+				continue
+			}
+			for _, id := range categoryIDs {
+				if memoryInCategories(hit.doc, GetCategoryDescendantIDs(id)) {
+					categoryCounts[id]++
+				}
+			}
+		}
+		facets["category"] = categoryCounts
+	}
+
+	return facets
+}
+
+// memoryInCategories indica si el producto pertenece a alguna de las categorías
+func memoryInCategories(doc *memoryDoc, categoryIDs []string) bool {
+	for _, id := range categoryIDs {
+		for _, category := range doc.Categories {
+			if category == id {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	H "mercadillo-global/helpers"
+)
+
+// memoryTestEngine motor en memoria con productos activos
+func memoryTestEngine(t *testing.T, products ...Product) *MemorySearchEngine {
+	t.Helper()
+	engine := NewMemorySearchEngine()
+	for i := range products {
+		products[i].Status = "active"
+	}
+	if err := engine.Index(products...); err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+// resultIDs ids de los productos del resultado en orden
+func resultIDs(result *SearchResult) []string {
+	ids := make([]string, 0, len(result.Products))
+	for _, product := range result.Products {
+		ids = append(ids, product.ID)
+	}
+	return ids
+}
+
+func sameIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemorySearchBM25Ranking(t *testing.T) {
+	engine := memoryTestEngine(t,
+		Product{ID: "long", Title: "Zapatilla", Description: "de cuero marrón con suela de goma, cordones largos y plantilla acolchada"},
+		Product{ID: "short", Title: "Zapatillas running", Description: "zapatillas livianas"},
+		Product{ID: "other", Title: "Camiseta deportiva", Description: "algodón"},
+	)
+
+	result, err := engine.Query("zapatillas", SearchFilters{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultIDs(result); !sameIDs(got, []string{"short", "long"}) {
+		t.Fatalf("ranking = %v, want [short long]", got)
+	}
+	if result.Total != 2 {
+		t.Errorf("total = %d, want 2", result.Total)
+	}
+	if result.Products[0].Relevance <= result.Products[1].Relevance {
+		t.Errorf("relevance %v should be above %v", result.Products[0].Relevance, result.Products[1].Relevance)
+	}
+
+	// Reindexar reemplaza el documento y Delete lo quita
+	if err := engine.Index(Product{ID: "short", Status: "active", Title: "Camiseta"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Delete("long"); err != nil {
+		t.Fatal(err)
+	}
+	result, err = engine.Query("zapatillas", SearchFilters{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Products) != 0 {
+		t.Errorf("results after reindex and delete = %v, want none", resultIDs(result))
+	}
+}
+
+func TestMemorySearchFilters(t *testing.T) {
+	colombia := []ProductWarehouse{{Warehouse: Warehouse{Country: "CO", State: "Antioquia", City: "Medellín", IsActive: true}}}
+	engine := memoryTestEngine(t,
+		Product{ID: "cheap", Title: "Lámpara", Price: 9, Rating: 4.5, FreeShipping: true, Warehouses: colombia,
+			ProductCategories: []ProductCategory{{CategoryID: "hogar"}}},
+		Product{ID: "mid", Title: "Lámpara", Price: 10, Rating: 3.2, Sold: 5, Warehouses: colombia,
+			ProductCategories: []ProductCategory{{CategoryID: "oficina"}}},
+		Product{ID: "abroad", Title: "Lámpara", Price: 49, Rating: 4.8},
+		Product{ID: "service", Title: "Lámpara instalación", Price: 50, IsService: true},
+	)
+
+	tenToFiftyMin, tenToFiftyMax, _ := ParsePriceBucket("10-50")
+	minRating := 4.0
+	freeShipping := true
+	isService := true
+	noSales := 0
+	tests := []struct {
+		name    string
+		filters SearchFilters
+		want    []string
+	}{
+		{"price bucket is half-open", SearchFilters{MinPrice: tenToFiftyMin, MaxPrice: tenToFiftyMax}, []string{"abroad", "mid"}},
+		{"rating", SearchFilters{MinRating: &minRating}, []string{"abroad", "cheap"}},
+		{"free shipping", SearchFilters{FreeShipping: &freeShipping}, []string{"cheap"}},
+		{"service", SearchFilters{IsService: &isService}, []string{"service"}},
+		{"no sales", SearchFilters{Sales: &noSales}, []string{"abroad", "cheap", "service"}},
+		{"category", SearchFilters{Categories: []string{"oficina"}}, []string{"mid"}},
+		{"ship to keeps services", SearchFilters{ShipTo: &ShipTo{Country: "co", City: "Medellin"}}, []string{"cheap", "mid", "service"}},
+		{"ship to elsewhere", SearchFilters{ShipTo: &ShipTo{Country: "MX"}}, []string{"service"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filters.Limit = 10
+			result, err := engine.Query("lampara", tt.filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := resultIDs(result)
+			if !sameIDSet(got, tt.want) {
+				t.Errorf("results = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func sameIDSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int)
+	for _, id := range a {
+		seen[id]++
+	}
+	for _, id := range b {
+		seen[id]--
+	}
+	for _, count := range seen {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemorySearchFacets(t *testing.T) {
+	engine := memoryTestEngine(t,
+		Product{ID: "a", Title: "Mochila", Price: 9, Rating: 4.2, ReviewCount: 5, FreeShipping: true},
+		Product{ID: "b", Title: "Mochila", Price: 10, Rating: 3.9, ReviewCount: 1},
+		Product{ID: "c", Title: "Mochila", Price: 50, Rating: 2.5},
+		Product{ID: "d", Title: "Mochila", Price: 500, Rating: 4.9, Sold: 2},
+	)
+
+	minRating := 4.0
+	result, err := engine.Query("mochila", SearchFilters{Limit: 10, WithFacets: true, MinRating: &minRating})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultIDs(result); !sameIDSet(got, []string{"a", "d"}) {
+		t.Fatalf("results = %v, want [a d]", got)
+	}
+
+	// Cada faceta cuenta con los demás filtros activos: el precio solo ve a y d, el rating ve a todos
+	price := result.Facets["price"]
+	if price["0-10"] != 1 || price["10-50"] != 0 || price["500-"] != 1 {
+		t.Errorf("price facet = %v", price)
+	}
+	rating := result.Facets["rating"]
+	if rating["4"] != 2 || rating["3"] != 3 || rating["2"] != 4 {
+		t.Errorf("rating facet = %v", rating)
+	}
+	reviews := result.Facets["reviews"]
+	if reviews["3"] != 1 || reviews["1"] != 1 || reviews["0"] != 1 {
+		t.Errorf("reviews facet = %v", reviews)
+	}
+	if shipping := result.Facets["shipping"]; shipping["free"] != 1 || shipping["nonfree"] != 1 {
+		t.Errorf("shipping facet = %v", shipping)
+	}
+}
+
+func TestMemorySearchCursorPaging(t *testing.T) {
+	t.Setenv("CURSOR_ENCRYPTION_KEYS", "")
+	t.Setenv("CURSOR_ENCRYPTION_KEY", "0123456789abcdef0123456789abcdef")
+
+	products := make([]Product, 0)
+	for i, id := range []string{"p1", "p2", "p3", "p4", "p5", "p6", "p7"} {
+		// Mismo texto y rating: el orden lo deciden las ventas y el id
+		products = append(products, Product{ID: id, Title: "Bicicleta urbana", Rating: 4, Sold: i % 2})
+	}
+	products = append(products, Product{ID: "p0", Title: "Bicicleta bicicleta urbana", Rating: 4})
+	engine := memoryTestEngine(t, products...)
+
+	all, err := engine.Query("bicicleta", SearchFilters{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filters := SearchFilters{Limit: 3, UseCursor: true}
+	paged := make([]string, 0)
+	for pages := 0; ; pages++ {
+		if pages > len(products) {
+			t.Fatal("cursor paging does not end")
+		}
+		result, err := engine.Query("bicicleta", filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paged = append(paged, resultIDs(result)...)
+		if !result.HasMore {
+			break
+		}
+		if result.NextCursor == "" {
+			t.Fatal("has_more without next_cursor")
+		}
+		filters.Cursor = result.NextCursor
+	}
+	if want := resultIDs(all); !sameIDs(paged, want) {
+		t.Errorf("paged = %v, want %v", paged, want)
+	}
+
+	// El cursor queda atado a la consulta y los filtros
+	first, err := engine.Query("bicicleta", SearchFilters{Limit: 3, UseCursor: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = engine.Query("urbana", SearchFilters{Limit: 3, UseCursor: true, Cursor: first.NextCursor})
+	if !errors.Is(err, H.ErrCursorFilterMismatch) {
+		t.Errorf("cursor reused with another query: err = %v, want ErrCursorFilterMismatch", err)
+	}
+}
+
+func TestSearchCursorWithSortUsesPages(t *testing.T) {
+	t.Setenv("CURSOR_ENCRYPTION_KEYS", "")
+	t.Setenv("CURSOR_ENCRYPTION_KEY", "0123456789abcdef0123456789abcdef")
+	SetSearchEngine(memoryTestEngine(t,
+		Product{ID: "p1", Title: "Bicicleta urbana", Price: 300},
+		Product{ID: "p2", Title: "Bicicleta bicicleta", Price: 100},
+		Product{ID: "p3", Title: "Bicicleta de montaña", Price: 200},
+	))
+	t.Cleanup(func() { SetSearchEngine(nil) })
+
+	// El keyset solo sigue la relevancia: con SortBy se ignora el cursor y se pagina por número de página
+	result, err := SearchProducts(nil, "bicicleta", SearchFilters{Limit: 2, SortBy: "price_asc", UseCursor: true, Cursor: "otro"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultIDs(result); !sameIDs(got, []string{"p2", "p3"}) {
+		t.Errorf("ids = %v, want [p2 p3]", got)
+	}
+	if result.Page != 1 || result.TotalPages != 2 || result.NextCursor != "" {
+		t.Errorf("page = %d, total pages = %d, next cursor = %q", result.Page, result.TotalPages, result.NextCursor)
+	}
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	H "mercadillo-global/helpers"
+)
+
+// SearchQuery registro de una búsqueda en /search con su resultado y el producto en el que se hizo clic
+type SearchQuery struct {
+	ID               string     `json:"id" gorm:"type:char(36);primaryKey"`
+	SessionID        string     `json:"session_id" gorm:"type:varchar(64);index"`
+	Query            string     `json:"query" gorm:"type:varchar(255)"`
+	NormalizedQuery  string     `json:"normalized_query" gorm:"type:varchar(255);index"`
+	Filters          string     `json:"filters" gorm:"type:json"`
+	ResultCount      int64      `json:"result_count"`
+	ClickedProductID *string    `json:"clicked_product_id" gorm:"type:char(36)"`
+	ClickedAt        *time.Time `json:"clicked_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// SearchQueryStats métricas agregadas de una consulta normalizada
+type SearchQueryStats struct {
+	Query      string  `json:"query"`
+	Searches   int64   `json:"searches"`
+	Clicks     int64   `json:"clicks"`
+	CTR        float64 `json:"ctr"` // clics / búsquedas
+	AvgResults float64 `json:"avg_results"`
+}
+
+// SearchReport reporte de búsquedas para ajustar search_keywords y sinónimos
+type SearchReport struct {
+	Since             time.Time          `json:"since"`
+	TopQueries        []SearchQueryStats `json:"top_queries"`
+	ZeroResultQueries []SearchQueryStats `json:"zero_result_queries"`
+}
+
+func (sq *SearchQuery) BeforeCreate(tx *gorm.DB) error {
+	if H.IsEmpty(sq.ID) {
+		sq.ID = H.NewUUID()
+	}
+	return nil
+}
+
+// NewSearchQuery arma el registro de una búsqueda con la consulta normalizada y los filtros en JSON
+func NewSearchQuery(sessionID, query string, filters SearchFilters, resultCount int64) *SearchQuery {
+	return &SearchQuery{
+		ID:              H.NewUUID(),
+		SessionID:       sessionID,
+		Query:           truncateRunes(query, 255),
+		NormalizedQuery: truncateRunes(normalizeSuggest(query), 255),
+		Filters:         H.JSONEncode(filters),
+		ResultCount:     resultCount,
+	}
+}
+
+// RecordSearchClick marca el primer producto abierto desde los resultados de una búsqueda de la misma sesión
+func RecordSearchClick(db *gorm.DB, searchQueryID, sessionID, productID string) error {
+	return db.Model(&SearchQuery{}).
+		Where("id = ? AND session_id = ? AND clicked_product_id IS NULL", searchQueryID, sessionID).
+		Updates(map[string]interface{}{
+			"clicked_product_id": productID,
+			"clicked_at":         time.Now(),
+		}).Error
+}
+
+// GetSearchReport consultas más buscadas, más buscadas sin resultados y CTR de cada una desde la fecha indicada
+func GetSearchReport(db *gorm.DB, since time.Time, limit int) (*SearchReport, error) {
+	report := &SearchReport{Since: since}
+
+	var err error
+	if report.TopQueries, err = searchQueryStats(db.Where("created_at >= ?", since), limit); err != nil {
+		return nil, err
+	}
+	if report.ZeroResultQueries, err = searchQueryStats(db.Where("created_at >= ? AND result_count = 0", since), limit); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// searchQueryStats agrupa search_queries por consulta normalizada
+func searchQueryStats(query *gorm.DB, limit int) ([]SearchQueryStats, error) {
+	stats := make([]SearchQueryStats, 0)
+	err := query.Model(&SearchQuery{}).
+		Select("normalized_query AS query, COUNT(*) AS searches, COUNT(clicked_product_id) AS clicks, AVG(result_count) AS avg_results").
+		Where("normalized_query <> ''").
+		Group("normalized_query").
+		Order("searches DESC").
+		Limit(limit).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range stats {
+		if stats[i].Searches > 0 {
+			stats[i].CTR = H.Round(float64(stats[i].Clicks)/float64(stats[i].Searches), 4)
+		}
+	}
+	return stats, nil
+}
+
+// truncateRunes recorta un texto a n caracteres sin cortar runas
+func truncateRunes(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n])
+}
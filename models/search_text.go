@@ -0,0 +1,161 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	H "mercadillo-global/helpers"
+)
+
+// spanishStopwords palabras vacías que no aportan a la búsqueda
+var spanishStopwords = map[string]bool{
+	"a": true, "al": true, "ante": true, "con": true, "contra": true, "de": true, "del": true, "desde": true,
+	"el": true, "en": true, "entre": true, "es": true, "hacia": true, "hasta": true, "la": true, "las": true,
+	"lo": true, "los": true, "mas": true, "mi": true, "mis": true, "o": true, "para": true, "pero": true,
+	"por": true, "que": true, "se": true, "sin": true, "sobre": true, "su": true, "sus": true, "tu": true,
+	"tus": true, "u": true, "un": true, "una": true, "unas": true, "unos": true, "y": true, "e": true,
+}
+
+// Diccionario de sinónimos cargado desde synonyms.json: raíz -> raíces equivalentes (incluida ella misma)
+var (
+	synonymsMap    map[string][]string
+	synonymsLoaded bool
+)
+
+// InitializeSynonyms carga los grupos de sinónimos una vez al iniciar (SEARCH_SYNONYMS_FILE o synonyms.json)
+func InitializeSynonyms() error {
+	if synonymsLoaded {
+		return nil
+	}
+
+	path := os.Getenv("SEARCH_SYNONYMS_FILE")
+	if H.IsEmpty(path) {
+		path = "synonyms.json"
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var groups [][]string
+	if err := json.NewDecoder(file).Decode(&groups); err != nil {
+		return fmt.Errorf("error decoding %s: %v", path, err)
+	}
+
+	synonymsMap = make(map[string][]string)
+	for _, group := range groups {
+		stems := make([]string, 0, len(group))
+		for _, word := range group {
+			stems = append(stems, NormalizeSearchTerms(word, false)...)
+		}
+		for _, stem := range stems {
+			synonymsMap[stem] = appendUnique(synonymsMap[stem], stems...)
+		}
+	}
+
+	synonymsLoaded = true
+	return nil
+}
+
+// StemSpanish raíz ligera de una palabra ya en minúsculas y sin acentos: quita plurales y la vocal final de género
+// Ej. "neumaticos" y "neumatico" -> "neumatic", "luces" -> "luz"
+func StemSpanish(word string) string {
+	if len(word) <= 3 || strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+		return word
+	}
+
+	switch {
+	case strings.HasSuffix(word, "ces") && len(word) > 4:
+		word = word[:len(word)-3] + "z"
+	case strings.HasSuffix(word, "es") && len(word) > 4 && !isSpanishVowel(word[len(word)-3]):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "s"):
+		word = word[:len(word)-1]
+	}
+
+	if len(word) > 4 && isSpanishVowel(word[len(word)-1]) {
+		word = word[:len(word)-1]
+	}
+	return word
+}
+
+func isSpanishVowel(c byte) bool {
+	return c == 'a' || c == 'e' || c == 'i' || c == 'o' || c == 'u'
+}
+
+// searchWords pasa un texto por el pipeline de búsqueda: minúsculas, sin acentos ni signos,
+// sin palabras vacías y con raíz ligera (conserva repeticiones)
+func searchWords(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(H.RemoveAccents(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	stems := make([]string, 0, len(words))
+	for _, word := range words {
+		if !spanishStopwords[word] {
+			stems = append(stems, StemSpanish(word))
+		}
+	}
+	return stems
+}
+
+// NormalizeSearchTerms términos únicos normalizados de un texto; con expand agrega los sinónimos de cada término
+func NormalizeSearchTerms(text string, expand bool) []string {
+	terms := make([]string, 0)
+	for _, stem := range searchWords(text) {
+		terms = appendUnique(terms, stem)
+		if expand {
+			terms = appendUnique(terms, synonymsMap[stem]...)
+		}
+	}
+	return terms
+}
+
+// NormalizeSearchQuery cadena para MATCH ... AGAINST sobre search_content (normalizado igual al indexar)
+// Si todas las palabras son vacías se conserva la consulta original
+func NormalizeSearchQuery(query string) string {
+	terms := NormalizeSearchTerms(query, true)
+	if len(terms) == 0 {
+		return query
+	}
+	return strings.Join(terms, " ")
+}
+
+// NormalizeSearchContent normaliza el texto indexado en search_content (sin expandir sinónimos)
+func NormalizeSearchContent(text string) string {
+	return strings.Join(searchWords(text), " ")
+}
+
+// NormalizeSearchKeywords normaliza cada palabra clave de search_keywords como search_content, conservando la
+// separación por comas y sin repetidas. Respeta el largo de la columna (VARCHAR(500))
+func NormalizeSearchKeywords(keywords string) string {
+	normalized := make([]string, 0)
+	for _, keyword := range strings.Split(keywords, ",") {
+		if keyword = NormalizeSearchContent(keyword); keyword != "" {
+			normalized = appendUnique(normalized, keyword)
+		}
+	}
+	return truncateRunes(strings.Join(normalized, ", "), 500)
+}
+
+// appendUnique agrega valores que no estén ya en la lista
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
@@ -0,0 +1,37 @@
+package models
+
+import "testing"
+
+func TestStemSpanish(t *testing.T) {
+	tests := []struct {
+		word, want string
+	}{
+		{"neumaticos", "neumatic"},
+		{"neumatico", "neumatic"},
+		{"luces", "luz"},
+		{"luz", "luz"},
+		{"camiones", "camion"},
+		{"camion", "camion"},
+		{"zapatillas", "zapatill"},
+		{"zapatilla", "zapatill"},
+		{"mesa", "mesa"},         // Cuatro letras: conserva la vocal final
+		{"mesas", "mesa"},        // El plural sí se quita
+		{"sol", "sol"},           // Tres letras o menos: sin cambios
+		{"iphone15", "iphone15"}, // Con dígitos: sin cambios
+		{"clases", "clas"},
+	}
+	for _, tt := range tests {
+		if got := StemSpanish(tt.word); got != tt.want {
+			t.Errorf("StemSpanish(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeSearchText(t *testing.T) {
+	if got := NormalizeSearchContent("Las Zapatillas de RUNNING, para niños!"); got != "zapatill running nino" {
+		t.Errorf("NormalizeSearchContent = %q", got)
+	}
+	if got := NormalizeSearchKeywords("Zapatillas, zapatilla ,  , Luces LED"); got != "zapatill, luz led" {
+		t.Errorf("NormalizeSearchKeywords = %q", got)
+	}
+}
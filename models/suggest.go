@@ -0,0 +1,403 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+
+	H "mercadillo-global/helpers"
+)
+
+// Configuración del índice de autocompletado
+var (
+	SuggestRefreshEvery   = 10 * time.Minute
+	suggestMaxProducts    = 5000 // Productos más vendidos que aportan títulos y palabras clave
+	suggestTopPrefixRunes = 3    // Prefijos de hasta este largo tienen sus sugerencias precalculadas al publicar el índice
+	suggestTopSize        = 20   // Sugerencias precalculadas por prefijo corto (el máximo de limit)
+	suggestMaxQueryLength = 100
+	suggestMaxQueries     = 2000                // Consultas pasadas (search_queries) en el índice
+	suggestQueriesWindow  = 30 * 24 * time.Hour // Antigüedad máxima de las consultas pasadas
+	spellingMinWordLength = 3                   // Palabras más cortas no se corrigen ni forman parte del vocabulario
+)
+
+// Suggestion sugerencia de autocompletado
+type Suggestion struct {
+	Text  string  `json:"text"`
+	Type  string  `json:"type"` // category, product, keyword, query
+	URL   string  `json:"url"`
+	Score float64 `json:"score"`
+}
+
+// suggestEntry texto sugerible con su popularidad
+type suggestEntry struct {
+	Suggestion
+	Popularity float64
+}
+
+// suggestKey clave normalizada del índice; whole indica que la clave es el texto completo (no desde una palabra interna)
+type suggestKey struct {
+	Key   string
+	Entry int
+	Whole bool
+}
+
+// suggestIndex índice de prefijos ordenado, se reemplaza completo en cada refresco
+// top tiene las mejores sugerencias de cada prefijo corto, que coinciden con demasiadas claves para recorrerlas
+// en cada consulta. vocabulary reúne las palabras normalizadas de títulos, palabras clave y categorías con su
+// frecuencia (corrector)
+type suggestIndex struct {
+	entries    []suggestEntry
+	keys       []suggestKey
+	top        map[string][]Suggestion
+	vocabulary map[string]float64
+}
+
+var (
+	suggestMu      sync.RWMutex
+	currentSuggest = &suggestIndex{}
+
+	// Consultas realizadas desde el último arranque (texto normalizado -> veces con resultados)
+	pastQueriesMu sync.Mutex
+	pastQueries   = make(map[string]*suggestEntry)
+)
+
+// normalizeSuggest normaliza un texto para comparar prefijos: minúsculas, sin acentos ni signos y espacios simples
+func normalizeSuggest(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(H.RemoveAccents(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// RecordSearchQuery registra una búsqueda con resultados para sugerirla en el próximo refresco
+func RecordSearchQuery(query string, total int64) {
+	key := normalizeSuggest(query)
+	if key == "" || total == 0 || len(key) > suggestMaxQueryLength {
+		return
+	}
+
+	pastQueriesMu.Lock()
+	defer pastQueriesMu.Unlock()
+	if entry, ok := pastQueries[key]; ok {
+		entry.Popularity++
+		return
+	}
+	pastQueries[key] = &suggestEntry{
+		Suggestion: Suggestion{Text: H.Trim(query), Type: "query", URL: "/search?q=" + url.QueryEscape(H.Trim(query))},
+		Popularity: 1,
+	}
+}
+
+// GetSuggestions devuelve hasta limit sugerencias para el prefijo; coincidencias al inicio del texto pesan el doble
+func GetSuggestions(prefix string, limit int) []Suggestion {
+	prefix = normalizeSuggest(prefix)
+	result := make([]Suggestion, 0, limit)
+	if prefix == "" || limit <= 0 {
+		return result
+	}
+
+	suggestMu.RLock()
+	index := currentSuggest
+	suggestMu.RUnlock()
+
+	if len([]rune(prefix)) <= suggestTopPrefixRunes {
+		top := index.top[prefix]
+		return append(result, top[:min(limit, len(top))]...)
+	}
+
+	// Prefijos largos: se puntúan todas las claves que empiezan con él
+	scores := make(map[int]float64)
+	start := sort.Search(len(index.keys), func(i int) bool { return index.keys[i].Key >= prefix })
+	for i := start; i < len(index.keys) && strings.HasPrefix(index.keys[i].Key, prefix); i++ {
+		index.addKeyScore(scores, index.keys[i])
+	}
+	return index.rankSuggestions(scores, limit)
+}
+
+// addKeyScore suma la clave a los puntajes por entrada: popularidad, el doble si es el texto completo, y de todas
+// las claves de una entrada vale la mejor
+func (index *suggestIndex) addKeyScore(scores map[int]float64, key suggestKey) {
+	score := index.entries[key.Entry].Popularity + 1
+	if key.Whole {
+		score *= 2
+	}
+	if score > scores[key.Entry] {
+		scores[key.Entry] = score
+	}
+}
+
+// rankSuggestions las limit entradas de mayor puntaje (en empate por texto)
+func (index *suggestIndex) rankSuggestions(scores map[int]float64, limit int) []Suggestion {
+	result := make([]Suggestion, 0, len(scores))
+	for entry, score := range scores {
+		suggestion := index.entries[entry].Suggestion
+		suggestion.Score = score
+		result = append(result, suggestion)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Text < result[j].Text
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// RunSuggestIndexRefresher reconstruye el índice al iniciar y luego cada SuggestRefreshEvery
+func RunSuggestIndexRefresher(getDB func() *gorm.DB) {
+	for {
+		if err := RefreshSuggestIndex(getDB); err != nil {
+			log.Println("Error refreshing suggest index: ", err)
+		}
+		time.Sleep(SuggestRefreshEvery)
+	}
+}
+
+// RefreshSuggestIndex reconstruye el índice desde categorías, productos populares y consultas pasadas
+// Si falla la base de datos se publica igual el índice con categorías y las consultas acumuladas en memoria
+func RefreshSuggestIndex(getDB func() *gorm.DB) (err error) {
+	// Categorías: más generales primero
+	categoryEntries := make([]suggestEntry, 0)
+	for _, category := range GetFlatCategories() {
+		categoryEntries = append(categoryEntries, suggestEntry{
+			Suggestion: Suggestion{Text: category.Name, Type: "category", URL: "/category/" + category.ID},
+			Popularity: 10 / float64(category.Level+1),
+		})
+	}
+
+	entries := append([]suggestEntry{}, categoryEntries...)
+	pastQueriesMu.Lock()
+	for _, entry := range pastQueries {
+		entries = append(entries, *entry)
+	}
+	pastQueriesMu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("suggest index: %v", r)
+		}
+		publishSuggestIndex(entries)
+	}()
+
+	db := getDB()
+	productEntries, err := suggestProductEntries(db)
+	if err != nil {
+		return err
+	}
+	// Consultas con resultados guardadas en search_queries; reemplazan a las acumuladas en memoria
+	queryEntries, err := suggestQueryEntries(db)
+	if err != nil {
+		return err
+	}
+
+	entries = append(append(categoryEntries, productEntries...), queryEntries...)
+	pastQueriesMu.Lock()
+	pastQueries = make(map[string]*suggestEntry)
+	pastQueriesMu.Unlock()
+	return nil
+}
+
+// suggestQueryEntries consultas más frecuentes con resultados de los últimos días
+func suggestQueryEntries(db *gorm.DB) ([]suggestEntry, error) {
+	var rows []struct {
+		Query    string
+		Searches int64
+	}
+	err := db.Model(&SearchQuery{}).
+		Select("MAX(query) AS query, COUNT(*) AS searches").
+		Where("result_count > 0 AND created_at >= ? AND normalized_query <> ''", time.Now().Add(-suggestQueriesWindow)).
+		Group("normalized_query").
+		Order("searches DESC").
+		Limit(suggestMaxQueries).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]suggestEntry, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, suggestEntry{
+			Suggestion: Suggestion{Text: row.Query, Type: "query", URL: "/search?q=" + url.QueryEscape(row.Query)},
+			Popularity: float64(row.Searches),
+		})
+	}
+	return entries, nil
+}
+
+// suggestProductEntries títulos de los productos más vendidos y sus palabras clave (search_keywords) que aparecen
+// en el título, con las palabras del título porque las de search_keywords están normalizadas
+func suggestProductEntries(db *gorm.DB) ([]suggestEntry, error) {
+	var rows []struct {
+		ID             string
+		Title          string
+		SearchKeywords string
+		Sold           int
+	}
+	err := db.Model(&Product{}).
+		Select("id, title, search_keywords, sold").
+		Where("status = ?", "active").
+		Order("sold DESC").
+		Limit(suggestMaxProducts).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]suggestEntry, 0, len(rows))
+	keywords := make(map[string]*suggestEntry)
+	for _, row := range rows {
+		entries = append(entries, suggestEntry{
+			Suggestion: Suggestion{Text: row.Title, Type: "product", URL: "/product/" + row.ID},
+			Popularity: float64(row.Sold),
+		})
+
+		for _, keyword := range strings.Split(row.SearchKeywords, ",") {
+			keyword = readableKeyword(row.Title, keyword)
+			key := normalizeSuggest(keyword)
+			if key == "" {
+				continue
+			}
+			if entry, ok := keywords[key]; ok {
+				entry.Popularity += float64(row.Sold) + 1
+				continue
+			}
+			keywords[key] = &suggestEntry{
+				Suggestion: Suggestion{Text: keyword, Type: "keyword", URL: "/search?q=" + url.QueryEscape(keyword)},
+				Popularity: float64(row.Sold) + 1,
+			}
+		}
+	}
+	for _, entry := range keywords {
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// readableKeyword palabras del título de las que sale la palabra clave normalizada (ej. "zapat deportiv" en
+// "Zapatos deportivos Nike" -> "zapatos deportivos"). Vacío si el título no la contiene
+func readableKeyword(title, keyword string) string {
+	target := strings.Fields(NormalizeSearchContent(keyword))
+	if len(target) == 0 {
+		return ""
+	}
+
+	// Raíces del título con la palabra de la que sale cada una (las palabras vacías no dejan raíz)
+	type titleStem struct {
+		Stem string
+		Word int
+	}
+	words := strings.Fields(title)
+	stems := make([]titleStem, 0, len(words))
+	for i, word := range words {
+		for _, stem := range searchWords(word) {
+			stems = append(stems, titleStem{Stem: stem, Word: i})
+		}
+	}
+
+	for start := 0; start+len(target) <= len(stems); start++ {
+		found := true
+		for i, stem := range target {
+			if stems[start+i].Stem != stem {
+				found = false
+				break
+			}
+		}
+		if found {
+			first, last := stems[start].Word, stems[start+len(target)-1].Word
+			return strings.ToLower(strings.Trim(strings.Join(words[first:last+1], " "), ",.;:()[]\"'¡!¿?"))
+		}
+	}
+	return ""
+}
+
+// publishSuggestIndex arma las claves (texto completo y desde cada palabra), precalcula los prefijos cortos y
+// reemplaza el índice actual
+func publishSuggestIndex(entries []suggestEntry) {
+	index := &suggestIndex{entries: entries, top: make(map[string][]Suggestion), vocabulary: make(map[string]float64)}
+	for i, entry := range entries {
+		words := strings.Fields(normalizeSuggest(entry.Text))
+		for w := range words {
+			index.keys = append(index.keys, suggestKey{Key: strings.Join(words[w:], " "), Entry: i, Whole: w == 0})
+			if entry.Type != "query" && len(words[w]) >= spellingMinWordLength {
+				index.vocabulary[words[w]] += entry.Popularity + 1
+			}
+		}
+	}
+	sort.Slice(index.keys, func(i, j int) bool { return index.keys[i].Key < index.keys[j].Key })
+
+	// Prefijos cortos: puntaje de cada entrada entre todas sus claves y solo las suggestTopSize mejores
+	prefixScores := make(map[string]map[int]float64)
+	for _, key := range index.keys {
+		runes := []rune(key.Key)
+		for length := 1; length <= min(len(runes), suggestTopPrefixRunes); length++ {
+			prefix := string(runes[:length])
+			if prefixScores[prefix] == nil {
+				prefixScores[prefix] = make(map[int]float64)
+			}
+			index.addKeyScore(prefixScores[prefix], key)
+		}
+	}
+	for prefix, scores := range prefixScores {
+		index.top[prefix] = index.rankSuggestions(scores, suggestTopSize)
+	}
+
+	suggestMu.Lock()
+	currentSuggest = index
+	suggestMu.Unlock()
+}
+
+// SpellingSuggestion corrige cada palabra desconocida de la consulta con la palabra del vocabulario a menor distancia
+// de edición (1 para palabras cortas, 2 para el resto; en empate gana la más frecuente). Devuelve "" si no hay cambios
+func SpellingSuggestion(query string) string {
+	suggestMu.RLock()
+	vocabulary := currentSuggest.vocabulary
+	suggestMu.RUnlock()
+
+	words := strings.Fields(normalizeSuggest(query))
+	if len(words) == 0 || len(vocabulary) == 0 {
+		return ""
+	}
+
+	changed := false
+	for i, word := range words {
+		if len(word) < spellingMinWordLength || vocabulary[word] > 0 {
+			continue
+		}
+
+		maxDistance := 2
+		if len([]rune(word)) <= 4 {
+			maxDistance = 1
+		}
+
+		best, bestDistance, bestFrequency := "", maxDistance+1, 0.0
+		for candidate, frequency := range vocabulary {
+			lengthDiff := len([]rune(candidate)) - len([]rune(word))
+			if lengthDiff > maxDistance || -lengthDiff > maxDistance {
+				continue
+			}
+			distance := H.EditDistance(word, candidate)
+			if distance < bestDistance || (distance == bestDistance && frequency > bestFrequency) {
+				best, bestDistance, bestFrequency = candidate, distance, frequency
+			}
+		}
+		if best != "" {
+			words[i] = best
+			changed = true
+		}
+	}
+
+	if !changed {
+		return ""
+	}
+	return strings.Join(words, " ")
+}
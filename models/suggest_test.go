@@ -0,0 +1,41 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGetSuggestionsRanksAllMatches(t *testing.T) {
+	previous := currentSuggest
+	t.Cleanup(func() { currentSuggest = previous })
+
+	// Muchas claves que van antes en orden alfabético y una popular al final
+	entries := make([]suggestEntry, 0)
+	for i := 0; i < 3000; i++ {
+		entries = append(entries, suggestEntry{Suggestion: Suggestion{Text: fmt.Sprintf("aa%04d", i), Type: "query"}})
+	}
+	entries = append(entries,
+		suggestEntry{Suggestion: Suggestion{Text: "Azul marino", Type: "keyword"}, Popularity: 50},
+		suggestEntry{Suggestion: Suggestion{Text: "Camisa azul", Type: "product"}, Popularity: 40},
+	)
+	publishSuggestIndex(entries)
+
+	for _, prefix := range []string{"a", "az", "Azu", "azul", "azul m"} {
+		suggestions := GetSuggestions(prefix, 3)
+		if len(suggestions) == 0 || suggestions[0].Text != "Azul marino" {
+			t.Errorf("GetSuggestions(%q) = %v, want Azul marino first", prefix, suggestions)
+		}
+	}
+
+	// Desde una palabra interna pesa la mitad: "Camisa azul" (40+1) queda detrás de "Azul marino" (2 * 51)
+	suggestions := GetSuggestions("azu", 2)
+	if len(suggestions) != 2 || suggestions[1].Text != "Camisa azul" || suggestions[1].Score != 41 {
+		t.Errorf("GetSuggestions(azu) = %v", suggestions)
+	}
+	if suggestions := GetSuggestions("aa00", 5); len(suggestions) != 5 {
+		t.Errorf("GetSuggestions(aa00) returned %d suggestions, want 5", len(suggestions))
+	}
+	if suggestions := GetSuggestions("zz", 5); len(suggestions) != 0 {
+		t.Errorf("GetSuggestions(zz) = %v, want none", suggestions)
+	}
+}
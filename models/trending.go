@@ -0,0 +1,223 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Configuración del puntaje de tendencia y de los más vendidos
+var (
+	TrendingRefreshEvery   = time.Hour
+	ProductViewsFlushEvery = time.Minute
+	TrendingHalfLifeDays   = 7.0 // La actividad pierde la mitad de su peso cada 7 días
+	TrendingWindowDays     = 30  // Actividad más antigua no suma
+	TrendingViewWeight     = 1.0
+	TrendingSaleWeight     = 10.0
+	TrendingReviewWeight   = 5.0
+	BestsellersLimit       = 10
+	BestsellersWindowDays  = 30
+)
+
+// ProductDailyStats actividad diaria de un producto: visitas y ventas (diferencia de products.sold)
+type ProductDailyStats struct {
+	ProductID string    `json:"product_id" gorm:"type:char(36);primaryKey"`
+	Day       time.Time `json:"day" gorm:"type:date;primaryKey"`
+	Views     int       `json:"views" gorm:"default:0"`
+	Sales     int       `json:"sales" gorm:"default:0"`
+}
+
+func (ProductDailyStats) TableName() string {
+	return "product_daily_stats"
+}
+
+var (
+	// Visitas acumuladas en memoria hasta el próximo FlushProductViews (producto -> visitas)
+	productViewsMu sync.Mutex
+	productViews   = make(map[string]int)
+
+	// Más vendidos por categoría (incluye subcategorías), calculados por RefreshTrending
+	bestsellersMu sync.RWMutex
+	bestsellers   = make(map[string][]string)
+)
+
+// RecordProductView suma una visita al producto; se guarda en product_daily_stats en el próximo flush
+func RecordProductView(productID string) {
+	productViewsMu.Lock()
+	defer productViewsMu.Unlock()
+	productViews[productID]++
+}
+
+// RunTrendingJob guarda las visitas cada ProductViewsFlushEvery y recalcula tendencia y más vendidos cada TrendingRefreshEvery
+func RunTrendingJob(getDB func() *gorm.DB) {
+	var lastRefresh time.Time
+	for {
+		if err := FlushProductViews(getDB()); err != nil {
+			log.Println("Error flushing product views: ", err)
+		}
+		if time.Since(lastRefresh) >= TrendingRefreshEvery {
+			if err := RefreshTrending(getDB(), time.Now()); err != nil {
+				log.Println("Error refreshing trending scores: ", err)
+			}
+			lastRefresh = time.Now()
+		}
+		time.Sleep(ProductViewsFlushEvery)
+	}
+}
+
+// FlushProductViews suma las visitas acumuladas al día actual; si falla, se conservan para el próximo intento
+func FlushProductViews(db *gorm.DB) error {
+	productViewsMu.Lock()
+	pending := productViews
+	productViews = make(map[string]int)
+	productViewsMu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	today := statsDay(time.Now())
+	rows := make([]ProductDailyStats, 0, len(pending))
+	for productID, views := range pending {
+		rows = append(rows, ProductDailyStats{ProductID: productID, Day: today, Views: views})
+	}
+
+	err := db.Clauses(clause.OnConflict{
+		DoUpdates: clause.Assignments(map[string]interface{}{"views": gorm.Expr("views + VALUES(views)")}),
+	}).CreateInBatches(rows, 500).Error
+	if err != nil {
+		productViewsMu.Lock()
+		for productID, views := range pending {
+			productViews[productID] += views
+		}
+		productViewsMu.Unlock()
+	}
+	return err
+}
+
+// RefreshTrending registra las ventas nuevas, recalcula products.trending_score y los más vendidos por categoría
+func RefreshTrending(db *gorm.DB, now time.Time) error {
+	if err := recordProductSales(db, now); err != nil {
+		return fmt.Errorf("recording sales: %w", err)
+	}
+	if err := updateTrendingScores(db, now); err != nil {
+		return fmt.Errorf("updating scores: %w", err)
+	}
+	if err := refreshBestsellers(db, now); err != nil {
+		return fmt.Errorf("bestsellers: %w", err)
+	}
+	return nil
+}
+
+// recordProductSales pasa a product_daily_stats lo que creció products.sold desde la última ejecución.
+// La primera vez solo se toma la referencia (sold_snapshot) para no contar las ventas históricas como de hoy
+func recordProductSales(db *gorm.DB, now time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("INSERT INTO product_daily_stats (product_id, day, views, sales) "+
+			"SELECT id, ?, 0, sold - sold_snapshot FROM products WHERE sold_snapshot IS NOT NULL AND sold > sold_snapshot "+
+			"ON DUPLICATE KEY UPDATE sales = sales + VALUES(sales)", statsDay(now)).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("UPDATE products SET sold_snapshot = sold, updated_at = updated_at WHERE sold_snapshot IS NULL OR sold_snapshot <> sold").Error
+	})
+}
+
+// updateTrendingScores puntaje = suma de visitas, ventas y reseñas aprobadas de la ventana, cada una con peso
+// 0.5^(días de antigüedad / TrendingHalfLifeDays). Solo se escriben productos con actividad o con puntaje previo.
+// updated_at se conserva (la columna tiene ON UPDATE): el puntaje no es un cambio del producto para los feeds
+func updateTrendingScores(db *gorm.DB, now time.Time) error {
+	since := now.AddDate(0, 0, -TrendingWindowDays)
+	today := statsDay(now)
+
+	return db.Exec(`UPDATE products p
+		LEFT JOIN (
+			SELECT product_id, SUM((views * ? + sales * ?) * POW(0.5, DATEDIFF(?, day) / ?)) AS score
+			FROM product_daily_stats WHERE day >= ? GROUP BY product_id
+		) activity ON activity.product_id = p.id
+		LEFT JOIN (
+			SELECT product_id, SUM(? * POW(0.5, TIMESTAMPDIFF(HOUR, created_at, ?) / 24 / ?)) AS score
+			FROM reviews WHERE status = 'approved' AND created_at >= ? GROUP BY product_id
+		) review_activity ON review_activity.product_id = p.id
+		SET p.trending_score = ROUND(COALESCE(activity.score, 0) + COALESCE(review_activity.score, 0), 6),
+			p.updated_at = p.updated_at
+		WHERE activity.product_id IS NOT NULL OR review_activity.product_id IS NOT NULL OR p.trending_score <> 0`,
+		TrendingViewWeight, TrendingSaleWeight, today, TrendingHalfLifeDays, statsDay(since),
+		TrendingReviewWeight, now, TrendingHalfLifeDays, since).Error
+}
+
+// refreshBestsellers ventas de la ventana por producto y categoría, acumuladas hacia las categorías padre
+func refreshBestsellers(db *gorm.DB, now time.Time) error {
+	var rows []struct {
+		CategoryID string
+		ProductID  string
+		Sales      int
+	}
+	err := db.Raw(`SELECT pc.category_id, s.product_id, SUM(s.sales) AS sales
+		FROM product_daily_stats s
+		JOIN product_categories pc ON pc.product_id = s.product_id
+		JOIN products p ON p.id = s.product_id AND p.status = 'active'
+		WHERE s.day >= ? AND s.sales > 0
+		GROUP BY pc.category_id, s.product_id`, statsDay(now.AddDate(0, 0, -BestsellersWindowDays))).
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	// Un producto en varias subcategorías de la misma rama cuenta una sola vez en cada ancestro
+	salesByCategory := make(map[string]map[string]int)
+	for _, row := range rows {
+		for _, category := range GetCategoryPath(row.CategoryID) {
+			if salesByCategory[category.ID] == nil {
+				salesByCategory[category.ID] = make(map[string]int)
+			}
+			salesByCategory[category.ID][row.ProductID] = row.Sales
+		}
+	}
+
+	ranking := make(map[string][]string, len(salesByCategory))
+	for categoryID, sales := range salesByCategory {
+		ids := make([]string, 0, len(sales))
+		for productID := range sales {
+			ids = append(ids, productID)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			if sales[ids[i]] != sales[ids[j]] {
+				return sales[ids[i]] > sales[ids[j]]
+			}
+			return ids[i] < ids[j]
+		})
+		if len(ids) > BestsellersLimit {
+			ids = ids[:BestsellersLimit]
+		}
+		ranking[categoryID] = ids
+	}
+
+	bestsellersMu.Lock()
+	bestsellers = ranking
+	bestsellersMu.Unlock()
+	return nil
+}
+
+// GetCategoryBestsellers "Más vendidos" de la categoría (con subcategorías) según la última ejecución del job
+func GetCategoryBestsellers(db *gorm.DB, categoryID string, limit int) ([]Product, error) {
+	bestsellersMu.RLock()
+	ids := bestsellers[categoryID]
+	bestsellersMu.RUnlock()
+
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return getActiveProductsByIDs(db, ids)
+}
+
+// statsDay fecha local de t como medianoche UTC, para que la columna DATE guarde el día local
+func statsDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
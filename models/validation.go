@@ -0,0 +1,89 @@
+package models
+
+import (
+	H "mercadillo-global/helpers"
+)
+
+// ValidationModels traducción de los campos de los DTO de la API para H.CustomValidator.ListModels, por nombre
+// del struct que se valida. Los campos de los structs anidados se traducen en el modelo que los contiene
+var ValidationModels = map[string]H.ModelTranslate{
+	"ProductRequest":      productRequestFields,
+	"ProductPatchRequest": productRequestFields,
+	"StatusChangeRequest": statusChangeRequestFields,
+}
+
+// productRequestFields nombres en es/en de los campos de los DTO de productos, incluidos los de sus structs anidados
+var productRequestFields = H.ModelTranslate{
+	"es": H.FieldTranslate{
+		"SKU":            "SKU",
+		"Title":          "título",
+		"Description":    "descripción",
+		"Price":          "precio",
+		"OriginalPrice":  "precio original",
+		"CurrencyID":     "moneda",
+		"Images":         "imágenes",
+		"Stock":          "stock",
+		"IsService":      "es servicio",
+		"FreeShipping":   "envío gratis",
+		"Specifications": "especificaciones",
+		"Status":         "estado",
+		"Categories":     "categorías",
+		"Attributes":     "atributos",
+		"Warehouses":     "almacenes",
+		"Variants":       "variantes",
+		"Options":        "opciones",
+		"Barcode":        "código de barras",
+		"Stocks":         "stock por almacén",
+		"CategoryID":     "categoría",
+		"IsPrimary":      "categoría principal",
+		"Slug":           "atributo",
+		"Value":          "valor",
+		"WarehouseID":    "almacén",
+		"Quantity":       "cantidad",
+		"Weight":         "peso",
+		"Dimensions":     "dimensiones",
+		"Name":           "nombre",
+	},
+	"en": H.FieldTranslate{
+		"SKU":            "SKU",
+		"Title":          "title",
+		"Description":    "description",
+		"Price":          "price",
+		"OriginalPrice":  "original price",
+		"CurrencyID":     "currency",
+		"Images":         "images",
+		"Stock":          "stock",
+		"IsService":      "is service",
+		"FreeShipping":   "free shipping",
+		"Specifications": "specifications",
+		"Status":         "status",
+		"Categories":     "categories",
+		"Attributes":     "attributes",
+		"Warehouses":     "warehouses",
+		"Variants":       "variants",
+		"Options":        "options",
+		"Barcode":        "barcode",
+		"Stocks":         "stock per warehouse",
+		"CategoryID":     "category",
+		"IsPrimary":      "primary category",
+		"Slug":           "attribute",
+		"Value":          "value",
+		"WarehouseID":    "warehouse",
+		"Quantity":       "quantity",
+		"Weight":         "weight",
+		"Dimensions":     "dimensions",
+		"Name":           "name",
+	},
+}
+
+// statusChangeRequestFields nombres en es/en de los campos de StatusChangeRequest
+var statusChangeRequestFields = H.ModelTranslate{
+	"es": H.FieldTranslate{
+		"Status": "estado",
+		"Reason": "motivo",
+	},
+	"en": H.FieldTranslate{
+		"Status": "status",
+		"Reason": "reason",
+	},
+}
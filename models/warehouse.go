@@ -1,6 +1,8 @@
 package models
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -91,6 +93,13 @@ type ShippingLocation struct {
 	Cities []string `json:"cities,omitempty"`
 }
 
+// ShipTo destino de envío elegido por el comprador: país ISO2 y, opcionalmente, estado y ciudad
+type ShipTo struct {
+	Country string `json:"country"`
+	State   string `json:"state,omitempty"`
+	City    string `json:"city,omitempty"`
+}
+
 // GORM Hooks
 func (w *Warehouse) BeforeCreate(tx *gorm.DB) error {
 	if H.IsEmpty(w.ID) {
@@ -164,3 +173,112 @@ func GetUserWarehouses(db *gorm.DB, userID string) ([]Warehouse, error) {
 
 	return warehouses, err
 }
+
+// GetShippingCountries países (ISO2) con almacenes activos o costos de envío activos
+func GetShippingCountries(db *gorm.DB) ([]string, error) {
+	var countries []string
+	err := db.Raw("SELECT country FROM warehouses WHERE is_active = TRUE UNION SELECT country FROM shipping_costs WHERE is_active = TRUE ORDER BY country").
+		Scan(&countries).Error
+	return countries, err
+}
+
+// shipToProductIDsSQL subconsulta con los productos que llegan al destino: un almacén activo ubicado en él
+// o un costo de envío activo a su país cuyas locations (vacías = todo el país) incluyen el estado y la ciudad.
+// El envío gratis no cambia la cobertura. Los servicios no se envían: quien filtra debe dejarlos pasar aparte
+func shipToProductIDsSQL(shipTo ShipTo) (string, []interface{}) {
+	warehouseSQL := "w.country = ?"
+	args := []interface{}{shipTo.Country}
+	locationSQL := ""
+	locationArgs := []interface{}{}
+	if shipTo.State != "" {
+		warehouseSQL += " AND w.state = ?"
+		args = append(args, shipTo.State)
+		locationSQL = "COALESCE(loc.state, '') IN ('', ?)"
+		locationArgs = append(locationArgs, shipTo.State)
+	}
+	if shipTo.City != "" {
+		warehouseSQL += " AND w.city = ?"
+		args = append(args, shipTo.City)
+		if locationSQL != "" {
+			locationSQL += " AND "
+		}
+		locationSQL += "(loc.city IS NULL OR loc.city = ?)"
+		locationArgs = append(locationArgs, shipTo.City)
+	}
+
+	costSQL := "SELECT 1 FROM shipping_costs sc WHERE sc.product_warehouse_id = pw.id AND sc.is_active = TRUE AND sc.country = ?"
+	args = append(args, shipTo.Country)
+	if locationSQL != "" {
+		costSQL += " AND (sc.locations IS NULL OR JSON_LENGTH(sc.locations) = 0 OR EXISTS (SELECT 1 FROM JSON_TABLE(sc.locations, '$[*]' COLUMNS (" +
+			"state VARCHAR(100) PATH '$.state', NESTED PATH '$.cities[*]' COLUMNS (city VARCHAR(100) PATH '$'))) loc WHERE " + locationSQL + "))"
+		args = append(args, locationArgs...)
+	}
+
+	return "SELECT pw.product_id FROM product_warehouses pw JOIN warehouses w ON w.id = pw.warehouse_id AND w.is_active = TRUE " +
+		"WHERE (" + warehouseSQL + ") OR EXISTS (" + costSQL + ")", args
+}
+
+// ShipsTo indica en memoria si el producto llega al destino (mismo criterio que shipToProductIDsSQL; los
+// servicios siempre llegan). Requiere Warehouses, Warehouses.Warehouse y Warehouses.ShippingCosts cargados
+func (p *Product) ShipsTo(shipTo ShipTo) bool {
+	return p.IsService || warehousesShipTo(p.Warehouses, shipTo)
+}
+
+// warehousesShipTo indica si alguno de los almacenes del producto llega al destino
+func warehousesShipTo(productWarehouses []ProductWarehouse, shipTo ShipTo) bool {
+	for _, productWarehouse := range productWarehouses {
+		if !productWarehouse.Warehouse.IsActive {
+			continue
+		}
+		if productWarehouse.Warehouse.LocatedIn(shipTo) {
+			return true
+		}
+		for _, shippingCost := range productWarehouse.ShippingCosts {
+			if shippingCost.Covers(shipTo) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LocatedIn indica si el almacén está en el país, estado y ciudad indicados (los vacíos no se comparan)
+func (w *Warehouse) LocatedIn(shipTo ShipTo) bool {
+	return sameLocation(w.Country, shipTo.Country) &&
+		(shipTo.State == "" || sameLocation(w.State, shipTo.State)) &&
+		(shipTo.City == "" || sameLocation(w.City, shipTo.City))
+}
+
+// Covers indica si el costo de envío aplica al destino
+func (sc *ShippingCost) Covers(shipTo ShipTo) bool {
+	if !sc.IsActive || !sameLocation(sc.Country, shipTo.Country) {
+		return false
+	}
+	if shipTo.State == "" && shipTo.City == "" {
+		return true
+	}
+
+	var locations []ShippingLocation
+	if H.IsEmpty(sc.Locations) || json.Unmarshal([]byte(sc.Locations), &locations) != nil || len(locations) == 0 {
+		return true
+	}
+	for _, location := range locations {
+		if location.State != "" && shipTo.State != "" && !sameLocation(location.State, shipTo.State) {
+			continue
+		}
+		if shipTo.City == "" || len(location.Cities) == 0 {
+			return true
+		}
+		for _, city := range location.Cities {
+			if sameLocation(city, shipTo.City) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameLocation compara nombres de lugares sin distinguir mayúsculas ni acentos (como la colación de MySQL)
+func sameLocation(a, b string) bool {
+	return strings.EqualFold(H.RemoveAccents(H.Trim(a)), H.RemoveAccents(H.Trim(b)))
+}
@@ -0,0 +1,310 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	H "mercadillo-global/helpers"
+)
+
+// Quién puede disparar un cambio de estado
+const (
+	ActorSeller    = "seller"    // Dueño del producto
+	ActorIA        = "ia"        // Pipeline de IA
+	ActorModerator = "moderator" // Moderación (API de administración)
+)
+
+// Errores de los cambios de estado
+var (
+	ErrStatusEntityNotFound    = errors.New("status entity not found")
+	ErrIllegalStatusTransition = errors.New("illegal status transition")
+)
+
+// StatusActor quién hace el cambio; ID es el usuario (vacío para la IA o la clave de administración)
+type StatusActor struct {
+	Type string
+	ID   string
+}
+
+// StatusChangeRequest cuerpo de los cambios de estado de moderación (POST /admin/.../:id/status)
+type StatusChangeRequest struct {
+	Status string `json:"status" validate:"required,max=30"`
+	Reason string `json:"reason" validate:"max=1000"`
+}
+
+// StatusTransition cambio permitido de From a To y los actores que pueden hacerlo
+type StatusTransition struct {
+	From   string
+	To     string
+	Actors []string
+}
+
+// StatusWorkflow máquina de estados de una entidad (products, questions, reviews) con su historial en
+// product_status_history. Cada cambio dispara el evento "<Entity>.status_changed" de H.Listener
+type StatusWorkflow struct {
+	Entity          string // product, question, review
+	Table           string
+	ProductIDColumn string // Columna con el producto al que pertenece (id para products)
+	Transitions     []StatusTransition
+}
+
+// ProductStatusHistory cambio de estado de un producto o de una de sus preguntas o reseñas
+type ProductStatusHistory struct {
+	ID         string    `json:"id" gorm:"type:char(36);primaryKey"`
+	ProductID  string    `json:"product_id" gorm:"type:char(36);not null;index"`
+	EntityType string    `json:"entity_type" gorm:"type:enum('product','question','review');not null"`
+	EntityID   string    `json:"entity_id" gorm:"type:char(36);not null;index:idx_product_status_history_entity"`
+	FromStatus string    `json:"from_status" gorm:"type:varchar(30);comment:'Empty when the entity is created'"`
+	ToStatus   string    `json:"to_status" gorm:"type:varchar(30);not null"`
+	ActorType  string    `json:"actor_type" gorm:"type:enum('seller','ia','moderator');not null"`
+	ActorID    string    `json:"actor_id" gorm:"type:char(36)"`
+	Reason     string    `json:"reason" gorm:"type:text"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (ProductStatusHistory) TableName() string {
+	return "product_status_history"
+}
+
+func (h *ProductStatusHistory) BeforeCreate(tx *gorm.DB) error {
+	if H.IsEmpty(h.ID) {
+		h.ID = H.NewUUID()
+	}
+	return nil
+}
+
+// ProductWorkflow el vendedor manda a revisión, pausa o retira; la IA aprueba o deriva a moderación;
+// moderación aprueba, rechaza (vuelve a borrador) o pausa. Si el vendedor cambia el contenido de un producto
+// publicado, vuelve a wait_for_ia
+var ProductWorkflow = &StatusWorkflow{
+	Entity:          "product",
+	Table:           "products",
+	ProductIDColumn: "id",
+	Transitions: []StatusTransition{
+		{From: "draft", To: "wait_for_ia", Actors: []string{ActorSeller}},
+		{From: "wait_for_ia", To: "active", Actors: []string{ActorIA}},
+		{From: "wait_for_ia", To: "wait_for_human_review", Actors: []string{ActorIA}},
+		{From: "wait_for_ia", To: "draft", Actors: []string{ActorSeller, ActorIA}},
+		{From: "wait_for_human_review", To: "active", Actors: []string{ActorModerator}},
+		{From: "wait_for_human_review", To: "draft", Actors: []string{ActorSeller, ActorModerator}},
+		{From: "active", To: "pause", Actors: []string{ActorSeller, ActorModerator}},
+		{From: "active", To: "wait_for_human_review", Actors: []string{ActorModerator}},
+		{From: "active", To: "draft", Actors: []string{ActorSeller}},
+		{From: "active", To: "wait_for_ia", Actors: []string{ActorSeller}},
+		{From: "pause", To: "active", Actors: []string{ActorSeller, ActorModerator}},
+		{From: "pause", To: "draft", Actors: []string{ActorSeller}},
+		{From: "pause", To: "wait_for_ia", Actors: []string{ActorSeller}},
+	},
+}
+
+// QuestionWorkflow la IA o el vendedor responden; moderación revisa y oculta
+var QuestionWorkflow = &StatusWorkflow{
+	Entity:          "question",
+	Table:           "questions",
+	ProductIDColumn: "product_id",
+	Transitions: []StatusTransition{
+		{From: "wait_for_ia", To: "answered", Actors: []string{ActorIA, ActorSeller}},
+		{From: "wait_for_ia", To: "wait_for_human_review", Actors: []string{ActorIA}},
+		{From: "wait_for_ia", To: "hidden", Actors: []string{ActorIA, ActorModerator}},
+		{From: "wait_for_human_review", To: "answered", Actors: []string{ActorSeller, ActorModerator}},
+		{From: "wait_for_human_review", To: "hidden", Actors: []string{ActorModerator}},
+		{From: "answered", To: "hidden", Actors: []string{ActorModerator}},
+		{From: "hidden", To: "wait_for_human_review", Actors: []string{ActorModerator}},
+		{From: "hidden", To: "answered", Actors: []string{ActorModerator}},
+	},
+}
+
+// ReviewWorkflow la IA aprueba, oculta o deriva a moderación; el vendedor no puede tocar reseñas
+var ReviewWorkflow = &StatusWorkflow{
+	Entity:          "review",
+	Table:           "reviews",
+	ProductIDColumn: "product_id",
+	Transitions: []StatusTransition{
+		{From: "wait_for_ia", To: "approved", Actors: []string{ActorIA}},
+		{From: "wait_for_ia", To: "wait_for_human_review", Actors: []string{ActorIA}},
+		{From: "wait_for_ia", To: "hidden", Actors: []string{ActorIA, ActorModerator}},
+		{From: "wait_for_human_review", To: "approved", Actors: []string{ActorModerator}},
+		{From: "wait_for_human_review", To: "hidden", Actors: []string{ActorModerator}},
+		{From: "approved", To: "hidden", Actors: []string{ActorModerator}},
+		{From: "approved", To: "wait_for_human_review", Actors: []string{ActorModerator}},
+		{From: "hidden", To: "approved", Actors: []string{ActorModerator}},
+	},
+}
+
+// GetStatusWorkflow workflow de la entidad (product, question, review) o nil
+func GetStatusWorkflow(entity string) *StatusWorkflow {
+	for _, workflow := range []*StatusWorkflow{ProductWorkflow, QuestionWorkflow, ReviewWorkflow} {
+		if workflow.Entity == entity {
+			return workflow
+		}
+	}
+	return nil
+}
+
+// EventName evento de H.Listener que se dispara en cada cambio de estado de la entidad
+func (w *StatusWorkflow) EventName() string {
+	return w.Entity + ".status_changed"
+}
+
+// Can indica si el actor puede pasar de from a to
+func (w *StatusWorkflow) Can(from, to, actor string) bool {
+	for _, transition := range w.Transitions {
+		if transition.From == from && transition.To == to {
+			exists, _ := H.InArray(actor, transition.Actors)
+			return exists
+		}
+	}
+	return false
+}
+
+// Check error ErrIllegalStatusTransition si el actor no puede hacer el cambio
+func (w *StatusWorkflow) Check(from, to, actor string) error {
+	if !w.Can(from, to, actor) {
+		return fmt.Errorf("%w: %s can't move %s from %s to %s", ErrIllegalStatusTransition, actor, w.Entity, from, to)
+	}
+	return nil
+}
+
+// Transition cambia el estado de la entidad, lo registra en el historial y dispara el evento
+func (w *StatusWorkflow) Transition(db *gorm.DB, entityID, to string, actor StatusActor, reason string) (*ProductStatusHistory, error) {
+	var change *ProductStatusHistory
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		change, err = w.Apply(tx, entityID, to, actor, reason)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	change.Fire()
+	return change, nil
+}
+
+// Apply hace el cambio dentro de la transacción tx, bloqueando la fila, sin disparar el evento: quien llama
+// debe llamar a Fire después del commit
+func (w *StatusWorkflow) Apply(tx *gorm.DB, entityID, to string, actor StatusActor, reason string) (*ProductStatusHistory, error) {
+	var current struct {
+		Status    string
+		ProductID string
+	}
+	err := tx.Table(w.Table).
+		Select("status, "+w.ProductIDColumn+" AS product_id").
+		Where("id = ?", entityID).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Take(&current).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s %s", ErrStatusEntityNotFound, w.Entity, entityID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.Check(current.Status, to, actor.Type); err != nil {
+		return nil, err
+	}
+
+	err = tx.Table(w.Table).Where("id = ?", entityID).
+		Updates(map[string]interface{}{"status": to, "updated_at": time.Now()}).Error
+	if err != nil {
+		return nil, err
+	}
+	return w.record(tx, current.ProductID, entityID, current.Status, to, actor, reason)
+}
+
+// RecordCreated registra el estado inicial de una entidad recién creada (sin estado anterior)
+func (w *StatusWorkflow) RecordCreated(tx *gorm.DB, productID, entityID, status string, actor StatusActor) (*ProductStatusHistory, error) {
+	return w.record(tx, productID, entityID, "", status, actor, "created")
+}
+
+// GetProductStatusHistory historial del producto y de sus preguntas y reseñas, el más reciente primero
+func GetProductStatusHistory(db *gorm.DB, productID string, limit int) ([]ProductStatusHistory, error) {
+	history := make([]ProductStatusHistory, 0)
+	err := db.Where("product_id = ?", productID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&history).Error
+	return history, err
+}
+
+// Fire dispara el evento del cambio en H.Listener
+func (h *ProductStatusHistory) Fire() {
+	workflow := GetStatusWorkflow(h.EntityType)
+	if workflow == nil {
+		return
+	}
+	H.Listener.Fire(workflow.EventName(), H.EventArgs{
+		"entity_id":  h.EntityID,
+		"product_id": h.ProductID,
+		"from":       h.FromStatus,
+		"to":         h.ToStatus,
+		"actor_type": h.ActorType,
+		"actor_id":   h.ActorID,
+		"reason":     h.Reason,
+	})
+}
+
+// record guarda el cambio en product_status_history
+func (w *StatusWorkflow) record(tx *gorm.DB, productID, entityID, from, to string, actor StatusActor, reason string) (*ProductStatusHistory, error) {
+	change := &ProductStatusHistory{
+		ProductID:  productID,
+		EntityType: w.Entity,
+		EntityID:   entityID,
+		FromStatus: from,
+		ToStatus:   to,
+		ActorType:  actor.Type,
+		ActorID:    actor.ID,
+		Reason:     reason,
+	}
+	if err := tx.Create(change).Error; err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// RegisterWorkflowListeners listeners de los cambios de estado; hay que llamarlo después de H.Listener.Load.
+// H.Listener corta el proceso si se dispara un evento sin listeners, así que se registran los tres
+func RegisterWorkflowListeners(getDB func() *gorm.DB) {
+	// Al entrar o salir de active el producto aparece o desaparece de la búsqueda
+	H.Listener.AddListener(ProductWorkflow.EventName(), func(event_uuid string, args H.EventArgs) {
+		productID, _ := args["product_id"].(string)
+		var product Product
+		err := getDB().Preload("ProductCategories").
+			Preload("Warehouses.Warehouse").
+			Preload("Warehouses.ShippingCosts").
+			First(&product, "id = ?", productID).Error
+		if err == nil {
+			err = GetSearchEngine(getDB()).Index(product)
+		}
+		if err != nil {
+			log.Println("Error reindexing product after status change: ", productID, err)
+		}
+	})
+
+	// Punto de extensión para avisar al que preguntó cuando se responde
+	H.Listener.AddListener(QuestionWorkflow.EventName(), func(event_uuid string, args H.EventArgs) {
+	})
+
+	// Rating y cantidad de reseñas del producto solo cuentan las aprobadas
+	H.Listener.AddListener(ReviewWorkflow.EventName(), func(event_uuid string, args H.EventArgs) {
+		if args["from"] != "approved" && args["to"] != "approved" {
+			return
+		}
+		productID, _ := args["product_id"].(string)
+		if err := RefreshProductRating(getDB(), productID); err != nil {
+			log.Println("Error refreshing product rating: ", productID, err)
+		}
+	})
+}
+
+// RefreshProductRating recalcula rating y review_count del producto con sus reseñas aprobadas
+func RefreshProductRating(db *gorm.DB, productID string) error {
+	return db.Exec(`UPDATE products p SET
+		p.rating = (SELECT COALESCE(ROUND(AVG(r.rating), 2), 0) FROM reviews r WHERE r.product_id = p.id AND r.status = 'approved'),
+		p.review_count = (SELECT COUNT(*) FROM reviews r WHERE r.product_id = p.id AND r.status = 'approved')
+		WHERE p.id = ?`, productID).Error
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	H "mercadillo-global/helpers"
+	"mercadillo-global/models"
+)
+
+// requireUser solo deja pasar peticiones con un usuario autenticado (JWT, ver H.GetUserID)
+func requireUser(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if H.GetUserID(c) == "" {
+			return c.JSON(http.StatusUnauthorized, H.GenericError{Message: H.TranslateText("Authentication required", c)})
+		}
+		return next(c)
+	}
+}
+
+// createProduct POST /api/v1/products
+func createProduct(c echo.Context) error {
+	var req models.ProductRequest
+	if genericError := bindRequest(c, &req); genericError != nil {
+		return c.JSON(http.StatusBadRequest, genericError)
+	}
+
+	product, err := models.CreateProduct(H.DB(), H.GetUserID(c), req)
+	if err != nil {
+		return productAPIError(c, err)
+	}
+	indexProduct(c, product)
+	return c.JSON(http.StatusCreated, product)
+}
+
+// replaceProduct PUT /api/v1/products/:productId, reemplaza el producto completo
+func replaceProduct(c echo.Context) error {
+	var req models.ProductRequest
+	if genericError := bindRequest(c, &req); genericError != nil {
+		return c.JSON(http.StatusBadRequest, genericError)
+	}
+	return updateProduct(c, req.Patch())
+}
+
+// patchProduct PATCH /api/v1/products/:productId, cambia solo los campos enviados
+func patchProduct(c echo.Context) error {
+	var req models.ProductPatchRequest
+	if genericError := bindRequest(c, &req); genericError != nil {
+		return c.JSON(http.StatusBadRequest, genericError)
+	}
+	return updateProduct(c, req)
+}
+
+// updateProduct guarda los cambios de PUT y PATCH
+func updateProduct(c echo.Context, req models.ProductPatchRequest) error {
+	product, err := models.UpdateProduct(H.DB(), H.GetUserID(c), c.Param("productId"), req)
+	if err != nil {
+		return productAPIError(c, err)
+	}
+	indexProduct(c, product)
+	return c.JSON(http.StatusOK, product)
+}
+
+// deleteProduct DELETE /api/v1/products/:productId
+func deleteProduct(c echo.Context) error {
+	productID := c.Param("productId")
+	if err := models.DeleteProduct(H.DB(), H.GetUserID(c), productID); err != nil {
+		return productAPIError(c, err)
+	}
+	if err := models.GetSearchEngine(H.DB()).Delete(productID); err != nil {
+		c.Logger().Error("Error removing product from search index: ", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// bindRequest lee el JSON en el DTO y lo valida con H.CustomValidator; el error ya tiene la forma de respuesta
+func bindRequest(c echo.Context, data interface{}) *H.GenericError {
+	if err := c.Bind(data); err != nil {
+		return &H.GenericError{Message: H.TranslateText("Invalid JSON body", c), Error: err.Error()}
+	}
+	if err := H.Validate(data, c); err != nil {
+		var httpError *echo.HTTPError
+		if errors.As(err, &httpError) {
+			return &H.GenericError{Message: H.TranslateText("Invalid data", c), Error: httpError.Message}
+		}
+		return &H.GenericError{Message: err.Error()}
+	}
+	return nil
+}
+
+// productAPIError responde los errores de models con el código que corresponde
+func productAPIError(c echo.Context, err error) error {
+	var rulesErr *models.ProductRulesError
+	switch {
+	case errors.As(err, &rulesErr):
+		return c.JSON(http.StatusBadRequest, H.GenericError{Message: H.TranslateText("Invalid product data", c), Error: rulesErr.Errors})
+	case errors.Is(err, models.ErrProductNotFound):
+		return c.JSON(http.StatusNotFound, H.GenericError{Message: H.TranslateText("Product not found", c)})
+	case errors.Is(err, models.ErrInvalidProductInput):
+		return c.JSON(http.StatusBadRequest, H.GenericError{Message: H.TranslateText("Invalid product data", c), Error: err.Error()})
+	case errors.Is(err, models.ErrIllegalStatusTransition):
+		return c.JSON(http.StatusConflict, H.GenericError{Message: H.TranslateText("Status change not allowed", c), Error: err.Error()})
+	case errors.Is(err, models.ErrProductInUse):
+		return c.JSON(http.StatusConflict, H.GenericError{
+			Message: H.TranslateText("The product has questions or reviews, pause it instead of deleting it", c),
+		})
+	}
+	c.Logger().Error("Error saving product: ", err)
+	return c.JSON(http.StatusInternalServerError, H.GenericError{Message: H.TranslateText("Error saving product", c)})
+}
+
+// moderateStatus POST /admin/{products,questions,reviews}/:id/status, cambio de estado hecho por moderación
+func moderateStatus(workflow *models.StatusWorkflow) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req models.StatusChangeRequest
+		if genericError := bindRequest(c, &req); genericError != nil {
+			return c.JSON(http.StatusBadRequest, genericError)
+		}
+
+		actor := models.StatusActor{Type: models.ActorModerator, ID: H.GetUserID(c)}
+		change, err := workflow.Transition(H.DB(), c.Param("id"), req.Status, actor, req.Reason)
+		switch {
+		case errors.Is(err, models.ErrStatusEntityNotFound):
+			return c.JSON(http.StatusNotFound, H.GenericError{Message: H.TranslateText("Not found", c)})
+		case errors.Is(err, models.ErrIllegalStatusTransition):
+			return c.JSON(http.StatusConflict, H.GenericError{Message: H.TranslateText("Status change not allowed", c), Error: err.Error()})
+		case err != nil:
+			c.Logger().Error("Error changing status: ", err)
+			return c.JSON(http.StatusInternalServerError, H.GenericError{Message: H.TranslateText("Error changing status", c)})
+		}
+		return c.JSON(http.StatusOK, change)
+	}
+}
+
+// productStatusHistory GET /admin/products/:id/status-history, cambios del producto y de sus preguntas y reseñas
+func productStatusHistory(c echo.Context) error {
+	limit := H.GetIntParam(c, "limit", 100)
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	history, err := models.GetProductStatusHistory(H.DB(), c.Param("id"), limit)
+	if err != nil {
+		c.Logger().Error("Error loading status history: ", err)
+		return c.JSON(http.StatusInternalServerError, H.GenericError{Message: H.TranslateText("Error loading status history", c)})
+	}
+	return c.JSON(http.StatusOK, history)
+}
+
+// migrateAttributeVariants POST /admin/variants/migrate, pasa a variantes los atributos por almacén de los
+// productos que aún no tienen variantes
+func migrateAttributeVariants(c echo.Context) error {
+	migrated, err := models.MigrateAttributeVariants(H.DB())
+	if err != nil {
+		c.Logger().Error("Error migrating attribute variants: ", err)
+		return c.JSON(http.StatusInternalServerError, H.GenericError{Message: H.TranslateText("Error migrating variants", c), Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]int{"migrated": migrated})
+}
+
+// indexProduct actualiza el producto en el motor de búsqueda (los que no están activos no aparecen en resultados)
+func indexProduct(c echo.Context, product *models.Product) {
+	if err := models.GetSearchEngine(H.DB()).Index(*product); err != nil {
+		c.Logger().Error("Error indexing product: ", err)
+	}
+}
@@ -35,6 +35,7 @@ CREATE TABLE `products` (
   `short_key` VARCHAR(20) NOT NULL,
   `slug` VARCHAR(255) NOT NULL,
   `user_id` CHAR(36) NOT NULL,
+  `sku` VARCHAR(100) DEFAULT NULL COMMENT 'Seller reference, unique per seller',
   `title` VARCHAR(100) NOT NULL,
   `price` DECIMAL(10,2) NOT NULL,
   `original_price` DECIMAL(10,2) NOT NULL DEFAULT 0,
@@ -54,11 +55,14 @@ CREATE TABLE `products` (
   `status` ENUM('active','wait_for_ia','wait_for_human_review','pause','draft') NOT NULL DEFAULT 'draft',
   `kyc` BOOLEAN NOT NULL DEFAULT FALSE,
   `from_company` BOOLEAN NOT NULL DEFAULT FALSE,
+  `trending_score` DOUBLE NOT NULL DEFAULT 0 COMMENT 'Time-decayed popularity from views, sales and reviews',
+  `sold_snapshot` INT DEFAULT NULL COMMENT 'Sold already counted in product_daily_stats',
   `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
   `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
   PRIMARY KEY (`id`),
   UNIQUE KEY `idx_products_short_key` (`short_key`),
   UNIQUE KEY `idx_products_slug` (`slug`),
+  UNIQUE KEY `idx_products_user_sku` (`user_id`, `sku`),
   KEY `fk_products_user` (`user_id`),
   KEY `idx_products_status` (`status`),
   KEY `idx_products_price` (`price`),
@@ -66,6 +70,7 @@ CREATE TABLE `products` (
   KEY `idx_products_stock` (`stock`),
   KEY `idx_products_kyc` (`kyc`),
   KEY `idx_products_from_company` (`from_company`),
+  KEY `idx_products_trending` (`trending_score`, `created_at`),
   CONSTRAINT `fk_products_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE RESTRICT ON UPDATE RESTRICT
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
 
@@ -170,6 +175,74 @@ CREATE TABLE `product_attributes` (
   CONSTRAINT `fk_product_attributes_product_warehouse` FOREIGN KEY (`product_warehouse_id`) REFERENCES `product_warehouses` (`id`) ON DELETE RESTRICT ON UPDATE RESTRICT
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
 
+-- Images uploaded by sellers; the files of each size and format are in the image storage
+CREATE TABLE `product_images` (
+  `id` CHAR(36) NOT NULL,
+  `product_id` CHAR(36) NOT NULL,
+  `width` INT NOT NULL COMMENT 'Original width, already rotated',
+  `height` INT NOT NULL,
+  `content_type` VARCHAR(20) NOT NULL COMMENT 'Type of the uploaded file',
+  `renditions` JSON DEFAULT NULL COMMENT 'Size name -> width and height of the generated files',
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `fk_product_images_product` (`product_id`),
+  CONSTRAINT `fk_product_images_product` FOREIGN KEY (`product_id`) REFERENCES `products` (`id`) ON DELETE RESTRICT ON UPDATE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+
+-- Product variants table (SKU, option values and price/stock of each sellable combination)
+CREATE TABLE `product_variants` (
+  `id` CHAR(36) NOT NULL,
+  `product_id` CHAR(36) NOT NULL,
+  `sku` VARCHAR(100) DEFAULT NULL,
+  `options` JSON NOT NULL COMMENT 'Attribute slug -> value',
+  `option_key` VARCHAR(255) NOT NULL COMMENT 'Canonical form of options',
+  `price` INT DEFAULT NULL COMMENT 'Overrides the product price',
+  `barcode` VARCHAR(50) NOT NULL DEFAULT '',
+  `stock` INT NOT NULL DEFAULT 0,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_product_variants_option_key` (`product_id`, `option_key`),
+  CONSTRAINT `fk_product_variants_product` FOREIGN KEY (`product_id`) REFERENCES `products` (`id`) ON DELETE RESTRICT ON UPDATE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+
+-- Stock of each variant per product warehouse
+CREATE TABLE `product_variant_stocks` (
+  `id` CHAR(36) NOT NULL,
+  `variant_id` CHAR(36) NOT NULL,
+  `product_warehouse_id` CHAR(36) NOT NULL,
+  `quantity` INT NOT NULL DEFAULT 0,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_product_variant_stocks_variant` (`variant_id`, `product_warehouse_id`),
+  KEY `fk_product_variant_stocks_product_warehouse` (`product_warehouse_id`),
+  CONSTRAINT `fk_product_variant_stocks_variant` FOREIGN KEY (`variant_id`) REFERENCES `product_variants` (`id`) ON DELETE RESTRICT ON UPDATE RESTRICT,
+  CONSTRAINT `fk_product_variant_stocks_product_warehouse` FOREIGN KEY (`product_warehouse_id`) REFERENCES `product_warehouses` (`id`) ON DELETE RESTRICT ON UPDATE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+
+-- Stock held during checkout until it is confirmed, released or expires
+CREATE TABLE `stock_reservations` (
+  `id` CHAR(36) NOT NULL,
+  `product_id` CHAR(36) NOT NULL,
+  `variant_id` CHAR(36) DEFAULT NULL,
+  `product_warehouse_id` CHAR(36) DEFAULT NULL,
+  `holder_id` VARCHAR(64) NOT NULL COMMENT 'Visitor that holds the stock',
+  `holder_ip` VARCHAR(45) NOT NULL,
+  `quantity` INT NOT NULL,
+  `status` ENUM('active','confirmed','released') NOT NULL DEFAULT 'active',
+  `expires_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_stock_reservations_product` (`product_id`, `status`, `expires_at`),
+  KEY `idx_stock_reservations_variant_id` (`variant_id`),
+  KEY `idx_stock_reservations_holder` (`holder_id`),
+  KEY `idx_stock_reservations_holder_ip` (`holder_ip`),
+  CONSTRAINT `fk_stock_reservations_product` FOREIGN KEY (`product_id`) REFERENCES `products` (`id`) ON DELETE RESTRICT ON UPDATE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+
 -- Questions table
 CREATE TABLE `questions` (
   `id` CHAR(36) NOT NULL,
@@ -244,6 +317,126 @@ CREATE TABLE `review_votes` (
   CONSTRAINT `chk_review_votes_vote` CHECK (`vote` IN (1, -1))
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
 
+-- Search queries table (search analytics: top queries, zero results, CTR)
+CREATE TABLE `search_queries` (
+  `id` CHAR(36) NOT NULL,
+  `session_id` VARCHAR(64) NOT NULL,
+  `query` VARCHAR(255) NOT NULL,
+  `normalized_query` VARCHAR(255) NOT NULL,
+  `filters` JSON DEFAULT NULL,
+  `result_count` INT NOT NULL DEFAULT 0,
+  `clicked_product_id` CHAR(36) DEFAULT NULL,
+  `clicked_at` TIMESTAMP NULL DEFAULT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_search_queries_session` (`session_id`),
+  KEY `idx_search_queries_normalized` (`normalized_query`, `created_at`),
+  KEY `idx_search_queries_created` (`created_at`),
+  KEY `fk_search_queries_product` (`clicked_product_id`),
+  CONSTRAINT `fk_search_queries_product` FOREIGN KEY (`clicked_product_id`) REFERENCES `products` (`id`) ON DELETE SET NULL ON UPDATE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+
+-- Merchandising slots table (home page: hero, featured, deals, new arrivals)
+CREATE TABLE `merchandising_slots` (
+  `id` CHAR(36) NOT NULL,
+  `slug` VARCHAR(100) NOT NULL,
+  `title` VARCHAR(255) NOT NULL,
+  `strategy` ENUM('manual','rule','rotating') NOT NULL DEFAULT 'rule',
+  `product_ids` JSON COMMENT 'Manual strategy: ordered product IDs',
+  `rule` JSON COMMENT 'Rule and rotating strategies: filters and sort',
+  `pool_size` INT NOT NULL DEFAULT 100,
+  `rotate_minutes` INT NOT NULL DEFAULT 60,
+  `max_products` INT NOT NULL DEFAULT 10,
+  `position` INT NOT NULL DEFAULT 0,
+  `starts_at` TIMESTAMP NULL DEFAULT NULL,
+  `ends_at` TIMESTAMP NULL DEFAULT NULL,
+  `is_active` BOOLEAN NOT NULL DEFAULT TRUE,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_merchandising_slots_slug` (`slug`),
+  KEY `idx_merchandising_slots_position` (`position`),
+  KEY `idx_merchandising_slots_active` (`is_active`, `starts_at`, `ends_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+
+-- Product daily stats table (views and sales per day for trending scores and bestsellers)
+CREATE TABLE `product_daily_stats` (
+  `product_id` CHAR(36) NOT NULL,
+  `day` DATE NOT NULL,
+  `views` INT NOT NULL DEFAULT 0,
+  `sales` INT NOT NULL DEFAULT 0,
+  PRIMARY KEY (`product_id`, `day`),
+  KEY `idx_product_daily_stats_day` (`day`),
+  CONSTRAINT `fk_product_daily_stats_product` FOREIGN KEY (`product_id`) REFERENCES `products` (`id`) ON DELETE CASCADE ON UPDATE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+
+-- Product status history table (status changes of products and their questions and reviews)
+CREATE TABLE `product_status_history` (
+  `id` CHAR(36) NOT NULL,
+  `product_id` CHAR(36) NOT NULL,
+  `entity_type` ENUM('product','question','review') NOT NULL,
+  `entity_id` CHAR(36) NOT NULL,
+  `from_status` VARCHAR(30) DEFAULT NULL COMMENT 'Empty when the entity is created',
+  `to_status` VARCHAR(30) NOT NULL,
+  `actor_type` ENUM('seller','ia','moderator') NOT NULL,
+  `actor_id` CHAR(36) DEFAULT NULL,
+  `reason` TEXT,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_product_status_history_product` (`product_id`, `created_at`),
+  KEY `idx_product_status_history_entity` (`entity_id`),
+  CONSTRAINT `fk_product_status_history_product` FOREIGN KEY (`product_id`) REFERENCES `products` (`id`) ON DELETE CASCADE ON UPDATE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+
+-- Bulk product imports (CSV/XLSX); processed_rows is where an interrupted import resumes
+CREATE TABLE `product_imports` (
+  `id` CHAR(36) NOT NULL,
+  `user_id` CHAR(36) NOT NULL,
+  `file_name` VARCHAR(255) NOT NULL,
+  `format` ENUM('csv','xlsx') NOT NULL,
+  `language` VARCHAR(2) NOT NULL DEFAULT 'es',
+  `publish` BOOLEAN NOT NULL DEFAULT FALSE COMMENT 'New products go to wait_for_ia instead of draft',
+  `status` ENUM('pending','running','done','failed') NOT NULL DEFAULT 'pending',
+  `total_rows` INT NOT NULL DEFAULT 0,
+  `processed_rows` INT NOT NULL DEFAULT 0,
+  `created_count` INT NOT NULL DEFAULT 0,
+  `updated_count` INT NOT NULL DEFAULT 0,
+  `error_count` INT NOT NULL DEFAULT 0,
+  `last_error` TEXT,
+  `heartbeat_at` TIMESTAMP NULL DEFAULT NULL,
+  `started_at` TIMESTAMP NULL DEFAULT NULL,
+  `finished_at` TIMESTAMP NULL DEFAULT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_product_imports_user` (`user_id`, `created_at`),
+  KEY `idx_product_imports_status` (`status`, `created_at`),
+  CONSTRAINT `fk_product_imports_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+
+-- Per-row error report of an import (row is the sheet row, the header is row 1)
+CREATE TABLE `product_import_errors` (
+  `id` CHAR(36) NOT NULL,
+  `import_id` CHAR(36) NOT NULL,
+  `row` INT NOT NULL,
+  `field` VARCHAR(100) DEFAULT NULL,
+  `message` TEXT NOT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_product_import_errors_import` (`import_id`, `row`),
+  CONSTRAINT `fk_product_import_errors_import` FOREIGN KEY (`import_id`) REFERENCES `product_imports` (`id`) ON DELETE CASCADE ON UPDATE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+
+-- Generated entries of the catalog feeds (Google Merchant XML and Meta CSV), one per active product
+CREATE TABLE `catalog_feed_items` (
+  `product_id` CHAR(36) NOT NULL,
+  `google_item` MEDIUMTEXT NOT NULL COMMENT '<item> of the Google Merchant feed',
+  `meta_row` MEDIUMTEXT NOT NULL COMMENT 'Row of the Meta CSV feed',
+  `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`product_id`),
+  CONSTRAINT `fk_catalog_feed_items_product` FOREIGN KEY (`product_id`) REFERENCES `products` (`id`) ON DELETE CASCADE ON UPDATE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+
 -- Add full-text search indexes for product search
 -- Primary optimized search index using AI-generated content
 CREATE FULLTEXT INDEX `idx_products_search_optimized` ON `products` (`search_content`, `search_keywords`);
@@ -0,0 +1,14 @@
+-- Upgrade of databases created with an earlier scheme.sql. Run it once; new installs only need scheme.sql.
+-- New tables (product_images, product_variants, product_variant_stocks, stock_reservations, ...) are created with
+-- their CREATE TABLE statements from scheme.sql.
+
+-- Seller reference used by the bulk import to update products
+ALTER TABLE `products`
+  ADD COLUMN `sku` VARCHAR(100) DEFAULT NULL COMMENT 'Seller reference, unique per seller' AFTER `user_id`,
+  ADD UNIQUE KEY `idx_products_user_sku` (`user_id`, `sku`);
+
+-- Trending score job
+ALTER TABLE `products`
+  ADD COLUMN `trending_score` DOUBLE NOT NULL DEFAULT 0 COMMENT 'Time-decayed popularity from views, sales and reviews' AFTER `from_company`,
+  ADD COLUMN `sold_snapshot` INT DEFAULT NULL COMMENT 'Sold already counted in product_daily_stats' AFTER `trending_score`,
+  ADD KEY `idx_products_trending` (`trending_score`, `created_at`);